@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/rivsidn/kdev_bootstrap/pkg/audit"
+	"github.com/spf13/cobra"
+)
+
+var (
+	auditUser   string
+	auditKinds  []string
+	auditSince  string
+	auditUntil  string
+	auditPID    int
+	auditFailed bool
+	auditText   string
+	auditLast   int
+)
+
+var auditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "Inspect the audit log of privileged operations",
+}
+
+var auditShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Show audit log records matching the filters",
+	Long: `Show prints the records of the audit log that match all given filters,
+oldest first. Every kboot command running as root appends one JSON line per
+external command, mount, umount, losetup and deletion, with the invoking user
+(SUDO_USER), working directory, arguments and exit status.
+
+The columns are time, user, kboot PID, kind, exit status and the command line
+(or the deleted path). --output-format json returns the complete records.
+
+--since and --until take a duration back from now (24h, 30m), a date
+(2006-01-02) or a local time (2006-01-02 15:04:05, or RFC 3339).`,
+	Args: cobra.NoArgs,
+	RunE: runAuditShow,
+}
+
+func init() {
+	auditShowCmd.Flags().StringVarP(&auditUser, "user", "u", "", "Only records of this invoking user")
+	auditShowCmd.Flags().StringSliceVarP(&auditKinds, "kind", "k", nil, "Only records of these kinds: exec, mount, umount, losetup, delete (repeatable or comma separated)")
+	auditShowCmd.Flags().StringVar(&auditSince, "since", "", "Only records at or after this time")
+	auditShowCmd.Flags().StringVar(&auditUntil, "until", "", "Only records before this time")
+	auditShowCmd.Flags().IntVar(&auditPID, "pid", 0, "Only records of this kboot process")
+	auditShowCmd.Flags().BoolVar(&auditFailed, "failed", false, "Only failed operations (exit status not 0)")
+	auditShowCmd.Flags().StringVar(&auditText, "grep", "", "Only records whose command line, path, working directory or kboot command contains TEXT")
+	auditShowCmd.Flags().IntVarP(&auditLast, "last", "n", 0, "Only the last N matching records")
+
+	auditCmd.AddCommand(auditShowCmd)
+	rootCmd.AddCommand(auditCmd)
+}
+
+func runAuditShow(cmd *cobra.Command, args []string) error {
+	if err := audit.CheckKinds(auditKinds); err != nil {
+		return err
+	}
+	filter := &audit.Filter{User: auditUser, Kinds: auditKinds, PID: auditPID, Failed: auditFailed, Text: auditText}
+	var err error
+	if filter.Since, err = parseAuditTime(auditSince); err != nil {
+		return fmt.Errorf("invalid --since: %v", err)
+	}
+	if filter.Until, err = parseAuditTime(auditUntil); err != nil {
+		return fmt.Errorf("invalid --until: %v", err)
+	}
+
+	records, err := audit.Read(audit.Path(), filter)
+	if err != nil {
+		return err
+	}
+	if auditLast > 0 && len(records) > auditLast {
+		records = records[len(records)-auditLast:]
+	}
+
+	res.Data = append([]*audit.Record{}, records...)
+	if len(records) == 0 {
+		fmt.Println("No matching audit records")
+		return nil
+	}
+	for _, r := range records {
+		fmt.Printf("%s %-10s %-7d %-7s %3d  %s\n", r.Time.Local().Format("2006-01-02 15:04:05"), r.User, r.PID, r.Kind, r.ExitStatus, r.CommandLine())
+	}
+	return nil
+}
+
+// parseAuditTime 解析 --since、--until：距现在的时长、日期或本地时间，空字符串返回零值
+func parseAuditTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%s is neither a duration, a date nor a time", value)
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/rivsidn/kdev_bootstrap/pkg/audit"
+	"github.com/rivsidn/kdev_bootstrap/pkg/bootfs"
+	"github.com/rivsidn/kdev_bootstrap/pkg/lock"
+	"github.com/rivsidn/kdev_bootstrap/pkg/result"
+	"github.com/rivsidn/kdev_bootstrap/pkg/utils"
+	"github.com/spf13/cobra"
+)
+
+var (
+	exportBootfsPath  string
+	exportOutput      string
+	exportCompression string
+	importOutput      string
+)
+
+var bootfsCmd = &cobra.Command{
+	Use:   "bootfs",
+	Short: "Operate on built bootfs environments",
+}
+
+var bootfsExportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export bootfs as a compressed tarball",
+	Long: `Export packs a bootfs directory into a tarball (zstd, xz or gz).
+
+Ownership, device nodes, hardlinks, xattrs and file capabilities are preserved,
+and /etc/bootstrap.conf is embedded so that kboot_build_docker and
+kboot_build_qemu can use the tarball directly as --bootfs input. The bootfs
+credentials file, if any, is copied next to the tarball.`,
+	RunE: runBootfsExport,
+}
+
+var bootfsImportCmd = &cobra.Command{
+	Use:   "import ARCHIVE",
+	Short: "Import bootfs from a tarball",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runBootfsImport,
+}
+
+func init() {
+	bootfsExportCmd.Flags().StringVarP(&exportBootfsPath, "bootfs", "b", "", "Root filesystem path (required)")
+	bootfsExportCmd.Flags().StringVarP(&exportOutput, "output", "o", "", "Output tarball path (default: <bootfs>.tar.zst)")
+	bootfsExportCmd.Flags().StringVarP(&exportCompression, "compression", "c", "", "Compression: zstd, xz, gz, none (default: from output suffix, otherwise zstd)")
+	bootfsExportCmd.MarkFlagRequired("bootfs")
+
+	bootfsImportCmd.Flags().StringVarP(&importOutput, "output", "o", "", "Output directory (default: archive name without suffix)")
+
+	bootfsCmd.AddCommand(bootfsExportCmd, bootfsImportCmd)
+	rootCmd.AddCommand(bootfsCmd)
+}
+
+func runBootfsExport(cmd *cobra.Command, args []string) error {
+	if !utils.CheckRoot() {
+		return fmt.Errorf("please run with sudo or root privileges")
+	}
+
+	compression, err := resolveCompression(exportOutput, exportCompression)
+	if err != nil {
+		return err
+	}
+
+	output := exportOutput
+	if output == "" {
+		output = filepath.Clean(exportBootfsPath) + compression.Suffix()
+	}
+
+	sourceLock, err := lock.AcquireShared(exportBootfsPath)
+	if err != nil {
+		return err
+	}
+	defer sourceLock.Release()
+	outputLock, err := lock.Acquire(output)
+	if err != nil {
+		return err
+	}
+	defer outputLock.Release()
+
+	if utils.FileExists(output) {
+		fmt.Printf("File %s already exists\n", output)
+		if !utils.Confirm("Overwrite?") {
+			return fmt.Errorf("operation cancelled by user")
+		}
+	}
+
+	if err := bootfs.Export(cmd.Context(), exportBootfsPath, output, compression); err != nil {
+		return err
+	}
+
+	res.AddPath(result.ArtifactArchive, output)
+	fmt.Printf("\nBootfs export successful: %s\n", output)
+	return nil
+}
+
+// resolveCompression 根据参数或输出文件后缀确定压缩格式
+//
+// 导入时根据后缀判断压缩格式，同时指定时两者必须一致。
+func resolveCompression(output, name string) (bootfs.Compression, error) {
+	if output == "" {
+		return bootfs.ParseCompression(name)
+	}
+
+	detected, ok := bootfs.DetectCompression(output)
+	if !ok {
+		return "", fmt.Errorf("unrecognized output suffix: %s (use .tar.zst, .tar.xz, .tar.gz or .tar)", output)
+	}
+	if name == "" {
+		return detected, nil
+	}
+	compression, err := bootfs.ParseCompression(name)
+	if err != nil {
+		return "", err
+	}
+	if compression != detected {
+		return "", fmt.Errorf("--compression %s does not match the output suffix of %s (use %s)", name, output, compression.Suffix())
+	}
+	return compression, nil
+}
+
+func runBootfsImport(cmd *cobra.Command, args []string) error {
+	archive := args[0]
+
+	if !utils.CheckRoot() {
+		return fmt.Errorf("please run with sudo or root privileges")
+	}
+	if !bootfs.IsArchive(archive) {
+		return fmt.Errorf("not a bootfs archive: %s", archive)
+	}
+
+	output := importOutput
+	if output == "" {
+		compression, _ := bootfs.DetectCompression(archive)
+		output = strings.TrimSuffix(archive, compression.Suffix())
+		if output == archive {
+			output = strings.TrimSuffix(archive, filepath.Ext(archive))
+		}
+	}
+
+	outputLock, err := lock.Acquire(output)
+	if err != nil {
+		return err
+	}
+	defer outputLock.Release()
+
+	if utils.DirExists(output) {
+		fmt.Printf("Directory %s already exists\n", output)
+		if !utils.Confirm("Delete and recreate?") {
+			return fmt.Errorf("operation cancelled by user")
+		}
+		if err := audit.RemoveAll(output); err != nil {
+			return fmt.Errorf("failed to remove directory: %v", err)
+		}
+	}
+
+	if err := bootfs.Import(cmd.Context(), archive, output); err != nil {
+		return err
+	}
+
+	res.AddPath(result.ArtifactBootfs, output)
+	fmt.Printf("\nBootfs import successful: %s\n", output)
+	return nil
+}
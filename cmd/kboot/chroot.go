@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/rivsidn/kdev_bootstrap/pkg/bootfs"
+	"github.com/rivsidn/kdev_bootstrap/pkg/lock"
+	"github.com/rivsidn/kdev_bootstrap/pkg/utils"
+	"github.com/spf13/cobra"
+)
+
+var chrootAllowDaemons bool
+
+var chrootCmd = &cobra.Command{
+	Use:   "chroot BOOTFS [-- COMMAND [ARG...]]",
+	Short: "Open a shell or run a command inside a bootfs",
+	Long: `Chroot mounts proc, sys, dev and dev/pts into the bootfs, copies the host
+resolv.conf for network access and blocks daemons from starting (policy-rc.d),
+then runs an interactive shell with a "(kboot:NAME)" prompt, or the given
+command. Everything is undone when the shell or command exits, including
+when kboot is interrupted with SIGINT, SIGTERM or SIGHUP.
+
+The exit status is that of the shell or command.`,
+	Example: `  sudo kboot chroot ./ubuntu-16.04-amd64-bootfs
+  sudo kboot chroot ./ubuntu-16.04-amd64-bootfs -- dpkg -l`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: runChroot,
+}
+
+func init() {
+	chrootCmd.Flags().BoolVar(&chrootAllowDaemons, "allow-daemons", false, "Do not block services from starting inside the chroot")
+
+	rootCmd.AddCommand(chrootCmd)
+}
+
+func runChroot(cmd *cobra.Command, args []string) (err error) {
+	root := args[0]
+	command := args[1:]
+
+	if !utils.CheckRoot() {
+		return fmt.Errorf("please run with sudo or root privileges")
+	}
+	if bootfs.IsArchive(root) {
+		return fmt.Errorf("%s is an archive, import it with kboot bootfs import first", root)
+	}
+	if !utils.DirExists(root) {
+		return fmt.Errorf("bootfs directory does not exist: %s", root)
+	}
+
+	bootfsLock, err := lock.Acquire(root)
+	if err != nil {
+		return err
+	}
+	defer bootfsLock.Release()
+
+	// 挂载期间收到信号时先完成准备，之后卸载并退出
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(signals)
+
+	chroot, err := bootfs.NewChroot(root)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := chroot.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+		// 卸载失败时提示残留的挂载点
+		if left := mountsUnder(chroot.Root); len(left) > 0 {
+			fmt.Fprintf(os.Stderr, "Warning: still mounted: %s\n", strings.Join(left, ", "))
+		}
+	}()
+
+	if err := chroot.Mount(cmd.Context()); err != nil {
+		return err
+	}
+	if !chrootAllowDaemons {
+		if err := chroot.BlockDaemons(cmd.Context()); err != nil {
+			return err
+		}
+	}
+	if err := chroot.UseHostResolver(cmd.Context()); err != nil {
+		return err
+	}
+
+	select {
+	case sig := <-signals:
+		return fmt.Errorf("interrupted by %v", sig)
+	default:
+	}
+
+	name := filepath.Base(chroot.Root)
+	env := []string{"TERM=" + os.Getenv("TERM"), "debian_chroot=kboot:" + name}
+	if len(command) == 0 {
+		shell := "/bin/sh"
+		if _, err := os.Stat(filepath.Join(chroot.Root, "bin", "bash")); err == nil {
+			shell = "/bin/bash"
+		}
+		env = append(env, `PS1=(kboot:`+name+`) \u@\h:\w\$ `)
+		fmt.Printf("Entering %s, exit the shell to unmount\n", chroot.Root)
+		command = []string{shell, "-i"}
+	}
+
+	code, err := chroot.Interactive(env, command[0], command[1:]...)
+	if err != nil {
+		return err
+	}
+	if code != 0 {
+		return exitStatus(code)
+	}
+	return nil
+}
+
+// mountsUnder 返回 root 下的挂载点
+func mountsUnder(root string) []string {
+	var mounts []string
+	for _, mountPoint := range utils.MountPoints() {
+		if strings.HasPrefix(mountPoint, root+"/") {
+			mounts = append(mounts, mountPoint)
+		}
+	}
+	return mounts
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/rivsidn/kdev_bootstrap/pkg/bootfs"
+	"github.com/spf13/cobra"
+)
+
+var diffPaths []string
+
+var bootfsDiffCmd = &cobra.Command{
+	Use:   "diff OLD NEW",
+	Short: "Show differences between two bootfs environments",
+	Long: `Diff compares two bootfs environments, each given as a bootfs directory,
+an exported tarball or a build manifest (*.manifest.json).
+
+It reports added, removed and changed packages, differences in
+/etc/bootstrap.conf and, with --path, file-level differences (type, mode,
+owner, size and content) under the given directories.
+
+Exit status is 0 if the environments are the same, 1 if they differ
+and 2 on error.`,
+	Args: cobra.ExactArgs(2),
+	RunE: runBootfsDiff,
+}
+
+func init() {
+	bootfsDiffCmd.Flags().StringSliceVarP(&diffPaths, "path", "p", nil, "Compare files under these directories, e.g. /usr/include,/etc")
+
+	bootfsCmd.AddCommand(bootfsDiffCmd)
+}
+
+func runBootfsDiff(cmd *cobra.Command, args []string) error {
+	d, err := diffBootfs(cmd.Context(), args[0], args[1])
+	if err != nil {
+		return &failStatus{err: err, code: 2}
+	}
+
+	res.Data = d
+	printDiff(d)
+	if !d.Empty() {
+		return exitStatus(1)
+	}
+	return nil
+}
+
+// diffBootfs 打开并比较两个 bootfs
+func diffBootfs(ctx context.Context, oldPath, newPath string) (*bootfs.Diff, error) {
+	needFiles := len(diffPaths) > 0
+
+	a, err := bootfs.OpenSnapshot(ctx, oldPath, needFiles)
+	if err != nil {
+		return nil, err
+	}
+	defer a.Close()
+
+	b, err := bootfs.OpenSnapshot(ctx, newPath, needFiles)
+	if err != nil {
+		return nil, err
+	}
+	defer b.Close()
+
+	return bootfs.Compare(a, b, diffPaths)
+}
+
+// printDiff 输出差异，"+" 表示新增，"-" 表示删除，"~" 表示修改
+func printDiff(d *bootfs.Diff) {
+	if d.Empty() {
+		fmt.Println("No differences")
+		return
+	}
+
+	if len(d.Packages) > 0 {
+		fmt.Printf("Packages (%d):\n", len(d.Packages))
+		for _, p := range d.Packages {
+			switch {
+			case p.OldVersion == "":
+				fmt.Printf("  + %s:%s %s\n", p.Name, p.Architecture, p.NewVersion)
+			case p.NewVersion == "":
+				fmt.Printf("  - %s:%s %s\n", p.Name, p.Architecture, p.OldVersion)
+			default:
+				fmt.Printf("  ~ %s:%s %s -> %s\n", p.Name, p.Architecture, p.OldVersion, p.NewVersion)
+			}
+		}
+	}
+
+	if len(d.Config) > 0 {
+		fmt.Printf("Config (%d):\n", len(d.Config))
+		for _, c := range d.Config {
+			switch {
+			case c.Old == "":
+				fmt.Printf("  + [%s] %s = %s\n", c.Section, c.Key, c.New)
+			case c.New == "":
+				fmt.Printf("  - [%s] %s = %s\n", c.Section, c.Key, c.Old)
+			default:
+				fmt.Printf("  ~ [%s] %s: %s -> %s\n", c.Section, c.Key, c.Old, c.New)
+			}
+		}
+	}
+
+	if len(d.Files) > 0 {
+		fmt.Printf("Files (%d):\n", len(d.Files))
+		for _, f := range d.Files {
+			switch f.Change {
+			case "added":
+				fmt.Printf("  + %s\n", f.Path)
+			case "removed":
+				fmt.Printf("  - %s\n", f.Path)
+			default:
+				fmt.Printf("  ~ %s (%s)\n", f.Path, f.Detail)
+			}
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/rivsidn/kdev_bootstrap/pkg/cleanup"
+	"github.com/rivsidn/kdev_bootstrap/pkg/config"
+	"github.com/rivsidn/kdev_bootstrap/pkg/executor"
+	"github.com/rivsidn/kdev_bootstrap/pkg/preflight"
+	"github.com/rivsidn/kdev_bootstrap/pkg/utils"
+	"github.com/spf13/cobra"
+)
+
+var (
+	doctorCleanup bool
+	doctorConfig  string
+	doctorArch    string
+	doctorOutput  string
+)
+
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Check the host and find resources left behind by interrupted kboot runs",
+	Long: `Doctor first checks whether the host can run the builders and prints a
+pass/fail table with hints:
+
+  debootstrap  installed, version, script of the configured suite
+  keyring      keyring used by debootstrap for the suite
+  binfmt       handler for the target architecture if it is not native
+  qemu-img     installed
+  mkfs         mkfs.ext3 (used for images) and mkfs.ext4
+  loop         losetup and a free loop device (as root)
+  rsync        installed, otherwise images are copied with cp
+  docker       daemon is running and accessible
+  kvm          /dev/kvm is accessible
+  bridge/tap   /dev/net/tun, qemu-bridge-helper and /etc/qemu/bridge.conf
+  disk         free space in the output directory versus the estimated need
+
+The builders run the checks they need before building. With --file the
+suite and architecture of the configuration are checked.
+
+Doctor then lists what kboot runs that crashed or were killed left behind:
+mounts (chroot proc/sys/dev, image mount points), loop devices, temporary
+directories and files replaced inside a bootfs (policy-rc.d, resolv.conf).
+
+Every kboot process records its undo actions in ` + cleanup.JournalDir + `;
+records of processes that are no longer running are leftovers. Mount points
+of older kboot versions (/tmp/qemu-mount-PID) are found as well.
+
+With --cleanup only the leftovers are released, in reverse order. Exit status
+is 1 if a check failed or leftovers remain.`,
+	Args: cobra.NoArgs,
+	RunE: runDoctor,
+}
+
+func init() {
+	doctorCmd.Flags().BoolVar(&doctorCleanup, "cleanup", false, "Release the leftovers")
+	doctorCmd.Flags().StringVarP(&doctorConfig, "file", "f", "", "Configuration file whose suite and architecture are checked")
+	doctorCmd.Flags().StringVarP(&doctorArch, "arch", "a", "", "Target architecture (default: arch_current of the configuration)")
+	doctorCmd.Flags().StringVarP(&doctorOutput, "output", "o", ".", "Output directory whose free space is checked")
+
+	rootCmd.AddCommand(doctorCmd)
+}
+
+// doctorResult 主机检查结果及发现的残留资源，--cleanup 时不检查主机
+type doctorResult struct {
+	Checks    preflight.Report   `json:"checks,omitempty"`
+	Leftovers []*cleanup.Journal `json:"leftovers"`
+	Untracked []cleanup.Action   `json:"untracked"`
+}
+
+func runDoctor(cmd *cobra.Command, args []string) error {
+	if doctorCleanup && !utils.CheckRoot() {
+		return fmt.Errorf("please run with sudo or root privileges")
+	}
+
+	data := &doctorResult{Leftovers: []*cleanup.Journal{}, Untracked: []cleanup.Action{}}
+	res.Data = data
+
+	checkFailed := false
+	if !doctorCleanup {
+		opts, err := doctorOptions()
+		if err != nil {
+			return err
+		}
+		report := preflight.Run(cmd.Context(), preflight.AllChecks, opts)
+		report.Print(os.Stdout)
+		fmt.Println()
+		checkFailed = report.Failed()
+		data.Checks = report
+	}
+
+	journals, err := cleanup.Leftovers()
+	if err != nil {
+		return err
+	}
+	untracked, err := cleanup.Untracked()
+	if err != nil {
+		return err
+	}
+	data.Leftovers = append(data.Leftovers, journals...)
+	data.Untracked = append(data.Untracked, untracked...)
+
+	if len(journals) == 0 && len(untracked) == 0 {
+		fmt.Println("No leftovers from interrupted runs")
+		if checkFailed {
+			return exitStatus(1)
+		}
+		return nil
+	}
+
+	failed := 0
+	report := func(a cleanup.Action, err error) {
+		if err != nil {
+			failed++
+			fmt.Printf("   FAILED   %s: %v\n", a, err)
+		} else {
+			fmt.Printf("   released %s\n", a)
+		}
+	}
+
+	for _, j := range journals {
+		fmt.Printf("PID %d, started %s: %s\n", j.PID, j.Start.Local().Format("2006-01-02 15:04:05"), j.Command)
+		if !doctorCleanup {
+			for i := len(j.Actions) - 1; i >= 0; i-- {
+				fmt.Printf("   %s\n", j.Actions[i])
+			}
+			continue
+		}
+		// 失败的操作保留在记录中，错误通过 report 输出
+		j.Cleanup(cmd.Context(), report)
+	}
+
+	if len(untracked) > 0 {
+		fmt.Println("Untracked mounts:")
+		for _, a := range untracked {
+			if !doctorCleanup {
+				fmt.Printf("   %s\n", a)
+				continue
+			}
+			report(a, a.Undo(cmd.Context(), executor.Default))
+		}
+	}
+
+	if !doctorCleanup {
+		fmt.Println("\nRun 'sudo kboot doctor --cleanup' to release them")
+		return exitStatus(1)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d cleanup action(s) failed", failed)
+	}
+	fmt.Println("\nAll leftovers released")
+	return nil
+}
+
+// doctorOptions 返回主机检查的目标，指定配置文件时检查其 suite 及架构
+func doctorOptions() (preflight.Options, error) {
+	opts := preflight.Options{Arch: doctorArch, Dir: doctorOutput, Need: preflight.BootfsEstimate}
+	if doctorConfig == "" {
+		return opts, nil
+	}
+
+	cfg, err := config.LoadConfig(doctorConfig)
+	if err != nil {
+		return opts, err
+	}
+	opts.Suite = cfg.GetSuite()
+	opts.NoCheckGPG = strings.HasPrefix(cfg.Version, "5.")
+	if opts.Arch == "" {
+		opts.Arch = cfg.ArchCurrent
+	}
+	return opts, nil
+}
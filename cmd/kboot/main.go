@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/rivsidn/kdev_bootstrap/pkg/audit"
+	"github.com/rivsidn/kdev_bootstrap/pkg/cleanup"
+	"github.com/rivsidn/kdev_bootstrap/pkg/diagnose"
+	"github.com/rivsidn/kdev_bootstrap/pkg/logging"
+	"github.com/rivsidn/kdev_bootstrap/pkg/result"
+	"github.com/spf13/cobra"
+)
+
+var rootCmd = &cobra.Command{
+	Use:   "kboot",
+	Short: "Manage kernel debugging environments",
+	Long: `kboot collects the maintenance commands for bootfs (root filesystem)
+environments built by kboot_build_bootfs.`,
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		res = result.New(cmd.CommandPath())
+		audit.SetPath(auditLog)
+		if err := result.CheckFormat(outputFormat); err != nil {
+			return err
+		}
+		if outputFormat == result.FormatJSON {
+			if cmd == chrootCmd {
+				return fmt.Errorf("kboot chroot is interactive and does not support --output-format json")
+			}
+			stdout = result.RedirectStdout()
+			logging.Setup(logging.Options{})
+		}
+
+		// kboot chroot 将信号转发给 chroot 中的 shell，退出后自行清理
+		if cmd != chrootCmd {
+			cleanup.HandleSignals(cancelRun)
+		}
+		return nil
+	},
+}
+
+var (
+	outputFormat string
+	auditLog     string
+
+	// stdout 写入结果的标准输出，json 格式时 os.Stdout 指向标准错误
+	stdout = os.Stdout
+	// res 命令的结果，子命令记录产物及命令特有的结果
+	res = result.New("kboot")
+)
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&auditLog, "audit-log", "", "Audit log of executed commands, mounts, loop devices and deletions (JSON lines, default: $KBOOT_AUDIT_LOG or "+audit.DefaultPath+")")
+	rootCmd.PersistentFlags().StringVar(&outputFormat, "output-format", result.FormatText, "Final result format: text, or json (a single result object on stdout, everything else on stderr)")
+}
+
+// cancelRun 取消命令的 context，收到 SIGINT、SIGTERM 时终止正在执行的外部命令
+var cancelRun context.CancelFunc = func() {}
+
+// exitStatus 只设置进程退出码，不打印错误信息（如 diff 发现差异时退出码为 1）
+type exitStatus int
+
+func (e exitStatus) Error() string {
+	return fmt.Sprintf("exit status %d", int(e))
+}
+
+// failStatus 打印错误信息并以指定的退出码退出（如 diff 出错时退出码为 2）
+type failStatus struct {
+	err  error
+	code int
+}
+
+func (e *failStatus) Error() string {
+	return e.err.Error()
+}
+
+func (e *failStatus) Unwrap() error {
+	return e.err
+}
+
+func main() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cancelRun = cancel
+
+	err := rootCmd.ExecuteContext(ctx)
+	code := 0
+	var status exitStatus
+	var fail *failStatus
+	switch {
+	case err == nil:
+	case errors.As(err, &status):
+		// 命令正常完成，结果通过退出码表示
+		err, code = nil, cleanup.ExitCode(int(status))
+	default:
+		code = 1
+		if errors.As(err, &fail) {
+			code = fail.code
+		}
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		if known := diagnose.Find(err); known != nil {
+			fmt.Fprintln(os.Stderr, known.Explain())
+		}
+		for _, err := range cleanup.RunAll() {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		}
+		code = cleanup.ExitCode(code)
+	}
+
+	if outputFormat == result.FormatJSON {
+		res.Finish(err, code)
+		if err := res.Write(stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		}
+	}
+	if code != 0 {
+		os.Exit(code)
+	}
+}
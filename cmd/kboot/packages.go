@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rivsidn/kdev_bootstrap/pkg/bootfs"
+	"github.com/rivsidn/kdev_bootstrap/pkg/manifest"
+	"github.com/rivsidn/kdev_bootstrap/pkg/result"
+	"github.com/rivsidn/kdev_bootstrap/pkg/utils"
+	"github.com/spf13/cobra"
+)
+
+var (
+	packagesBootfsPath string
+	installKey         string
+	removePurge        bool
+)
+
+var bootfsInstallCmd = &cobra.Command{
+	Use:   "install PACKAGE...",
+	Short: "Install packages into an existing bootfs",
+	Long: `Install runs apt-get inside a chroot of the bootfs (with proc, sys, dev
+mounted and daemons blocked), then appends the packages to a *_packages entry
+of /etc/bootstrap.conf and refreshes the build manifest, so that Docker and
+QEMU images rebuilt afterwards include them.`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: runBootfsPackages(bootfs.OperationInstall),
+}
+
+var bootfsRemoveCmd = &cobra.Command{
+	Use:   "remove PACKAGE...",
+	Short: "Remove packages from an existing bootfs",
+	Long: `Remove runs apt-get remove inside a chroot of the bootfs, then drops the
+packages from the *_packages entries of /etc/bootstrap.conf and refreshes
+the build manifest.`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: runBootfsPackages(bootfs.OperationRemove),
+}
+
+func init() {
+	for _, cmd := range []*cobra.Command{bootfsInstallCmd, bootfsRemoveCmd} {
+		cmd.Flags().StringVarP(&packagesBootfsPath, "bootfs", "b", "", "Root filesystem path (required)")
+		cmd.MarkFlagRequired("bootfs")
+	}
+	bootfsInstallCmd.Flags().StringVar(&installKey, "key", bootfs.DefaultPackagesKey, "bootstrap.conf entry to record the packages in")
+	bootfsRemoveCmd.Flags().BoolVar(&removePurge, "purge", false, "Also remove configuration files")
+
+	bootfsCmd.AddCommand(bootfsInstallCmd, bootfsRemoveCmd)
+}
+
+// packagesResult install、remove 的结果
+type packagesResult struct {
+	Operation string   `json:"operation"`
+	Packages  []string `json:"packages"`
+}
+
+func runBootfsPackages(operation string) func(cmd *cobra.Command, args []string) error {
+	return func(cmd *cobra.Command, args []string) error {
+		if !utils.CheckRoot() {
+			return fmt.Errorf("please run with sudo or root privileges")
+		}
+		if bootfs.IsArchive(packagesBootfsPath) {
+			return fmt.Errorf("%s is an archive, import it with kboot bootfs import first", packagesBootfsPath)
+		}
+		if !utils.DirExists(packagesBootfsPath) {
+			return fmt.Errorf("bootfs directory does not exist: %s", packagesBootfsPath)
+		}
+
+		logPath := packagesBootfsPath + ".packages.log"
+		logFile, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return fmt.Errorf("failed to open log file: %v", err)
+		}
+		defer logFile.Close()
+		res.AddPath(result.ArtifactLog, logPath)
+		res.Data = packagesResult{Operation: operation, Packages: args}
+
+		opts := bootfs.PackageOptions{Key: installKey, Purge: removePurge, Log: logFile}
+		if operation == bootfs.OperationInstall {
+			err = bootfs.InstallPackages(cmd.Context(), packagesBootfsPath, args, opts)
+		} else {
+			err = bootfs.RemovePackages(cmd.Context(), packagesBootfsPath, args, opts)
+		}
+		if err != nil {
+			return fmt.Errorf("%s failed (see %s): %v", operation, logPath, err)
+		}
+
+		res.AddPath(result.ArtifactConfig, filepath.Join(packagesBootfsPath, "etc/bootstrap.conf"))
+		res.AddPath(result.ArtifactManifest, manifest.SidecarPath(packagesBootfsPath))
+		if operation == bootfs.OperationInstall {
+			fmt.Printf("\nPackages installed: %s\n", strings.Join(args, ", "))
+		} else {
+			fmt.Printf("\nPackages removed: %s\n", strings.Join(args, ", "))
+		}
+		fmt.Printf("Updated: %s/etc/bootstrap.conf, %s\n", packagesBootfsPath, manifest.SidecarPath(packagesBootfsPath))
+		fmt.Println("Rebuild Docker or QEMU images to pick up the change")
+		return nil
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rivsidn/kdev_bootstrap/pkg/bootfs"
+	"github.com/rivsidn/kdev_bootstrap/pkg/executor"
+	"github.com/rivsidn/kdev_bootstrap/pkg/result"
+	"github.com/rivsidn/kdev_bootstrap/pkg/sbom"
+	"github.com/rivsidn/kdev_bootstrap/pkg/utils"
+	"github.com/spf13/cobra"
+)
+
+var (
+	sbomFormat string
+	sbomOutput string
+)
+
+var sbomCmd = &cobra.Command{
+	Use:   "sbom BOOTFS|IMAGE",
+	Short: "Generate SPDX and CycloneDX SBOMs",
+	Long: `Sbom lists the packages installed in a bootfs directory, bootfs tarball
+or QEMU rootfs image, using the dpkg database and the per-package copyright
+files, and writes them as SPDX 2.3 and CycloneDX 1.5 JSON documents.
+
+By default the documents are written next to the input as
+<input>.spdx.json and <input>.cdx.json. Use "-o -" to print a single
+format to stdout.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runSBOM,
+}
+
+func init() {
+	sbomCmd.Flags().StringVarP(&sbomFormat, "format", "f", "all", "SBOM format: spdx, cyclonedx, all")
+	sbomCmd.Flags().StringVarP(&sbomOutput, "output", "o", "", "Output file prefix, or - for stdout (default: input path)")
+
+	rootCmd.AddCommand(sbomCmd)
+}
+
+func runSBOM(cmd *cobra.Command, args []string) error {
+	input := filepath.Clean(args[0])
+
+	formats, err := sbom.ParseFormats(sbomFormat)
+	if err != nil {
+		return err
+	}
+	if sbomOutput == "-" && len(formats) != 1 {
+		return fmt.Errorf("writing to stdout requires a single --format")
+	}
+	if sbomOutput == "-" && outputFormat == result.FormatJSON {
+		return fmt.Errorf("--output-format json writes the result to stdout, use -o FILE")
+	}
+
+	root, name, cleanup, err := openSBOMInput(cmd.Context(), input)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	doc, err := sbom.Collect(root, name)
+	if err != nil {
+		return err
+	}
+
+	if sbomOutput == "-" {
+		return doc.Write(os.Stdout, formats[0])
+	}
+
+	prefix := sbomOutput
+	if prefix == "" {
+		prefix = input
+		if compression, ok := bootfs.DetectCompression(input); ok && bootfs.IsArchive(input) {
+			prefix = strings.TrimSuffix(input, compression.Suffix())
+		}
+	}
+
+	files, err := doc.Save(prefix, formats)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("SBOM with %d packages written to:\n", len(doc.Packages))
+	for _, file := range files {
+		res.AddPath(result.ArtifactSBOM, file)
+		fmt.Printf("   %s\n", file)
+	}
+	return nil
+}
+
+// openSBOMInput 返回 bootfs 目录、SBOM 名称及清理函数，归档会被解压，镜像以只读方式挂载
+func openSBOMInput(ctx context.Context, input string) (string, string, func(), error) {
+	name := filepath.Base(input)
+	if name == "/" {
+		name = "rootfs"
+	}
+
+	if utils.DirExists(input) {
+		return input, name, func() {}, nil
+	}
+	if !utils.FileExists(input) {
+		return "", "", nil, fmt.Errorf("bootfs or image does not exist: %s", input)
+	}
+	if !utils.CheckRoot() {
+		return "", "", nil, fmt.Errorf("please run with sudo or root privileges")
+	}
+
+	if bootfs.IsArchive(input) {
+		compression, _ := bootfs.DetectCompression(input)
+		fmt.Fprintf(os.Stderr, "Extracting bootfs archive: %s\n", input)
+		dir, remove, err := bootfs.ImportTemp(ctx, executor.Default, input)
+		if err != nil {
+			return "", "", nil, err
+		}
+		return dir, strings.TrimSuffix(name, compression.Suffix()), remove, nil
+	}
+
+	fmt.Fprintf(os.Stderr, "Mounting image read-only: %s\n", input)
+	dir, unmount, err := bootfs.MountImage(ctx, executor.Default, input)
+	if err != nil {
+		return "", "", nil, err
+	}
+	return dir, name, unmount, nil
+}
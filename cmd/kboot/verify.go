@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/rivsidn/kdev_bootstrap/pkg/bootfs"
+	"github.com/rivsidn/kdev_bootstrap/pkg/executor"
+	"github.com/rivsidn/kdev_bootstrap/pkg/utils"
+	"github.com/spf13/cobra"
+)
+
+var verifySkipFiles bool
+
+var bootfsVerifyCmd = &cobra.Command{
+	Use:   "verify BOOTFS",
+	Short: "Verify the integrity of a bootfs",
+	Long: `Verify checks a bootfs directory or exported tarball:
+
+  files      installed files match the dpkg md5sums lists (like debsums,
+             files excluded by dpkg path-exclude are skipped)
+  config     /etc/bootstrap.conf matches the dpkg database (architecture,
+             configured packages are installed)
+  manifest   the build manifest matches the dpkg database and bootstrap.conf
+  essential  /bin/sh, /sbin/init and /root/setup.sh (if configured) exist
+
+Exit status is 0 if no errors were found, 1 otherwise.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runBootfsVerify,
+}
+
+func init() {
+	bootfsVerifyCmd.Flags().BoolVar(&verifySkipFiles, "skip-files", false, "Do not check installed files against md5sums")
+
+	bootfsCmd.AddCommand(bootfsVerifyCmd)
+}
+
+func runBootfsVerify(cmd *cobra.Command, args []string) error {
+	root := args[0]
+
+	if bootfs.IsArchive(root) {
+		if !utils.CheckRoot() {
+			return fmt.Errorf("please run with sudo or root privileges")
+		}
+		fmt.Printf("Extracting bootfs archive: %s\n", root)
+		dir, remove, err := bootfs.ImportTemp(cmd.Context(), executor.Default, root)
+		if err != nil {
+			return err
+		}
+		defer remove()
+		root = dir
+	} else if !utils.DirExists(root) {
+		return fmt.Errorf("bootfs directory does not exist: %s", root)
+	}
+
+	report, err := bootfs.Verify(root, bootfs.VerifyOptions{SkipFiles: verifySkipFiles})
+	if err != nil {
+		return err
+	}
+	res.Data = report
+
+	errors, warnings := 0, 0
+	for _, p := range report.Problems {
+		if p.Level == bootfs.LevelError {
+			errors++
+		} else {
+			warnings++
+		}
+		if p.Path != "" {
+			fmt.Printf("%-7s %-9s %s: %s\n", p.Level, p.Check, p.Path, p.Message)
+		} else {
+			fmt.Printf("%-7s %-9s %s\n", p.Level, p.Check, p.Message)
+		}
+	}
+
+	fmt.Printf("\nChecked %d packages, %d files: %d error(s), %d warning(s)\n", report.Packages, report.Files, errors, warnings)
+	if report.Failed() {
+		return exitStatus(1)
+	}
+	return nil
+}
@@ -1,18 +1,46 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
 
+	"github.com/rivsidn/kdev_bootstrap/pkg/audit"
 	"github.com/rivsidn/kdev_bootstrap/pkg/builder"
+	"github.com/rivsidn/kdev_bootstrap/pkg/cleanup"
 	"github.com/rivsidn/kdev_bootstrap/pkg/config"
+	"github.com/rivsidn/kdev_bootstrap/pkg/diagnose"
+	"github.com/rivsidn/kdev_bootstrap/pkg/executor"
+	"github.com/rivsidn/kdev_bootstrap/pkg/logging"
+	"github.com/rivsidn/kdev_bootstrap/pkg/owner"
+	"github.com/rivsidn/kdev_bootstrap/pkg/progress"
+	"github.com/rivsidn/kdev_bootstrap/pkg/result"
 	"github.com/spf13/cobra"
 )
 
 var (
-	configFile string
-	arch       string
-	outputDir  string
+	configFile    string
+	arch          string
+	outputDir     string
+	provision     bool
+	timeouts      []string
+	recordFile    string
+	dryRun        string
+	logLevel      string
+	logFormat     string
+	logFile       string
+	verbose       bool
+	quiet         bool
+	progressMode  string
+	skipPreflight bool
+	metricsFile   string
+	outputFormat  string
+	ownerSpec     string
+	auditLog      string
+
+	// stdout 写入结果的标准输出，json 格式及 --dry-run 时 os.Stdout 指向标准错误
+	stdout = os.Stdout
+	res    = result.New("kboot_build_bootfs")
 )
 
 var rootCmd = &cobra.Command{
@@ -30,17 +58,55 @@ func init() {
 	rootCmd.Flags().StringVarP(&configFile, "file", "f", "", "Configuration file path (required)")
 	rootCmd.Flags().StringVarP(&arch, "arch", "a", "", "Target architecture (e.g., i386, amd64)")
 	rootCmd.Flags().StringVarP(&outputDir, "output", "o", "", "Output directory (default: current directory)")
+	rootCmd.Flags().BoolVar(&provision, "provision", false, "Run setup_script in a chroot at build time instead of on first boot")
+	rootCmd.Flags().StringArrayVar(&timeouts, "timeout", nil, "Stage timeout STAGE=DURATION for this run, e.g. debootstrap=30m (repeatable, 0 for none)")
+	rootCmd.Flags().StringVar(&recordFile, "record", "", "Record executed commands and their output to FILE (JSON lines) for replay")
+	rootCmd.Flags().StringVar(&dryRun, "dry-run", "", "Print the commands and file changes without doing anything; --dry-run=json for JSON")
+	rootCmd.Flags().Lookup("dry-run").NoOptDefVal = "text"
+	rootCmd.Flags().StringVar(&logLevel, "log-level", "info", "Terminal log level: debug, info, warn, error")
+	rootCmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "Same as --log-level debug")
+	rootCmd.Flags().BoolVarP(&quiet, "quiet", "q", false, "Same as --log-level warn, hides command output")
+	rootCmd.Flags().StringVar(&logFormat, "log-format", "text", "Terminal log format: text or json (JSON lines on stderr)")
+	rootCmd.Flags().BoolVar(&skipPreflight, "skip-preflight", false, "Do not check the host (tools, loop devices, disk space, ...) before building")
+	rootCmd.Flags().StringVar(&progressMode, "progress", "auto", "Progress display: auto (status line on terminals), json (events on stderr) or none")
+	rootCmd.Flags().StringVar(&logFile, "log-file", "", "Build log path (default: next to the artifact with suffix .build.log)")
+	rootCmd.Flags().StringVar(&metricsFile, "metrics-file", "", "Write stage durations to a Prometheus textfile (e.g. for the node exporter textfile collector)")
+	rootCmd.Flags().StringVar(&outputFormat, "output-format", result.FormatText, "Final result format: text, or json (a single result object on stdout, everything else on stderr)")
+	rootCmd.Flags().StringVar(&ownerSpec, "owner", "", "Owner USER[:GROUP] of the produced images, metadata and logs (default: the sudo caller from SUDO_UID/SUDO_GID)")
+	rootCmd.Flags().StringVar(&auditLog, "audit-log", "", "Append executed commands, mounts, loop devices and deletions to this JSON lines file (default: $KBOOT_AUDIT_LOG or "+audit.DefaultPath+")")
 
 	rootCmd.MarkFlagRequired("file")
 }
 
 func runBuild(cmd *cobra.Command, args []string) error {
+	if err := result.CheckFormat(outputFormat); err != nil {
+		return err
+	}
+	if outputFormat == result.FormatJSON && dryRun != "" {
+		return fmt.Errorf("--output-format json cannot be combined with --dry-run, use --dry-run=json")
+	}
+	// 标准输出只写入结果或构建计划，生成计划时 Build 的日志同样写入标准错误
+	if outputFormat == result.FormatJSON || dryRun != "" {
+		stdout = result.RedirectStdout()
+	}
+
+	if err := logging.Configure(logLevel, verbose, quiet, logFormat); err != nil {
+		return err
+	}
+	audit.SetPath(auditLog)
+
 	// 配置文件解析
 	cfg, err := config.LoadConfig(configFile)
 	if err != nil {
 		return err
 	}
 
+	for _, t := range timeouts {
+		if err := cfg.SetTimeoutFlag(t); err != nil {
+			return err
+		}
+	}
+
 	// 如果没有指定架构，使用配置文件中的
 	if arch == "" {
 		if cfg.ArchCurrent != "" {
@@ -53,17 +119,50 @@ func runBuild(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("architecture %s is not supported, supported architectures: %v", arch, cfg.ArchSupported)
 	}
 
+	// 创建构建器
+	builder := builder.NewBootfsBuilder(cfg, arch, outputDir)
+	builder.Provision = provision
+	defer builder.Result(res)
+
+	if builder.Owner, err = owner.Resolve(ownerSpec); err != nil {
+		return err
+	}
+
+	// 构建计划同样列出这些文件
+	builder.LogFile = logFile
+	builder.MetricsFile = metricsFile
+	builder.RecordFile = recordFile
+
+	if dryRun != "" {
+		plan, err := builder.Plan(cmd.Context())
+		if err != nil {
+			return err
+		}
+		return plan.Output(stdout, dryRun)
+	}
+
 	fmt.Printf("Configuration:\n")
 	fmt.Printf("   Distribution: %s %s\n", cfg.Distribution, cfg.Version)
 	fmt.Printf("   Supported architectures: %v\n", cfg.ArchSupported)
 	fmt.Printf("   Mirror: %s\n", cfg.Mirror)
 	fmt.Printf("   Target architecture: %s\n", arch)
 
-	// 创建构建器
-	builder := builder.NewBootfsBuilder(cfg, arch, outputDir)
+	if recordFile != "" {
+		recorder, err := executor.NewRecorder(recordFile)
+		if err != nil {
+			return err
+		}
+		defer recorder.Close()
+		builder.Exec = recorder
+	}
+
+	builder.SkipPreflight = skipPreflight
+	if builder.Progress, err = progress.Select(progressMode); err != nil {
+		return err
+	}
 
 	// 执行构建
-	if err := builder.Build(); err != nil {
+	if err := builder.Build(cmd.Context()); err != nil {
 		return fmt.Errorf("build failed: %v", err)
 	}
 
@@ -71,8 +170,31 @@ func runBuild(cmd *cobra.Command, args []string) error {
 }
 
 func main() {
-	if err := rootCmd.Execute(); err != nil {
+	// 中断时终止正在执行的命令，卸载 debootstrap、chroot 的挂载点并恢复 bootfs 中替换的文件
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cleanup.HandleSignals(cancel)
+
+	err := rootCmd.ExecuteContext(ctx)
+	code := 0
+	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
+		if known := diagnose.Find(err); known != nil {
+			fmt.Fprintln(os.Stderr, known.Explain())
+		}
+		for _, err := range cleanup.RunAll() {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		}
+		code = cleanup.ExitCode(1)
+	}
+
+	if outputFormat == result.FormatJSON {
+		res.Finish(err, code)
+		if err := res.Write(stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		}
+	}
+	if code != 0 {
+		os.Exit(code)
 	}
 }
@@ -0,0 +1,242 @@
+package audit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// DefaultPath 审计日志的默认位置，可以通过环境变量 KBOOT_AUDIT_LOG 或 --audit-log 修改
+const DefaultPath = "/var/log/kboot/audit.jsonl"
+
+// PathEnv 指定审计日志位置的环境变量
+const PathEnv = "KBOOT_AUDIT_LOG"
+
+// 记录类型
+const (
+	KindExec    = "exec"    // 外部命令
+	KindMount   = "mount"   // mount 命令
+	KindUmount  = "umount"  // umount 命令
+	KindLosetup = "losetup" // losetup 命令
+	KindDelete  = "delete"  // 删除文件或目录
+)
+
+// Kinds 所有记录类型
+var Kinds = []string{KindExec, KindMount, KindUmount, KindLosetup, KindDelete}
+
+// Record 审计日志中的一条记录，每条一行 JSON
+type Record struct {
+	Time       time.Time     `json:"time"`
+	Kind       string        `json:"kind"`
+	Args       []string      `json:"args,omitempty"`  // 命令及参数
+	Path       string        `json:"path,omitempty"`  // delete: 删除的路径，批量删除时为匹配的路径模式
+	Count      int           `json:"count,omitempty"` // delete: 批量删除的文件数
+	ExitStatus int           `json:"exit_status"`     // 命令的退出码，被信号终止为 128 + 信号，无法执行为 -1；delete 失败为 1
+	Error      string        `json:"error,omitempty"`
+	Duration   time.Duration `json:"duration_ns"`
+	User       string        `json:"user"` // 执行 sudo 的用户（SUDO_USER），直接执行时为当前用户
+	UID        int           `json:"uid"`
+	Dir        string        `json:"dir"` // 工作目录
+	PID        int           `json:"pid"`
+	Command    string        `json:"command"` // kboot 的命令行
+}
+
+// CommandLine 返回记录的命令行或删除的路径
+func (r *Record) CommandLine() string {
+	if r.Kind == KindDelete {
+		if r.Count > 0 {
+			return fmt.Sprintf("%s (%d files)", r.Path, r.Count)
+		}
+		return r.Path
+	}
+	return strings.Join(r.Args, " ")
+}
+
+var (
+	mu       sync.Mutex
+	path     string
+	disabled bool // 写入失败后不再写入，只警告一次
+)
+
+// SetPath 设置审计日志的位置，path 为空时不修改
+func SetPath(p string) {
+	if p == "" {
+		return
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	path, disabled = p, false
+}
+
+// Path 返回审计日志的位置：SetPath 设置的位置、环境变量 KBOOT_AUDIT_LOG 或 DefaultPath
+func Path() string {
+	mu.Lock()
+	defer mu.Unlock()
+	return currentPath()
+}
+
+// currentPath 返回审计日志的位置，调用者需持有 mu
+func currentPath() string {
+	if path != "" {
+		return path
+	}
+	if env := os.Getenv(PathEnv); env != "" {
+		return env
+	}
+	return DefaultPath
+}
+
+// Command 记录执行的外部命令，err 为命令的执行结果
+//
+// mount、umount、losetup 单独分类，便于筛选。
+func Command(args []string, start time.Time, err error) {
+	if len(args) == 0 {
+		return
+	}
+
+	kind := KindExec
+	switch filepath.Base(args[0]) {
+	case "mount":
+		kind = KindMount
+	case "umount":
+		kind = KindUmount
+	case "losetup":
+		kind = KindLosetup
+	}
+
+	r := &Record{Kind: kind, Args: args, ExitStatus: exitStatus(err), Duration: time.Since(start)}
+	if err != nil {
+		r.Error = err.Error()
+	}
+	write(r)
+}
+
+// RemoveAll 递归删除 path 并记录，path 不存在时不记录
+func RemoveAll(path string) error {
+	if _, err := os.Lstat(path); os.IsNotExist(err) {
+		return nil
+	}
+	start := time.Now()
+	err := os.RemoveAll(path)
+	deleted(path, start, err)
+	return err
+}
+
+// Remove 删除文件或空目录并记录，path 不存在时不记录，返回 os.Remove 的错误
+func Remove(path string) error {
+	start := time.Now()
+	err := os.Remove(path)
+	if !os.IsNotExist(err) {
+		deleted(path, start, err)
+	}
+	return err
+}
+
+// Removed 记录一次批量删除（如精简规则），patterns 为匹配的路径模式，count 为删除的文件数
+//
+// 批量删除的文件可能有数万个，只记录一条；没有删除文件且没有失败时不记录。
+func Removed(patterns []string, count int, start time.Time, err error) {
+	if count == 0 && err == nil {
+		return
+	}
+	r := &Record{Kind: KindDelete, Path: strings.Join(patterns, " "), Count: count, Duration: time.Since(start)}
+	if err != nil {
+		r.ExitStatus, r.Error = 1, err.Error()
+	}
+	write(r)
+}
+
+// deleted 记录删除操作
+func deleted(path string, start time.Time, err error) {
+	if abs, absErr := filepath.Abs(path); absErr == nil {
+		path = abs
+	}
+	r := &Record{Kind: KindDelete, Path: path, Duration: time.Since(start)}
+	if err != nil {
+		r.ExitStatus, r.Error = 1, err.Error()
+	}
+	write(r)
+}
+
+// exitStatus 返回命令的退出码
+func exitStatus(err error) int {
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return 0
+	case errors.As(err, &exitErr):
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			return 128 + int(status.Signal())
+		}
+		return exitErr.ExitCode()
+	default:
+		return -1
+	}
+}
+
+// write 追加一条记录，只记录以 root 执行的操作
+//
+// 每条记录以 O_APPEND 打开文件后一次写入，多个 kboot 进程同时写入时记录不会交错。
+func write(r *Record) {
+	if os.Geteuid() != 0 {
+		return
+	}
+
+	r.Time = time.Now().UTC()
+	r.User = invokingUser()
+	r.UID = os.Getuid()
+	r.Dir, _ = os.Getwd()
+	r.PID = os.Getpid()
+	r.Command = strings.Join(os.Args, " ")
+
+	data, err := json.Marshal(r)
+	if err != nil {
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if disabled {
+		return
+	}
+	if err := appendLine(currentPath(), append(data, '\n')); err != nil {
+		disabled = true
+		slog.Warn("audit log not written", "error", err)
+	}
+}
+
+// appendLine 以追加方式写入一行，文件不存在时创建
+func appendLine(path string, line []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create directory of %s: %v", path, err)
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %v", path, err)
+	}
+	if _, err := f.Write(line); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write %s: %v", path, err)
+	}
+	return f.Close()
+}
+
+// invokingUser 返回执行 sudo 的用户，直接执行时返回当前用户
+func invokingUser() string {
+	if name := os.Getenv("SUDO_USER"); name != "" {
+		return name
+	}
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return ""
+}
@@ -0,0 +1,98 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+)
+
+// maxLine 单条记录的最大长度
+const maxLine = 4 * 1024 * 1024
+
+// Filter 筛选记录的条件，零值不筛选
+type Filter struct {
+	User   string    // 执行 sudo 的用户
+	Kinds  []string  // 记录类型，任一匹配
+	Since  time.Time // 不早于
+	Until  time.Time // 早于
+	PID    int       // kboot 进程
+	Failed bool      // 只保留失败的操作
+	Text   string    // 命令行、删除的路径、工作目录或 kboot 命令行包含的字符串
+}
+
+// Match 判断记录是否满足所有条件
+func (f *Filter) Match(r *Record) bool {
+	switch {
+	case f.User != "" && r.User != f.User:
+		return false
+	case len(f.Kinds) > 0 && !contains(f.Kinds, r.Kind):
+		return false
+	case !f.Since.IsZero() && r.Time.Before(f.Since):
+		return false
+	case !f.Until.IsZero() && !r.Time.Before(f.Until):
+		return false
+	case f.PID != 0 && r.PID != f.PID:
+		return false
+	case f.Failed && r.ExitStatus == 0:
+		return false
+	case f.Text != "" && !strings.Contains(r.CommandLine(), f.Text) &&
+		!strings.Contains(r.Dir, f.Text) && !strings.Contains(r.Command, f.Text):
+		return false
+	}
+	return true
+}
+
+// CheckKinds 检查记录类型是否有效
+func CheckKinds(kinds []string) error {
+	for _, kind := range kinds {
+		if !contains(Kinds, kind) {
+			return fmt.Errorf("unknown audit record kind: %s (supported: %s)", kind, strings.Join(Kinds, ", "))
+		}
+	}
+	return nil
+}
+
+// Read 读取审计日志中满足条件的记录，按写入顺序排列
+//
+// 无法解析的行（如写入时磁盘已满留下的不完整记录）被跳过并输出警告。
+func Read(path string, filter *Filter) ([]*Record, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %v", err)
+	}
+	defer f.Close()
+
+	var records []*Record
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), maxLine)
+	for line := 1; scanner.Scan(); line++ {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		r := &Record{}
+		if err := json.Unmarshal(scanner.Bytes(), r); err != nil {
+			slog.Warn("invalid audit record skipped", "path", path, "line", line, "error", err)
+			continue
+		}
+		if filter.Match(r) {
+			records = append(records, r)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read audit log %s: %v", path, err)
+	}
+	return records, nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
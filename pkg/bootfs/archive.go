@@ -0,0 +1,248 @@
+package bootfs
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/rivsidn/kdev_bootstrap/pkg/audit"
+	"github.com/rivsidn/kdev_bootstrap/pkg/cleanup"
+	"github.com/rivsidn/kdev_bootstrap/pkg/executor"
+	"github.com/rivsidn/kdev_bootstrap/pkg/utils"
+)
+
+// ConfigMember bootstrap.conf 在归档中的路径
+const ConfigMember = "./etc/bootstrap.conf"
+
+// CredentialsSuffix 凭据文件的后缀，bootfs 及其归档的凭据保存在旁边的 ${path}.credentials
+const CredentialsSuffix = ".credentials"
+
+// Compression 归档压缩格式
+type Compression string
+
+const (
+	CompressionNone Compression = "none"
+	CompressionGzip Compression = "gz"
+	CompressionXz   Compression = "xz"
+	CompressionZstd Compression = "zstd"
+)
+
+// archiveSuffixes 文件后缀与压缩格式的对应关系
+var archiveSuffixes = []struct {
+	suffix      string
+	compression Compression
+}{
+	{".tar.zst", CompressionZstd},
+	{".tzst", CompressionZstd},
+	{".tar.xz", CompressionXz},
+	{".txz", CompressionXz},
+	{".tar.gz", CompressionGzip},
+	{".tgz", CompressionGzip},
+	{".tar", CompressionNone},
+}
+
+// ParseCompression 解析压缩格式名称
+func ParseCompression(name string) (Compression, error) {
+	switch strings.ToLower(name) {
+	case "", "zstd", "zst":
+		return CompressionZstd, nil
+	case "xz":
+		return CompressionXz, nil
+	case "gz", "gzip":
+		return CompressionGzip, nil
+	case "none":
+		return CompressionNone, nil
+	}
+	return "", fmt.Errorf("unsupported compression: %s (supported: zstd, xz, gz, none)", name)
+}
+
+// DetectCompression 根据文件后缀判断压缩格式
+func DetectCompression(path string) (Compression, bool) {
+	for _, s := range archiveSuffixes {
+		if strings.HasSuffix(path, s.suffix) {
+			return s.compression, true
+		}
+	}
+	return "", false
+}
+
+// Suffix 返回压缩格式对应的默认文件后缀
+func (c Compression) Suffix() string {
+	for _, s := range archiveSuffixes {
+		if s.compression == c {
+			return s.suffix
+		}
+	}
+	return ".tar"
+}
+
+// tarFlags 返回压缩格式对应的 tar 参数
+func (c Compression) tarFlags() []string {
+	switch c {
+	case CompressionGzip:
+		return []string{"--gzip"}
+	case CompressionXz:
+		return []string{"--xz"}
+	case CompressionZstd:
+		return []string{"--zstd"}
+	}
+	return nil
+}
+
+// IsArchive 判断路径是否为 bootfs 归档文件
+func IsArchive(path string) bool {
+	if _, ok := DetectCompression(path); !ok {
+		return false
+	}
+	info, err := os.Stat(path)
+	return err == nil && info.Mode().IsRegular()
+}
+
+// tarMetadataFlags 保留属主、权限、扩展属性（含文件 capabilities）及 ACL
+var tarMetadataFlags = []string{
+	"--numeric-owner",
+	"--preserve-permissions",
+	"--xattrs",
+	"--xattrs-include=*",
+	"--acls",
+}
+
+// Export 将 bootfs 目录导出为归档文件
+//
+// 设备文件和硬链接由 tar 原样保存。/etc/bootstrap.conf 作为第一个成员写入归档，
+// 导入端无需解压整个归档即可读取 bootfs 的构建信息。bootfs 的凭据文件复制到归档旁。
+func Export(ctx context.Context, bootfsPath, archivePath string, compression Compression) error {
+	if !utils.DirExists(bootfsPath) {
+		return fmt.Errorf("bootfs directory does not exist: %s", bootfsPath)
+	}
+	if !utils.FileExists(filepath.Join(bootfsPath, ConfigMember)) {
+		return fmt.Errorf("configuration file not found in bootfs: %s", filepath.Join(bootfsPath, ConfigMember))
+	}
+
+	args := []string{"--create", "--file=" + archivePath}
+	args = append(args, compression.tarFlags()...)
+	args = append(args, tarMetadataFlags...)
+	args = append(args,
+		"--sparse",
+		"--exclude=./proc/*",
+		"--exclude=./sys/*",
+		"-C", bootfsPath,
+		ConfigMember,
+		// 之后的 exclude 只作用于 "."，bootstrap.conf 不会重复写入
+		"--exclude="+ConfigMember,
+		".",
+	)
+
+	if err := utils.RunCommandContext(ctx, "tar", args...); err != nil {
+		audit.Remove(archivePath)
+		return fmt.Errorf("failed to export bootfs: %v", err)
+	}
+
+	return copyCredentials(bootfsPath, archivePath)
+}
+
+// Import 将归档文件解压到目标目录，归档的凭据文件复制到目标目录旁
+func Import(ctx context.Context, archivePath, destDir string) error {
+	args, err := ImportArgs(archivePath, destDir)
+	if err != nil {
+		return err
+	}
+
+	if err := utils.CreateDir(destDir); err != nil {
+		return err
+	}
+
+	if err := utils.RunCommandContext(ctx, "tar", args...); err != nil {
+		return fmt.Errorf("failed to import bootfs: %v", err)
+	}
+
+	return copyCredentials(archivePath, destDir)
+}
+
+// copyCredentials 将 src 旁的凭据文件复制到 dst 旁，src 没有凭据文件时不做任何事
+func copyCredentials(src, dst string) error {
+	data, err := os.ReadFile(filepath.Clean(src) + CredentialsSuffix)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err == nil {
+		err = os.WriteFile(filepath.Clean(dst)+CredentialsSuffix, data, 0600)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to copy credentials: %v", err)
+	}
+	return nil
+}
+
+// ImportArgs 返回 Import 执行的 tar 参数
+func ImportArgs(archivePath, destDir string) ([]string, error) {
+	compression, ok := DetectCompression(archivePath)
+	if !ok {
+		return nil, fmt.Errorf("unrecognized archive format: %s", archivePath)
+	}
+
+	args := []string{"--extract", "--file=" + archivePath}
+	args = append(args, compression.tarFlags()...)
+	args = append(args, tarMetadataFlags...)
+	return append(args, "--same-owner", "-C", destDir), nil
+}
+
+// importTempPrefix ImportTemp 创建的临时目录名称前缀
+const importTempPrefix = ".kboot-bootfs-"
+
+// ImportTemp 通过 exec 将归档解压到归档所在目录下的临时目录，返回临时目录路径及删除函数
+//
+// bootfs 通常较大，不使用可能是 tmpfs 的 /tmp。
+func ImportTemp(ctx context.Context, exec executor.Executor, archivePath string) (string, func(), error) {
+	dir, err := exec.MkdirTemp(filepath.Dir(archivePath), importTempPrefix)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create temporary directory: %v", err)
+	}
+	entry, err := cleanup.Register(ctx, exec, cleanup.Remove(dir), nil)
+	if err != nil {
+		exec.RemoveAll(dir)
+		return "", nil, err
+	}
+
+	args, err := ImportArgs(archivePath, dir)
+	if err == nil {
+		err = exec.Run(ctx, "tar", args...)
+		if err != nil {
+			err = fmt.Errorf("failed to import bootfs: %v", err)
+		}
+	}
+	if err != nil {
+		entry.Release()
+		return "", nil, err
+	}
+
+	return dir, func() { entry.Release() }, nil
+}
+
+// ReadConfig 从归档中读取 bootstrap.conf 内容
+func ReadConfig(archivePath string) ([]byte, error) {
+	return ReadMember(archivePath, ConfigMember)
+}
+
+// ReadMember 从归档中读取单个文件的内容，member 为 "./" 开头的归档路径
+func ReadMember(archivePath, member string) ([]byte, error) {
+	compression, ok := DetectCompression(archivePath)
+	if !ok {
+		return nil, fmt.Errorf("unrecognized archive format: %s", archivePath)
+	}
+
+	args := []string{"--extract", "--to-stdout", "--occurrence=1", "--file=" + archivePath}
+	args = append(args, compression.tarFlags()...)
+	args = append(args, member)
+
+	// 只读取归档，不修改文件，不写入审计日志
+	output, err := exec.Command("tar", args...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s from %s: %v", member, archivePath, err)
+	}
+
+	return output, nil
+}
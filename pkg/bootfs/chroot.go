@@ -0,0 +1,277 @@
+package bootfs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/rivsidn/kdev_bootstrap/pkg/audit"
+	"github.com/rivsidn/kdev_bootstrap/pkg/cleanup"
+	"github.com/rivsidn/kdev_bootstrap/pkg/executor"
+	"github.com/rivsidn/kdev_bootstrap/pkg/utils"
+)
+
+// policyRcd 禁止 chroot 内的软件包启动守护进程
+const policyRcd = `#!/bin/sh
+# Installed by kboot: do not start daemons inside the build chroot
+exit 101
+`
+
+// chrootEnv chroot 内命令使用的环境变量
+var chrootEnv = []string{
+	"DEBIAN_FRONTEND=noninteractive",
+	"LC_ALL=C",
+	"LANG=C",
+	"HOME=/root",
+	"PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin",
+}
+
+// chrootMount chroot 需要的挂载项
+type chrootMount struct {
+	target string // 相对 bootfs 根目录
+	args   []string
+}
+
+// chrootMounts 按挂载顺序排列，卸载时逆序
+var chrootMounts = []chrootMount{
+	{"proc", []string{"-t", "proc", "proc"}},
+	{"sys", []string{"-t", "sysfs", "sysfs"}},
+	{"dev", []string{"--bind", "/dev"}},
+	{"dev/pts", []string{"--bind", "/dev/pts"}},
+}
+
+// Chroot bootfs 的 chroot 环境
+//
+// 挂载及替换的文件登记到 cleanup，收到信号或进程异常退出后同样可以恢复。
+type Chroot struct {
+	Root string
+	Exec executor.Executor // 执行 mount 及 chroot 命令
+
+	mounts []*cleanup.Entry // 按挂载顺序排列
+	policy *cleanup.Entry   // 安装的 policy-rc.d
+	resolv *cleanup.Entry   // 复制的 resolv.conf
+}
+
+// NewChroot 创建 chroot 环境
+func NewChroot(root string) (*Chroot, error) {
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve bootfs path: %v", err)
+	}
+	if !utils.DirExists(absRoot) {
+		return nil, fmt.Errorf("bootfs directory does not exist: %s", absRoot)
+	}
+	return &Chroot{Root: absRoot, Exec: executor.Default}, nil
+}
+
+// Mount 挂载 proc、sys、dev 及 dev/pts
+//
+// 失败时已完成的挂载会被卸载，ctx 取消时终止正在执行的 mount。
+func (c *Chroot) Mount(ctx context.Context) error {
+	for _, m := range chrootMounts {
+		target := filepath.Join(c.Root, m.target)
+		if utils.IsMounted(target) {
+			c.Unmount()
+			return fmt.Errorf("%s is already mounted, unmount it first (kboot doctor --cleanup releases mounts left by interrupted runs)", target)
+		}
+		if err := c.Exec.MkdirAll(target, 0755); err != nil {
+			c.Unmount()
+			return fmt.Errorf("failed to create directory %s: %v", target, err)
+		}
+
+		args := m.mountArgs(c.Root)
+		entry, err := cleanup.Register(ctx, c.Exec, cleanup.Unmount(target), func() error {
+			return c.Exec.Run(ctx, "mount", args...)
+		})
+		if err != nil {
+			c.Unmount()
+			return fmt.Errorf("failed to mount %s: %v", target, err)
+		}
+		c.mounts = append(c.mounts, entry)
+	}
+
+	return nil
+}
+
+// mountArgs 返回挂载到 root 下的 mount 命令参数
+func (m chrootMount) mountArgs(root string) []string {
+	return append(append([]string{}, m.args...), filepath.Join(root, m.target))
+}
+
+// Unmount 逆序卸载所有挂载点，普通卸载失败时使用延迟卸载
+func (c *Chroot) Unmount() error {
+	var firstErr error
+	for i := len(c.mounts) - 1; i >= 0; i-- {
+		if err := c.mounts[i].Release(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	c.mounts = nil
+	return firstErr
+}
+
+// BlockDaemons 安装 policy-rc.d，阻止软件包安装时启动守护进程
+func (c *Chroot) BlockDaemons(ctx context.Context) error {
+	path := PolicyRcdPath(c.Root)
+	entry, err := c.replaceFile(ctx, path)
+	if err != nil {
+		return err
+	}
+	c.policy = entry
+
+	if err := c.Exec.WriteFile(path, []byte(policyRcd), 0755); err != nil {
+		return fmt.Errorf("failed to install policy-rc.d: %v", err)
+	}
+
+	return nil
+}
+
+// PolicyRcdPath 返回 BlockDaemons 安装的 policy-rc.d 路径
+func PolicyRcdPath(root string) string {
+	return filepath.Join(root, "usr", "sbin", "policy-rc.d")
+}
+
+// UnblockDaemons 移除 policy-rc.d 并恢复原有文件
+func (c *Chroot) UnblockDaemons() error {
+	if err := c.policy.Release(); err != nil {
+		return fmt.Errorf("failed to restore policy-rc.d: %v", err)
+	}
+	c.policy = nil
+	return nil
+}
+
+// UseHostResolver 使用宿主机的 /etc/resolv.conf，chroot 内的 resolv.conf 可能指向未运行的 systemd-resolved
+func (c *Chroot) UseHostResolver(ctx context.Context) error {
+	data, err := os.ReadFile("/etc/resolv.conf")
+	if err != nil {
+		return fmt.Errorf("failed to read host resolv.conf: %v", err)
+	}
+
+	path := filepath.Join(c.Root, "etc", "resolv.conf")
+	entry, err := c.replaceFile(ctx, path)
+	if err != nil {
+		return err
+	}
+	c.resolv = entry
+
+	if err := c.Exec.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to install resolv.conf: %v", err)
+	}
+
+	return nil
+}
+
+// RestoreResolver 移除复制的 resolv.conf 并恢复原有文件
+func (c *Chroot) RestoreResolver() error {
+	if err := c.resolv.Release(); err != nil {
+		return fmt.Errorf("failed to restore resolv.conf: %v", err)
+	}
+	c.resolv = nil
+	return nil
+}
+
+// replaceFile 准备替换 chroot 内的文件：原文件（可能是符号链接）存在时备份为 .kboot-saved，并登记恢复操作
+func (c *Chroot) replaceFile(ctx context.Context, path string) (*cleanup.Entry, error) {
+	if err := c.Exec.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create directory %s: %v", filepath.Dir(path), err)
+	}
+	if !c.Exec.Exists(path) {
+		return cleanup.Register(ctx, c.Exec, cleanup.Restore(path, ""), nil)
+	}
+
+	saved := path + ".kboot-saved"
+	return cleanup.Register(ctx, c.Exec, cleanup.Restore(path, saved), func() error {
+		if err := c.Exec.Rename(path, saved); err != nil {
+			return fmt.Errorf("failed to back up %s: %v", path, err)
+		}
+		return nil
+	})
+}
+
+// Close 恢复 policy-rc.d、resolv.conf 并卸载所有挂载点，可重复调用
+func (c *Chroot) Close() error {
+	policyErr := c.UnblockDaemons()
+	resolvErr := c.RestoreResolver()
+	unmountErr := c.Unmount()
+	for _, err := range []error{policyErr, resolvErr} {
+		if err != nil {
+			return err
+		}
+	}
+	return unmountErr
+}
+
+// Run 在 chroot 内执行命令，输出同时写入日志，ctx 取消时终止命令
+func (c *Chroot) Run(ctx context.Context, log io.Writer, name string, args ...string) error {
+	return c.Exec.RunWithLog(ctx, log, "chroot", RunArgs(c.Root, name, args...)...)
+}
+
+// RunArgs 返回 Run 执行的 chroot 命令参数
+func RunArgs(root, name string, args ...string) []string {
+	chrootArgs := []string{root, "/usr/bin/env", "-i"}
+	chrootArgs = append(chrootArgs, chrootEnv...)
+	chrootArgs = append(chrootArgs, name)
+	return append(chrootArgs, args...)
+}
+
+// Interactive 在 chroot 内执行命令，连接当前终端，返回命令的退出码
+//
+// 命令与 kboot 在同一前台进程组，终端的 Ctrl-C 直接发送给命令，kboot 只忽略 SIGINT；
+// 其他进程发送给 kboot 的 SIGTERM、SIGHUP 转发给命令，命令退出后调用者可以正常卸载。
+func (c *Chroot) Interactive(env []string, name string, args ...string) (int, error) {
+	chrootArgs := []string{c.Root, "/usr/bin/env", "-i"}
+	chrootArgs = append(chrootArgs, chrootEnv...)
+	chrootArgs = append(chrootArgs, env...)
+	chrootArgs = append(chrootArgs, name)
+	chrootArgs = append(chrootArgs, args...)
+
+	cmd := exec.Command("chroot", chrootArgs...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(signals)
+
+	start := time.Now()
+	if err := cmd.Start(); err != nil {
+		audit.Command(cmd.Args, start, err)
+		return 0, fmt.Errorf("failed to start chroot: %v", err)
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case sig := <-signals:
+				if sig != syscall.SIGINT {
+					cmd.Process.Signal(sig)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	err := cmd.Wait()
+	audit.Command(cmd.Args, start, err)
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		// 被信号终止时与 shell 一致，退出码为 128 + 信号
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			return 128 + int(status.Signal()), nil
+		}
+		return exitErr.ExitCode(), nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("chroot failed: %v", err)
+	}
+	return 0, nil
+}
@@ -0,0 +1,27 @@
+package bootfs
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/rivsidn/kdev_bootstrap/pkg/config"
+	"github.com/rivsidn/kdev_bootstrap/pkg/utils"
+)
+
+// LoadConfig 加载 bootfs 目录或归档中的 bootstrap.conf
+func LoadConfig(path string) (*config.Config, error) {
+	if IsArchive(path) {
+		data, err := ReadConfig(path)
+		if err != nil {
+			return nil, err
+		}
+		return config.LoadConfigData(data, path+":"+ConfigMember)
+	}
+
+	configPath := filepath.Join(path, "etc", "bootstrap.conf")
+	if !utils.FileExists(configPath) {
+		return nil, fmt.Errorf("configuration file not found: %s", configPath)
+	}
+
+	return config.LoadConfig(configPath)
+}
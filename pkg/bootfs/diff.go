@@ -0,0 +1,364 @@
+package bootfs
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/rivsidn/kdev_bootstrap/pkg/config"
+	"github.com/rivsidn/kdev_bootstrap/pkg/dpkg"
+	"github.com/rivsidn/kdev_bootstrap/pkg/executor"
+	"github.com/rivsidn/kdev_bootstrap/pkg/manifest"
+	"github.com/rivsidn/kdev_bootstrap/pkg/utils"
+)
+
+// Snapshot 用于比较的 bootfs 内容：目录、归档或构建清单
+type Snapshot struct {
+	Path     string
+	Root     string // 文件级比较使用的目录，构建清单为空
+	Packages []dpkg.Package
+	Config   map[string]map[string]string
+
+	cleanup func()
+}
+
+// OpenSnapshot 读取 bootfs 目录、归档或构建清单（.json）
+//
+// needFiles 为 true 时归档会被解压到临时目录以便比较文件，
+// 否则只从归档中读取 dpkg 数据库及 bootstrap.conf。
+func OpenSnapshot(ctx context.Context, path string, needFiles bool) (*Snapshot, error) {
+	s := &Snapshot{Path: path}
+
+	switch {
+	case utils.DirExists(path):
+		s.Root = path
+		if err := s.loadFromDir(); err != nil {
+			return nil, err
+		}
+
+	case IsArchive(path) && needFiles:
+		dir, remove, err := ImportTemp(ctx, executor.Default, path)
+		if err != nil {
+			return nil, err
+		}
+		s.Root = dir
+		s.cleanup = remove
+		if err := s.loadFromDir(); err != nil {
+			s.Close()
+			return nil, err
+		}
+
+	case IsArchive(path):
+		status, err := ReadMember(path, "./"+dpkg.StatusPath)
+		if err != nil {
+			return nil, err
+		}
+		if s.Packages, err = dpkg.ParseInstalled(bytes.NewReader(status)); err != nil {
+			return nil, err
+		}
+		cfg, err := LoadConfig(path)
+		if err != nil {
+			return nil, err
+		}
+		if s.Config, err = cfg.Sections(); err != nil {
+			return nil, err
+		}
+
+	case strings.HasSuffix(path, ".json"):
+		m, err := manifest.Load(path)
+		if err != nil {
+			return nil, err
+		}
+		s.Packages = m.Packages
+		s.Config = m.Config
+
+	default:
+		return nil, fmt.Errorf("not a bootfs directory, archive or manifest: %s", path)
+	}
+
+	return s, nil
+}
+
+// loadFromDir 从 bootfs 目录读取软件包及配置
+func (s *Snapshot) loadFromDir() error {
+	var err error
+	if s.Packages, err = dpkg.ReadInstalled(s.Root); err != nil {
+		return err
+	}
+
+	cfg, err := config.LoadConfig(filepath.Join(s.Root, "etc", "bootstrap.conf"))
+	if err != nil {
+		return err
+	}
+	s.Config, err = cfg.Sections()
+	return err
+}
+
+// Close 删除解压的临时目录
+func (s *Snapshot) Close() {
+	if s.cleanup != nil {
+		s.cleanup()
+		s.cleanup = nil
+	}
+}
+
+// PackageChange 软件包差异，OldVersion 为空表示新增，NewVersion 为空表示删除
+type PackageChange struct {
+	Name         string `json:"name"`
+	Architecture string `json:"architecture"`
+	OldVersion   string `json:"old_version,omitempty"`
+	NewVersion   string `json:"new_version,omitempty"`
+}
+
+// ConfigChange bootstrap.conf 差异，Old 为空表示新增，New 为空表示删除
+type ConfigChange struct {
+	Section string `json:"section"`
+	Key     string `json:"key"`
+	Old     string `json:"old,omitempty"`
+	New     string `json:"new,omitempty"`
+}
+
+// FileChange 文件差异
+type FileChange struct {
+	Path   string `json:"path"`
+	Change string `json:"change"` // added、removed、changed
+	Detail string `json:"detail,omitempty"`
+}
+
+// Diff 两个 bootfs 之间的差异
+type Diff struct {
+	Packages []PackageChange `json:"packages"`
+	Config   []ConfigChange  `json:"config"`
+	Files    []FileChange    `json:"files,omitempty"`
+}
+
+// Empty 判断是否没有差异
+func (d *Diff) Empty() bool {
+	return len(d.Packages) == 0 && len(d.Config) == 0 && len(d.Files) == 0
+}
+
+// Compare 比较两个 bootfs，paths 不为空时比较这些目录下的文件
+func Compare(a, b *Snapshot, paths []string) (*Diff, error) {
+	d := &Diff{
+		Packages: comparePackages(a.Packages, b.Packages),
+		Config:   compareConfig(a.Config, b.Config),
+	}
+
+	if len(paths) == 0 {
+		return d, nil
+	}
+	for _, s := range []*Snapshot{a, b} {
+		if s.Root == "" {
+			return nil, fmt.Errorf("file comparison is not available for manifest %s", s.Path)
+		}
+	}
+	for _, path := range paths {
+		changes, err := compareFiles(a.Root, b.Root, path)
+		if err != nil {
+			return nil, err
+		}
+		d.Files = append(d.Files, changes...)
+	}
+
+	return d, nil
+}
+
+// comparePackages 按名称和架构比较软件包版本
+func comparePackages(a, b []dpkg.Package) []PackageChange {
+	key := func(p dpkg.Package) string { return p.Name + ":" + p.Architecture }
+
+	changes := make(map[string]*PackageChange)
+	for _, p := range a {
+		changes[key(p)] = &PackageChange{Name: p.Name, Architecture: p.Architecture, OldVersion: p.Version}
+	}
+	for _, p := range b {
+		if c, ok := changes[key(p)]; ok {
+			c.NewVersion = p.Version
+		} else {
+			changes[key(p)] = &PackageChange{Name: p.Name, Architecture: p.Architecture, NewVersion: p.Version}
+		}
+	}
+
+	var result []PackageChange
+	for _, c := range changes {
+		if c.OldVersion != c.NewVersion {
+			result = append(result, *c)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Name != result[j].Name {
+			return result[i].Name < result[j].Name
+		}
+		return result[i].Architecture < result[j].Architecture
+	})
+	return result
+}
+
+// compareConfig 比较配置段中的键值
+func compareConfig(a, b map[string]map[string]string) []ConfigChange {
+	var result []ConfigChange
+	sections := make(map[string]bool)
+	for name := range a {
+		sections[name] = true
+	}
+	for name := range b {
+		sections[name] = true
+	}
+
+	for section := range sections {
+		keys := make(map[string]bool)
+		for key := range a[section] {
+			keys[key] = true
+		}
+		for key := range b[section] {
+			keys[key] = true
+		}
+		for key := range keys {
+			if oldValue, newValue := a[section][key], b[section][key]; oldValue != newValue {
+				result = append(result, ConfigChange{Section: section, Key: key, Old: oldValue, New: newValue})
+			}
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Section != result[j].Section {
+			return result[i].Section < result[j].Section
+		}
+		return result[i].Key < result[j].Key
+	})
+	return result
+}
+
+// fileInfo 文件比较使用的元数据
+type fileInfo struct {
+	mode   fs.FileMode
+	uid    uint32
+	gid    uint32
+	size   int64
+	target string // 符号链接目标
+}
+
+// scanFiles 列出 root 下 dir 目录中的所有文件，键为 bootfs 内的绝对路径
+func scanFiles(root, dir string) (map[string]fileInfo, error) {
+	files := make(map[string]fileInfo)
+	base := filepath.Join(root, dir)
+	if _, err := os.Lstat(base); os.IsNotExist(err) {
+		return files, nil
+	}
+
+	err := filepath.WalkDir(base, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		rel, _ := filepath.Rel(root, path)
+		fi := fileInfo{mode: info.Mode(), size: info.Size()}
+		if st, ok := info.Sys().(*syscall.Stat_t); ok {
+			fi.uid, fi.gid = st.Uid, st.Gid
+		}
+		if info.Mode()&fs.ModeSymlink != 0 {
+			fi.target, _ = os.Readlink(path)
+		}
+		if info.IsDir() {
+			fi.size = 0
+		}
+		files[filepath.Join("/", rel)] = fi
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan %s: %v", base, err)
+	}
+	return files, nil
+}
+
+// compareFiles 比较两个 bootfs 中 dir 目录下的文件
+func compareFiles(rootA, rootB, dir string) ([]FileChange, error) {
+	dir = filepath.Join("/", dir)
+	a, err := scanFiles(rootA, dir)
+	if err != nil {
+		return nil, err
+	}
+	b, err := scanFiles(rootB, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []FileChange
+	for path, fa := range a {
+		fb, ok := b[path]
+		if !ok {
+			result = append(result, FileChange{Path: path, Change: "removed"})
+			continue
+		}
+		if detail := fileDifference(rootA, rootB, path, fa, fb); detail != "" {
+			result = append(result, FileChange{Path: path, Change: "changed", Detail: detail})
+		}
+	}
+	for path := range b {
+		if _, ok := a[path]; !ok {
+			result = append(result, FileChange{Path: path, Change: "added"})
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i].Path < result[j].Path })
+	return result, nil
+}
+
+// fileDifference 描述同一路径两个文件的差异，没有差异时返回空字符串
+func fileDifference(rootA, rootB, path string, a, b fileInfo) string {
+	var details []string
+	switch {
+	case a.mode.Type() != b.mode.Type():
+		return fmt.Sprintf("type %s -> %s", a.mode.Type(), b.mode.Type())
+	case a.target != b.target:
+		return fmt.Sprintf("symlink %s -> %s", a.target, b.target)
+	}
+
+	if a.mode.Perm() != b.mode.Perm() || a.mode&(fs.ModeSetuid|fs.ModeSetgid|fs.ModeSticky) != b.mode&(fs.ModeSetuid|fs.ModeSetgid|fs.ModeSticky) {
+		details = append(details, fmt.Sprintf("mode %s -> %s", a.mode, b.mode))
+	}
+	if a.uid != b.uid || a.gid != b.gid {
+		details = append(details, fmt.Sprintf("owner %d:%d -> %d:%d", a.uid, a.gid, b.uid, b.gid))
+	}
+	if a.mode.IsRegular() {
+		if a.size != b.size {
+			details = append(details, fmt.Sprintf("size %d -> %d", a.size, b.size))
+		} else if !sameContent(filepath.Join(rootA, path), filepath.Join(rootB, path)) {
+			details = append(details, "content")
+		}
+	}
+
+	return strings.Join(details, ", ")
+}
+
+// sameContent 比较两个文件的内容
+func sameContent(a, b string) bool {
+	hash := func(path string) ([]byte, error) {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		h := sha256.New()
+		if _, err := io.Copy(h, f); err != nil {
+			return nil, err
+		}
+		return h.Sum(nil), nil
+	}
+
+	ha, errA := hash(a)
+	hb, errB := hash(b)
+	return errA == nil && errB == nil && bytes.Equal(ha, hb)
+}
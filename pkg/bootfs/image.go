@@ -0,0 +1,39 @@
+package bootfs
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/rivsidn/kdev_bootstrap/pkg/cleanup"
+	"github.com/rivsidn/kdev_bootstrap/pkg/executor"
+)
+
+// MountImage 通过 exec 以只读方式挂载 QEMU rootfs 镜像，返回挂载点及卸载函数
+func MountImage(ctx context.Context, exec executor.Executor, imagePath string) (string, func(), error) {
+	exec = executor.Or(exec)
+	mountPoint, err := exec.MkdirTemp("", "kboot-image-")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create mount point: %v", err)
+	}
+	dirEntry, err := cleanup.Register(ctx, exec, cleanup.Remove(mountPoint), nil)
+	if err != nil {
+		exec.Remove(mountPoint)
+		return "", nil, err
+	}
+
+	mountEntry, err := cleanup.Register(ctx, exec, cleanup.Unmount(mountPoint), func() error {
+		return exec.Run(ctx, "mount", "-o", "loop,ro", imagePath, mountPoint)
+	})
+	if err != nil {
+		dirEntry.Release()
+		return "", nil, fmt.Errorf("failed to mount image: %v", err)
+	}
+
+	unmount := func() {
+		if mountEntry.Release() == nil {
+			dirEntry.Release()
+		}
+	}
+
+	return mountPoint, unmount, nil
+}
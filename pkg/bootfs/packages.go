@@ -0,0 +1,170 @@
+package bootfs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/rivsidn/kdev_bootstrap/pkg/audit"
+	"github.com/rivsidn/kdev_bootstrap/pkg/config"
+	"github.com/rivsidn/kdev_bootstrap/pkg/lock"
+	"github.com/rivsidn/kdev_bootstrap/pkg/manifest"
+)
+
+// DefaultPackagesKey kboot bootfs install 默认写入的配置项
+const DefaultPackagesKey = "extra_packages"
+
+// 软件包操作
+const (
+	OperationInstall = "install"
+	OperationRemove  = "remove"
+)
+
+// PackageOptions 软件包操作选项
+type PackageOptions struct {
+	Key   string // install 时写入的 _packages 配置项
+	Purge bool   // remove 时同时删除配置文件
+	Log   io.Writer
+}
+
+// InstallPackages 在 bootfs 的 chroot 中安装软件包，并更新 bootstrap.conf 及构建清单
+func InstallPackages(ctx context.Context, root string, packages []string, opts PackageOptions) error {
+	args := []string{"install", "-y", "-o", "APT::Install-Recommends=false"}
+	return changePackages(ctx, root, OperationInstall, packages, append(args, packages...), opts)
+}
+
+// RemovePackages 在 bootfs 的 chroot 中删除软件包，并更新 bootstrap.conf 及构建清单
+func RemovePackages(ctx context.Context, root string, packages []string, opts PackageOptions) error {
+	args := []string{"remove", "-y"}
+	if opts.Purge {
+		args = append(args, "--purge")
+	}
+	return changePackages(ctx, root, OperationRemove, packages, append(args, packages...), opts)
+}
+
+// changePackages 执行 apt-get 并记录变更
+func changePackages(ctx context.Context, root, operation string, packages, aptArgs []string, opts PackageOptions) error {
+	if opts.Key == "" {
+		opts.Key = DefaultPackagesKey
+	}
+	if opts.Log == nil {
+		opts.Log = io.Discard
+	}
+
+	bootfsLock, err := lock.Acquire(root)
+	if err != nil {
+		return err
+	}
+	defer bootfsLock.Release()
+
+	cfg, err := LoadConfig(root)
+	if err != nil {
+		return err
+	}
+
+	// 1. 在 chroot 中执行 apt-get
+	if err := runApt(ctx, root, cfg, aptArgs, opts.Log); err != nil {
+		return err
+	}
+
+	// 2. 更新 bootstrap.conf
+	for _, name := range packages {
+		if operation == OperationInstall && !cfg.HasPackage(name) {
+			cfg.AddPackage(opts.Key, name)
+		}
+		if operation == OperationRemove {
+			cfg.RemovePackage(name)
+		}
+	}
+	if err := cfg.SaveToBootfs(root); err != nil {
+		return err
+	}
+
+	// 3. 更新构建清单
+	return updateManifest(root, cfg, manifest.Change{
+		Time:      time.Now().UTC(),
+		Operation: operation,
+		Packages:  packages,
+		Command:   append([]string{"apt-get"}, aptArgs...),
+		User:      manifest.CurrentUser(),
+	})
+}
+
+// runApt 挂载 chroot 并执行 apt-get update 及指定的操作
+func runApt(ctx context.Context, root string, cfg *config.Config, aptArgs []string, log io.Writer) (err error) {
+	chroot, err := NewChroot(root)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := chroot.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}()
+
+	if err := chroot.Mount(ctx); err != nil {
+		return err
+	}
+	if err := chroot.BlockDaemons(ctx); err != nil {
+		return err
+	}
+	if err := chroot.UseHostResolver(ctx); err != nil {
+		return err
+	}
+
+	// 精简后的 bootfs 没有软件包列表
+	if err := chroot.Run(ctx, log, "apt-get", "update"); err != nil {
+		return fmt.Errorf("apt-get update failed: %v", err)
+	}
+	if err := chroot.Run(ctx, log, "apt-get", aptArgs...); err != nil {
+		return fmt.Errorf("apt-get %s failed: %v", aptArgs[0], err)
+	}
+
+	// 保持精简
+	for _, rule := range cfg.Slim {
+		switch rule {
+		case "cache":
+			if err := chroot.Run(ctx, log, "apt-get", "clean"); err != nil {
+				return fmt.Errorf("apt-get clean failed: %v", err)
+			}
+		case "apt-lists":
+			removeAptLists(root)
+		}
+	}
+
+	return nil
+}
+
+// removeAptLists 删除 apt 软件包列表，保留 lock 及 partial
+func removeAptLists(root string) {
+	lists, _ := filepath.Glob(filepath.Join(root, "var", "lib", "apt", "lists", "*"))
+	for _, path := range lists {
+		if name := filepath.Base(path); name != "lock" && name != "partial" {
+			audit.RemoveAll(path)
+		}
+	}
+}
+
+// updateManifest 重新读取已安装的软件包，记录配置及变更历史
+func updateManifest(root string, cfg *config.Config, change manifest.Change) error {
+	// 旧版本构建的 bootfs 没有构建清单
+	m := manifest.New("kboot", change.Time)
+	if _, err := os.Stat(filepath.Join(root, manifest.BootfsPath)); err == nil {
+		if m, err = manifest.LoadFromBootfs(root); err != nil {
+			return err
+		}
+	}
+
+	if err := m.SetConfig(cfg); err != nil {
+		return err
+	}
+	if err := m.LoadPackages(root); err != nil {
+		return err
+	}
+	m.History = append(m.History, change)
+
+	return m.Save(root)
+}
@@ -0,0 +1,374 @@
+package bootfs
+
+import (
+	"bufio"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rivsidn/kdev_bootstrap/pkg/config"
+	"github.com/rivsidn/kdev_bootstrap/pkg/dpkg"
+	"github.com/rivsidn/kdev_bootstrap/pkg/manifest"
+)
+
+// 检查项名称
+const (
+	CheckFiles     = "files"
+	CheckConfig    = "config"
+	CheckManifest  = "manifest"
+	CheckEssential = "essential"
+)
+
+// 问题级别，存在 error 时校验失败
+const (
+	LevelError   = "error"
+	LevelWarning = "warning"
+)
+
+// Problem 校验发现的问题
+type Problem struct {
+	Level   string `json:"level"`
+	Check   string `json:"check"`
+	Path    string `json:"path,omitempty"`
+	Message string `json:"message"`
+}
+
+// VerifyReport 校验结果
+type VerifyReport struct {
+	Packages int       `json:"packages"`
+	Files    int       `json:"files"`
+	Problems []Problem `json:"problems"`
+}
+
+// Failed 判断是否存在错误
+func (r *VerifyReport) Failed() bool {
+	for _, p := range r.Problems {
+		if p.Level == LevelError {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *VerifyReport) add(level, check, path, format string, args ...interface{}) {
+	r.Problems = append(r.Problems, Problem{Level: level, Check: check, Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+// VerifyOptions 校验选项
+type VerifyOptions struct {
+	SkipFiles bool // 不校验文件 md5
+}
+
+// essentialPaths 根文件系统必须存在的可执行文件
+var essentialPaths = []string{"/bin/sh", "/sbin/init"}
+
+// Verify 校验 bootfs 目录的完整性
+//
+// 依次检查：dpkg md5sums 与已安装文件是否一致（与 debsums 相同，不检查 conffiles），
+// bootstrap.conf 及构建清单与 dpkg 数据库是否一致，必要文件是否存在。
+func Verify(root string, opts VerifyOptions) (*VerifyReport, error) {
+	packages, err := dpkg.ReadInstalled(root)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &VerifyReport{Packages: len(packages)}
+
+	// 1. 已安装文件
+	if !opts.SkipFiles {
+		if err := verifyFiles(root, packages, report); err != nil {
+			return nil, err
+		}
+	}
+
+	// 2. bootstrap.conf
+	cfg, err := config.LoadConfig(filepath.Join(root, "etc", "bootstrap.conf"))
+	if err != nil {
+		report.add(LevelError, CheckConfig, "/etc/bootstrap.conf", "%v", err)
+	} else {
+		verifyConfig(cfg, packages, report)
+	}
+
+	// 3. 构建清单
+	verifyManifest(root, cfg, packages, report)
+
+	// 4. 必要文件
+	paths := essentialPaths
+	if cfg != nil && cfg.SetupScript != "" {
+		paths = append(paths, "/root/setup.sh")
+	}
+	for _, path := range paths {
+		info, err := os.Stat(ResolveInRoot(root, path))
+		switch {
+		case err != nil:
+			report.add(LevelError, CheckEssential, path, "missing")
+		case !info.Mode().IsRegular() || info.Mode().Perm()&0111 == 0:
+			report.add(LevelError, CheckEssential, path, "not an executable file")
+		}
+	}
+
+	return report, nil
+}
+
+// verifyFiles 按 dpkg md5sums 校验已安装文件
+func verifyFiles(root string, packages []dpkg.Package, report *VerifyReport) error {
+	filter := dpkg.LoadPathFilter(root)
+	slimmed := loadSlimFilters(root)
+	diversions := readDiversions(root)
+
+	for _, p := range packages {
+		sums, err := readMD5Sums(root, p)
+		if err != nil {
+			return err
+		}
+		if sums == nil {
+			report.add(LevelWarning, CheckFiles, "", "package %s has no md5sums", p.Name)
+			continue
+		}
+
+		for _, sum := range sums {
+			path := sum.path
+			if d, ok := diversions[path]; ok && d.owner != p.Name {
+				path = d.to
+			}
+
+			report.Files++
+			actual, err := fileMD5(ResolveInRoot(root, path))
+			switch {
+			case os.IsNotExist(err):
+				// path-exclude 排除的文件不会安装，精简删除的文件同样忽略
+				if !filter.Excluded(sum.path) && !slimmed.Excluded(sum.path) {
+					report.add(LevelError, CheckFiles, path, "missing (package %s)", p.Name)
+				}
+			case err != nil:
+				report.add(LevelError, CheckFiles, path, "unreadable (package %s): %v", p.Name, err)
+			case actual != sum.md5:
+				report.add(LevelError, CheckFiles, path, "modified (package %s)", p.Name)
+			}
+		}
+	}
+
+	return nil
+}
+
+// slimFilters 构建清单中记录的精简规则，每条规则单独匹配
+//
+// dpkg 不支持 path-exclude 时（1.15.8 之前）精简不写入 dpkg 配置，只能根据清单判断文件是否被删除。
+type slimFilters []*dpkg.PathFilter
+
+// loadSlimFilters 读取 bootfs 中构建清单记录的精简规则，没有清单时返回空
+func loadSlimFilters(root string) slimFilters {
+	m, err := manifest.LoadFromBootfs(root)
+	if err != nil {
+		return nil
+	}
+
+	var filters slimFilters
+	for _, result := range m.Slim {
+		filter := &dpkg.PathFilter{}
+		for _, pattern := range result.Exclude {
+			filter.Exclude(pattern)
+		}
+		for _, pattern := range result.Include {
+			filter.Include(pattern)
+		}
+		filters = append(filters, filter)
+	}
+	return filters
+}
+
+// Excluded 判断路径是否被任一精简规则删除
+func (f slimFilters) Excluded(path string) bool {
+	for _, filter := range f {
+		if filter.Excluded(path) {
+			return true
+		}
+	}
+	return false
+}
+
+// md5Sum md5sums 文件中的一行
+type md5Sum struct {
+	md5  string
+	path string // 以 "/" 开头
+}
+
+// readMD5Sums 读取软件包的 md5sums，多架构软件包的文件名带有 :arch，软件包没有 md5sums 时返回 nil
+func readMD5Sums(root string, p dpkg.Package) ([]md5Sum, error) {
+	info := filepath.Join(root, "var", "lib", "dpkg", "info")
+	var data []byte
+	var err error
+	for _, name := range []string{p.Name + ":" + p.Architecture, p.Name} {
+		if data, err = os.ReadFile(filepath.Join(info, name+".md5sums")); err == nil {
+			break
+		}
+	}
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read md5sums of %s: %v", p.Name, err)
+	}
+
+	var sums []md5Sum
+	for _, line := range strings.Split(string(data), "\n") {
+		sum, path, ok := strings.Cut(line, " ")
+		if !ok {
+			continue
+		}
+		sums = append(sums, md5Sum{md5: sum, path: "/" + strings.TrimLeft(path, " /")})
+	}
+	return sums, nil
+}
+
+// diversion dpkg-divert 记录
+type diversion struct {
+	to    string
+	owner string // 执行转移的软件包，":" 表示本地转移
+}
+
+// readDiversions 读取 /var/lib/dpkg/diversions，每条记录为三行：原路径、转移后的路径、软件包
+func readDiversions(root string) map[string]diversion {
+	diversions := make(map[string]diversion)
+	f, err := os.Open(filepath.Join(root, "var", "lib", "dpkg", "diversions"))
+	if err != nil {
+		return diversions
+	}
+	defer f.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	for i := 0; i+2 < len(lines); i += 3 {
+		diversions[lines[i]] = diversion{to: lines[i+1], owner: lines[i+2]}
+	}
+	return diversions
+}
+
+// fileMD5 计算文件的 md5
+func fileMD5(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := md5.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// verifyConfig 检查 bootstrap.conf 与 dpkg 数据库是否一致
+func verifyConfig(cfg *config.Config, packages []dpkg.Package, report *VerifyReport) {
+	installed := make(map[string]bool)
+	dpkgArch := ""
+	for _, p := range packages {
+		installed[p.Name] = true
+		if p.Name == "dpkg" {
+			dpkgArch = p.Architecture
+		}
+	}
+
+	if cfg.ArchCurrent == "" {
+		report.add(LevelError, CheckConfig, "/etc/bootstrap.conf", "arch_current is not set")
+	} else if dpkgArch != "" && dpkgArch != cfg.ArchCurrent {
+		report.add(LevelError, CheckConfig, "/etc/bootstrap.conf", "arch_current = %s but dpkg architecture is %s", cfg.ArchCurrent, dpkgArch)
+	}
+
+	for _, key := range cfg.PackageKeys() {
+		for _, name := range splitPackages(cfg.Packages[key]) {
+			if !installed[name] {
+				report.add(LevelError, CheckConfig, "/etc/bootstrap.conf", "%s lists %s, which is not installed", key, name)
+			}
+		}
+	}
+}
+
+// splitPackages 拆分软件包列表，去掉 :arch 及 =version 后缀
+func splitPackages(value string) []string {
+	var names []string
+	for _, item := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ' ' }) {
+		item, _, _ = strings.Cut(item, "=")
+		item, _, _ = strings.Cut(item, ":")
+		if item != "" {
+			names = append(names, item)
+		}
+	}
+	return names
+}
+
+// verifyManifest 检查构建清单与 dpkg 数据库及 bootstrap.conf 是否一致
+func verifyManifest(root string, cfg *config.Config, packages []dpkg.Package, report *VerifyReport) {
+	path := filepath.Join(root, manifest.BootfsPath)
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		report.add(LevelWarning, CheckManifest, "/"+manifest.BootfsPath, "no build manifest")
+		return
+	}
+	m, err := manifest.Load(path)
+	if err != nil {
+		report.add(LevelError, CheckManifest, "/"+manifest.BootfsPath, "%v", err)
+		return
+	}
+
+	for _, c := range comparePackages(m.Packages, packages) {
+		switch {
+		case c.OldVersion == "":
+			report.add(LevelError, CheckManifest, "", "package %s:%s %s is installed but not in the manifest", c.Name, c.Architecture, c.NewVersion)
+		case c.NewVersion == "":
+			report.add(LevelError, CheckManifest, "", "package %s:%s %s is in the manifest but not installed", c.Name, c.Architecture, c.OldVersion)
+		default:
+			report.add(LevelError, CheckManifest, "", "package %s:%s is %s but the manifest records %s", c.Name, c.Architecture, c.NewVersion, c.OldVersion)
+		}
+	}
+
+	if cfg == nil {
+		return
+	}
+	sections, err := cfg.Sections()
+	if err != nil {
+		return
+	}
+	for _, c := range compareConfig(m.Config, sections) {
+		report.add(LevelError, CheckManifest, "", "bootstrap.conf [%s] %s = %q but the manifest records %q", c.Section, c.Key, c.New, c.Old)
+	}
+}
+
+// ResolveInRoot 在根文件系统内解析路径中的符号链接，绝对链接相对于 root，不会指向宿主机文件
+func ResolveInRoot(root, path string) string {
+	resolved := "/"
+	rest := strings.Split(strings.Trim(path, "/"), "/")
+	for links := 0; len(rest) > 0; {
+		name := rest[0]
+		rest = rest[1:]
+
+		switch name {
+		case "", ".":
+			continue
+		case "..":
+			resolved = filepath.Dir(resolved)
+			continue
+		}
+
+		next := filepath.Join(resolved, name)
+		target, err := os.Readlink(filepath.Join(root, next))
+		if err != nil || links >= 40 {
+			resolved = next
+			continue
+		}
+		links++
+
+		if filepath.IsAbs(target) {
+			resolved = "/"
+		}
+		rest = append(strings.Split(strings.Trim(target, "/"), "/"), rest...)
+	}
+	return filepath.Join(root, resolved)
+}
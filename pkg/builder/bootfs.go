@@ -1,21 +1,46 @@
 package builder
 
 import (
+	"context"
 	"fmt"
+	"log/slog"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
+	"time"
 
+	"github.com/rivsidn/kdev_bootstrap/pkg/audit"
+	"github.com/rivsidn/kdev_bootstrap/pkg/cleanup"
 	"github.com/rivsidn/kdev_bootstrap/pkg/config"
+	"github.com/rivsidn/kdev_bootstrap/pkg/executor"
+	"github.com/rivsidn/kdev_bootstrap/pkg/manifest"
+	"github.com/rivsidn/kdev_bootstrap/pkg/metrics"
+	"github.com/rivsidn/kdev_bootstrap/pkg/owner"
+	"github.com/rivsidn/kdev_bootstrap/pkg/progress"
 	"github.com/rivsidn/kdev_bootstrap/pkg/utils"
 )
 
 // BootfsBuilder bootfs 构建器
 type BootfsBuilder struct {
-	Config     *config.Config
-	Arch       string
-	OutputDir  string
-	BootfsPath string
+	Config        *config.Config
+	Arch          string
+	OutputDir     string
+	BootfsPath    string
+	Provision     bool              // 构建时在 chroot 中执行 setup_script
+	Exec          executor.Executor // 执行外部命令，可以替换为 DryRun、Recorder、Replay
+	LogFile       string            // 构建日志，默认 <BootfsPath>.build.log
+	Progress      progress.Reporter // 接收阶段及进度事件，默认丢弃
+	SkipPreflight bool              // 不执行构建前的主机检查
+	MetricsFile   string            // 写入各阶段耗时的 Prometheus textfile，为空时不写入
+	Owner         *owner.Owner      // 清单、凭据及日志的属主，nil 时不修改；bootfs 中的文件不受影响
+	RecordFile    string            // Recorder 的记录文件，与其他产物一同修改属主
+
+	startTime       time.Time
+	timing          *metrics.Recorder // 统计各阶段及步骤的耗时
+	reporter        progress.Reporter // Progress 及 timing
+	debootstrapArgs []string
+	slimResults     []manifest.SlimResult
 }
 
 // NewBootfsBuilder 创建新的 bootfs 构建器
@@ -24,91 +49,209 @@ func NewBootfsBuilder(cfg *config.Config, arch, outputDir string) *BootfsBuilder
 		Config:    cfg,
 		Arch:      arch,
 		OutputDir: outputDir,
+		Exec:      executor.Default,
+		Progress:  progress.Discard,
 	}
 }
 
-// Build 构建 bootfs
-func (b *BootfsBuilder) Build() error {
+// Build 构建 bootfs，ctx 取消时终止正在执行的命令并清理
+func (b *BootfsBuilder) Build(ctx context.Context) (err error) {
+	b.startTime = time.Now()
+	b.timing = metrics.NewRecorder()
+	b.reporter = progress.Multi(b.Progress, b.timing)
+
 	// 1. 检查环境
 	if err := b.checkEnvironment(); err != nil {
 		return err
 	}
 
-	// 2. 设置 bootfs 路径
+	// 2. 设置 bootfs 路径并加锁，防止同时构建同一目录
 	b.setBootfsPath()
+	release, err := b.Exec.Lock(b.BootfsPath, false)
+	if err != nil {
+		return err
+	}
+	defer release()
 
-	// 3. 检查是否已存在
-	if utils.DirExists(b.BootfsPath) {
-		fmt.Printf("Directory %s already exists\n", b.BootfsPath)
-		if !utils.Confirm("Delete and recreate?") {
-			return fmt.Errorf("operation cancelled by user")
+	if b.LogFile == "" {
+		b.LogFile = b.BootfsPath + buildLogSuffix
+	}
+	closeLog := openBuildLog(b.Exec, b.LogFile)
+	defer func() { closeLog(err) }()
+	defer func() { chownOutputs(b.Exec, b.Owner, b.outputs()...) }()
+	defer func() {
+		finishMetrics(b.Exec, b.timing, b.MetricsFile, "kboot_build_bootfs", filepath.Base(b.BootfsPath), err)
+	}()
+
+	// 检查主机是否满足构建条件
+	if err := b.preflight(ctx); err != nil {
+		return err
+	}
+
+	// 3. 检查是否已存在，生成计划时不询问
+	if b.Exec.Exists(b.BootfsPath) {
+		if !executor.Simulated(b.Exec) {
+			fmt.Printf("Directory %s already exists\n", b.BootfsPath)
+			if !utils.Confirm("Delete and recreate?") {
+				return fmt.Errorf("operation cancelled by user")
+			}
 		}
-		if err := os.RemoveAll(b.BootfsPath); err != nil {
+		if err := b.Exec.RemoveAll(b.BootfsPath); err != nil {
 			return fmt.Errorf("failed to remove directory: %v", err)
 		}
 	}
 
 	// 4. 创建目录
-	if err := utils.CreateDir(b.BootfsPath); err != nil {
-		return err
+	if err := b.Exec.MkdirAll(b.BootfsPath, 0755); err != nil {
+		return fmt.Errorf("failed to create directory %s: %v", b.BootfsPath, err)
 	}
 
 	// 5. 执行 debootstrap（包含额外的包）
-	if err := b.runDebootstrap(); err != nil {
+	if err := runStage(ctx, b.Config, b.reporter, config.StageDebootstrap, b.runDebootstrap); err != nil {
+		return err
+	}
+
+	// 6. 生成客户机配置
+	if err := b.configureGuest(); err != nil {
+		return err
+	}
+
+	// 7. 复制 overlay 目录
+	if err := b.applyOverlays(); err != nil {
 		return err
 	}
 
-	// 6. 保存配置文件
+	// 8. 保存配置文件
 	b.Config.ArchCurrent = b.Arch
-	if err := b.Config.SaveToBootfs(b.BootfsPath); err != nil {
+	if err := b.saveConfig(); err != nil {
 		return err
 	}
 
-	// 7. 安装启动脚本（替代原来的配置步骤）
+	// 9. 安装启动脚本（替代原来的配置步骤）
 	if err := b.installStartupScript(); err != nil {
 		return fmt.Errorf("failed to install startup script: %v", err)
 	}
 
+	// 10. 构建时配置（可选）
+	if err := runStage(ctx, b.Config, b.reporter, config.StageProvision, b.provision); err != nil {
+		return fmt.Errorf("provisioning failed: %v", err)
+	}
+
+	// 11. 精简 bootfs
+	results, err := b.slim(ctx)
+	if err != nil {
+		return err
+	}
+	b.slimResults = results
+
+	// 12. 写入构建清单
+	if err := b.writeManifest(); err != nil {
+		return err
+	}
+
+	if executor.Simulated(b.Exec) {
+		return nil
+	}
 	fmt.Printf("\nBootfs build successful: %s\n", b.BootfsPath)
-	fmt.Printf("Setup script installed: /root/setup.sh\n")
-	fmt.Printf("Run after boot: bash /root/setup.sh\n")
+	if utils.FileExists(CredentialsPath(b.BootfsPath)) {
+		fmt.Printf("Root credentials: %s\n", CredentialsPath(b.BootfsPath))
+	}
+	fmt.Printf("Build manifest: %s\n", manifest.SidecarPath(b.BootfsPath))
+	if b.Config.SetupScript != "" {
+		fmt.Printf("Setup script installed: /root/setup.sh\n")
+		if !b.Provision {
+			fmt.Printf("Run after boot: bash /root/setup.sh\n")
+		}
+	}
+	b.timing.Metrics().Print(os.Stdout)
 	return nil
 }
 
-// checkEnvironment 检查环境
+// Plan 返回构建将要执行的命令及修改的文件，不做任何修改，不需要 root 权限
+//
+// 计划由 Build 使用 DryRun 执行器生成，与构建执行同一流程。debootstrap 之后的步骤
+// 假定 bootfs 是 debootstrap 刚生成的（配置文件读取的是宿主机上尚不存在的文件），
+// 依赖 bootfs 内容的步骤（如网络配置方式、是否安装了 sshd）以此为准。
+func (b *BootfsBuilder) Plan(ctx context.Context) (*executor.Plan, error) {
+	plan := executor.NewPlan("kboot_build_bootfs")
+	plan.Write(audit.Path(), "audit log, appended when run as root")
+
+	b.Exec = executor.DryRun{Plan: plan}
+	b.SkipPreflight = true
+	if err := b.Build(ctx); err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// checkEnvironment 检查环境，生成计划时不需要 root 权限
 func (b *BootfsBuilder) checkEnvironment() error {
 	// 检查是否为 root
-	if !utils.CheckRoot() {
+	if !executor.Simulated(b.Exec) && !CheckRoot() {
 		return fmt.Errorf("please run with sudo or root privileges")
 	}
 
 	return nil
 }
 
-// setBootfsPath 设置 bootfs 路径
+// setBootfsPath 设置 bootfs 路径，转换为绝对路径，debootstrap 及构建计划中使用同一路径
 func (b *BootfsBuilder) setBootfsPath() {
 	if b.OutputDir != "" {
 		b.BootfsPath = b.OutputDir
-		return
-	}
+	} else {
+		b.OutputDir = "."
 
-	b.OutputDir = "."
+		dirName := fmt.Sprintf("%s-%s-%s-bootfs",
+			strings.ToLower(b.Config.Distribution),
+			b.Config.Version,
+			b.Arch)
 
-	dirName := fmt.Sprintf("%s-%s-%s-bootfs",
-		strings.ToLower(b.Config.Distribution),
-		b.Config.Version,
-		b.Arch)
+		b.BootfsPath = filepath.Join(b.OutputDir, dirName)
+	}
 
-	b.BootfsPath = filepath.Join(b.OutputDir, dirName)
+	if abs, err := filepath.Abs(b.BootfsPath); err == nil {
+		b.BootfsPath = abs
+	}
 }
 
 // runDebootstrap 执行 debootstrap
-func (b *BootfsBuilder) runDebootstrap() error {
-	fmt.Println("\nRunning debootstrap...")
+func (b *BootfsBuilder) runDebootstrap(ctx context.Context) error {
+	slog.Info("Running debootstrap", "target", b.BootfsPath)
 
+	args, err := b.debootstrapCommand()
+	if err != nil {
+		return err
+	}
+	if packages := b.Config.GetAllPackages(); len(packages) > 0 {
+		slog.Info("Including packages", "packages", strings.Join(packages, ","))
+	}
+	b.debootstrapArgs = append([]string{"debootstrap"}, args...)
+
+	// debootstrap 在目标目录中挂载 proc、sys 等，被中断时不会卸载
+	for _, dir := range []string{"dev", "dev/pts", "sys", "proc"} {
+		entry, err := cleanup.Register(ctx, b.Exec, cleanup.Unmount(filepath.Join(b.BootfsPath, dir)), nil)
+		if err != nil {
+			return err
+		}
+		defer entry.Release()
+	}
+
+	// 解析 I:、W:、E: 输出报告进度
+	w := progress.NewWriter(b.reporter, config.StageDebootstrap, &progress.Debootstrap{})
+	err = b.Exec.RunWithLog(ctx, w, "debootstrap", args...)
+	w.Flush()
+	if err != nil {
+		return fmt.Errorf("debootstrap failed: %v", err)
+	}
+
+	return nil
+}
+
+// debootstrapCommand 返回 debootstrap 的参数
+func (b *BootfsBuilder) debootstrapCommand() ([]string, error) {
 	suite := b.Config.GetSuite()
 	if suite == "" {
-		return fmt.Errorf("Not find the valid suite, add first")
+		return nil, fmt.Errorf("Not find the valid suite, add first")
 	}
 
 	mirror := b.Config.Mirror
@@ -122,7 +265,6 @@ func (b *BootfsBuilder) runDebootstrap() error {
 	// 获取所有要安装的包
 	packages := b.Config.GetAllPackages()
 	if len(packages) > 0 {
-		fmt.Printf("Including packages: %s\n", strings.Join(packages, ", "))
 		args = append(args, "--include="+strings.Join(packages, ","))
 	}
 
@@ -131,13 +273,7 @@ func (b *BootfsBuilder) runDebootstrap() error {
 		args = append(args, "--no-check-gpg")
 	}
 
-	args = append(args, suite, b.BootfsPath, mirror)
-
-	if err := utils.RunCommand("debootstrap", args...); err != nil {
-		return fmt.Errorf("debootstrap failed: %v", err)
-	}
-
-	return nil
+	return append(args, suite, b.BootfsPath, mirror), nil
 }
 
 // installStartupScript 安装启动脚本
@@ -146,11 +282,11 @@ func (b *BootfsBuilder) installStartupScript() error {
 	scriptName := b.Config.SetupScript
 	if scriptName == "" {
 		// 没有配置脚本，跳过
-		fmt.Println("No startup script configured, skipping configuration")
+		slog.Info("No startup script configured, skipping configuration")
 		return nil
 	}
 
-	fmt.Printf("Installing startup script: %s\n", scriptName)
+	slog.Info("Installing startup script", "script", scriptName)
 
 	// 脚本源路径（相对于配置文件）
 	configDir := filepath.Dir(b.Config.ConfigPath)
@@ -159,27 +295,62 @@ func (b *BootfsBuilder) installStartupScript() error {
 	if !utils.FileExists(scriptPath) {
 		return fmt.Errorf("startup script not found: %s", scriptPath)
 	}
-
-	// 固定安装到 /root/setup.sh
-	targetPath := filepath.Join(b.BootfsPath, "root", "setup.sh")
-
-	// 确保目标目录存在
-	if err := os.MkdirAll(filepath.Dir(targetPath), 0755); err != nil {
-		return fmt.Errorf("failed to create target directory: %v", err)
+	data, err := os.ReadFile(scriptPath)
+	if err != nil {
+		return fmt.Errorf("failed to read script: %v", err)
 	}
 
-	// 复制脚本
-	if err := utils.CopyFile(scriptPath, targetPath); err != nil {
+	// 固定安装到 /root/setup.sh，带执行权限
+	if err := b.writeBootfsFile("/root/setup.sh", string(data), 0755); err != nil {
 		return fmt.Errorf("failed to copy script: %v", err)
 	}
 
-	// 设置执行权限
-	if err := os.Chmod(targetPath, 0755); err != nil {
-		return fmt.Errorf("failed to set script permissions: %v", err)
-	}
+	slog.Info("Startup script installed", "path", "/root/setup.sh")
 
-	fmt.Printf("Startup script installed to: /root/setup.sh\n")
+	return nil
+}
 
+// writeManifest 记录构建来源：工具版本、主机、配置、debootstrap 命令及已安装的软件包
+func (b *BootfsBuilder) writeManifest() error {
+	m := manifest.New("kboot_build_bootfs", b.startTime)
+	if err := m.SetConfig(b.Config); err != nil {
+		return fmt.Errorf("failed to record config in manifest: %v", err)
+	}
+	m.Debootstrap = b.debootstrapArgs
+	m.Slim = b.slimResults
+	timing := b.timing.Metrics()
+	m.Metrics = &timing
+	// 生成计划时 debootstrap 没有执行，软件包未知
+	if !executor.Simulated(b.Exec) {
+		if err := m.LoadPackages(b.BootfsPath); err != nil {
+			return err
+		}
+	}
+	m.EndTime = time.Now().UTC()
+
+	data, err := m.Marshal()
+	if err != nil {
+		return err
+	}
+	if _, err := b.createBootfsDir(path.Dir("/" + manifest.BootfsPath)); err != nil {
+		return err
+	}
+	for _, file := range []string{b.bootfsPath(manifest.BootfsPath), manifest.SidecarPath(b.BootfsPath)} {
+		if err := b.Exec.WriteFile(file, data, 0644); err != nil {
+			return fmt.Errorf("failed to write manifest %s: %v", file, err)
+		}
+	}
 	return nil
 }
 
+// saveConfig 将配置保存到 bootfs 的 /etc/bootstrap.conf
+func (b *BootfsBuilder) saveConfig() error {
+	data, err := b.Config.INI()
+	if err != nil {
+		return err
+	}
+	if err := b.writeBootfsFile("/etc/bootstrap.conf", string(data), 0644); err != nil {
+		return fmt.Errorf("failed to save configuration file: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,228 @@
+package builder
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rivsidn/kdev_bootstrap/pkg/audit"
+	"github.com/rivsidn/kdev_bootstrap/pkg/cleanup"
+	"github.com/rivsidn/kdev_bootstrap/pkg/executor"
+)
+
+// TestMain 将清理记录及审计日志放到临时目录，构建不需要 root 权限
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "kboot-builder-test-")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	cleanup.JournalDir = filepath.Join(dir, "cleanup")
+	audit.SetPath(filepath.Join(dir, "audit.jsonl"))
+	CheckRoot = func() bool { return true }
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+const testConfig = `[ubuntu-16.04]
+distribution   = ubuntu
+version        = 16.04
+arch_supported = amd64
+arch_current   = amd64
+mirror         = http://archive.ubuntu.com/ubuntu/
+`
+
+const testStatus = `Package: bash
+Status: install ok installed
+Architecture: amd64
+Version: 4.3-14ubuntu1
+Description: GNU Bourne Again SHell
+`
+
+// writeBootfs 在 dir 下创建只包含 bootstrap.conf 及 dpkg 状态的 bootfs
+func writeBootfs(t *testing.T, dir string) string {
+	t.Helper()
+	root := filepath.Join(dir, "bootfs")
+	files := map[string]string{
+		"etc/bootstrap.conf":  testConfig,
+		"var/lib/dpkg/status": testStatus,
+	}
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+// checkTempRemoved 检查 Replay 的计划创建了临时文件，并在构建结束前删除
+func checkTempRemoved(t *testing.T, plan *executor.Plan, path string) {
+	t.Helper()
+	created, removed := false, false
+	for _, step := range plan.Steps {
+		if step.Path != path {
+			continue
+		}
+		switch step.Action {
+		case executor.ActionWrite, executor.ActionMkdir:
+			created = true
+		case executor.ActionDelete:
+			removed = created
+		}
+	}
+	if !created {
+		t.Errorf("%s not created", path)
+	} else if !removed || plan.Exists(path) {
+		t.Errorf("%s not removed", path)
+	}
+}
+
+// checkWritten 检查 Replay 的计划写入了构建日志、耗时统计等文件
+func checkWritten(t *testing.T, plan *executor.Plan, paths ...string) {
+	t.Helper()
+	for _, path := range paths {
+		if !plan.Exists(path) {
+			t.Errorf("%s not written", path)
+		}
+	}
+}
+
+// checkCleanedUp 检查构建后没有遗留的撤销操作
+func checkCleanedUp(t *testing.T) {
+	t.Helper()
+	leftovers, err := cleanup.Leftovers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, _ := filepath.Glob(filepath.Join(cleanup.JournalDir, "*.json"))
+	if len(leftovers) > 0 || len(files) > 0 {
+		t.Errorf("cleanup journal left behind: %v", files)
+	}
+}
+
+func TestDockerBuildReplay(t *testing.T) {
+	dir := t.TempDir()
+	bootfsDir := writeBootfs(t, dir)
+
+	replay, err := executor.LoadReplay("testdata/docker.rec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	labelPrefix := SBOMLabel + "="
+	replay.Normalize = func(arg string) string {
+		if strings.HasPrefix(arg, labelPrefix) {
+			return labelPrefix + "SBOM"
+		}
+		return strings.ReplaceAll(arg, dir, "TMP")
+	}
+
+	b, err := NewDockerBuilder(bootfsDir, "", "kboot/test:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Exec = replay
+	b.SkipPreflight = true
+	b.LogFile = filepath.Join(dir, "docker.build.log")
+	b.MetricsFile = filepath.Join(dir, "docker.prom")
+	if err := b.Build(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := replay.Done(); err != nil {
+		t.Fatal(err)
+	}
+
+	checkTempRemoved(t, replay.Plan, filepath.Join(dir, "Dockerfile.XXXXXX.tmp"))
+	checkWritten(t, replay.Plan, b.LogFile, b.MetricsFile)
+	checkCleanedUp(t)
+}
+
+func TestDockerBuildReplayMismatch(t *testing.T) {
+	dir := t.TempDir()
+	bootfsDir := writeBootfs(t, dir)
+
+	replay, err := executor.LoadReplay("testdata/docker.rec")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := NewDockerBuilder(bootfsDir, "", "kboot/other:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Exec = replay
+	b.SkipPreflight = true
+	b.LogFile = filepath.Join(dir, "docker.build.log")
+	err = b.Build(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "replay: command 1 mismatch") {
+		t.Fatalf("Build = %v, want replay mismatch", err)
+	}
+	if matches, _ := filepath.Glob(filepath.Join(dir, tempDockerfilePattern)); len(matches) > 0 {
+		t.Errorf("temporary Dockerfile written by replay: %v", matches)
+	}
+	checkCleanedUp(t)
+}
+
+func TestQemuBuildReplay(t *testing.T) {
+	for _, rsync := range []bool{true, false} {
+		t.Run(fmt.Sprintf("rsync=%v", rsync), func(t *testing.T) {
+			testQemuBuildReplay(t, rsync)
+		})
+	}
+}
+
+func testQemuBuildReplay(t *testing.T, rsync bool) {
+	dir := t.TempDir()
+	t.Setenv("TMPDIR", dir)
+	bootfsDir := writeBootfs(t, dir)
+	image := filepath.Join(dir, "test.img")
+	mountPoint := filepath.Join(dir, qemuMountPrefix+"XXXXXX")
+
+	defer func(check func(string) bool) { CheckCommand = check }(CheckCommand)
+	CheckCommand = func(name string) bool { return rsync }
+
+	copyCmd := executor.Record{Name: "cp", Args: []string{"-a", bootfsDir + "/.", mountPoint + "/"}}
+	if rsync {
+		copyCmd = executor.Record{Name: "rsync", Args: []string{"-av", "--info=progress2", "--devices", "--specials",
+			"--exclude=/proc/*", "--exclude=/sys/*", "--exclude=/tmp/*", bootfsDir + "/", mountPoint + "/"}}
+	}
+	replay := executor.NewReplay([]executor.Record{
+		{Name: "qemu-img", Args: []string{"create", "-f", "raw", image, "1G"}},
+		{Name: "losetup", Args: []string{"-f"}, Output: "/dev/loop7\n"},
+		{Name: "losetup", Args: []string{"/dev/loop7", image}},
+		{Name: "mkfs.ext3", Args: []string{"-F", "/dev/loop7"}},
+		{Name: "losetup", Args: []string{"--noheadings", "--output", "BACK-FILE", "/dev/loop7"}, Output: image + "\n"},
+		{Name: "losetup", Args: []string{"-d", "/dev/loop7"}},
+		{Name: "mount", Args: []string{"-o", "loop", image, mountPoint}},
+		copyCmd,
+		{Name: "mountpoint", Args: []string{"-q", mountPoint}},
+		{Name: "umount", Args: []string{mountPoint}},
+		{Name: "findmnt", Args: []string{"--json", "--list", "--output", "TARGET"}, Output: `{"filesystems": [{"target": "/"}]}` + "\n"},
+	})
+
+	b, err := NewQemuBuilder(bootfsDir, image, "1G")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Exec = replay
+	b.SkipPreflight = true
+	b.MetricsFile = filepath.Join(dir, "qemu.prom")
+	if err := b.Build(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := replay.Done(); err != nil {
+		t.Fatal(err)
+	}
+
+	checkTempRemoved(t, replay.Plan, mountPoint)
+	checkWritten(t, replay.Plan, append([]string{image + buildLogSuffix, b.MetricsFile}, b.sbomFiles...)...)
+	checkCleanedUp(t)
+}
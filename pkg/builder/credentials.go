@@ -0,0 +1,221 @@
+package builder
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+
+	"github.com/rivsidn/kdev_bootstrap/pkg/bootfs"
+	"github.com/rivsidn/kdev_bootstrap/pkg/config"
+	"github.com/rivsidn/kdev_bootstrap/pkg/utils"
+)
+
+// passwordAlphabet 随机密码使用的字符，去掉了容易混淆的字符
+const passwordAlphabet = "abcdefghijkmnpqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// CredentialsPath 返回保存 bootfs 凭据的宿主机文件路径
+func CredentialsPath(bootfsPath string) string {
+	return filepath.Clean(bootfsPath) + bootfs.CredentialsSuffix
+}
+
+// configureCredentials 设置 root 密码及 authorized_keys，返回生效的 sshd 配置
+//
+// 默认（secure）生成随机 root 密码并保存到宿主机 ${bootfs}.credentials，
+// 注入调用者的 SSH 公钥；insecure-lab 需要在配置中显式选择。
+// bootfs 未安装 sshd 且没有 [ssh] 配置时返回 nil。
+func (b *BootfsBuilder) configureCredentials() (*config.SSHConfig, error) {
+	users := b.Config.Guest.Users
+	lab := b.Config.SecurityProfile == config.ProfileInsecureLab
+
+	if lab {
+		slog.Warn("security_profile = insecure-lab, root has an EMPTY password and sshd accepts it")
+	}
+
+	// 1. root 密码
+	if err := b.configureRootPassword(users, lab); err != nil {
+		return nil, err
+	}
+
+	// 2. authorized_keys
+	keys, err := b.installAuthorizedKeys(users)
+	if err != nil {
+		return nil, err
+	}
+
+	// 3. sshd 配置
+	explicit := b.Config.Guest.SSH
+	if explicit == nil && !b.bootfsHas("/etc/ssh/sshd_config") {
+		return nil, nil
+	}
+	return effectiveSSHConfig(explicit, lab, keys > 0)
+}
+
+// configureRootPassword 设置 root 密码
+func (b *BootfsBuilder) configureRootPassword(users *config.UsersConfig, lab bool) error {
+	db, err := b.loadAccountDB()
+	if err != nil {
+		return err
+	}
+
+	// 删除之前构建留下的凭据文件
+	if path := CredentialsPath(b.BootfsPath); b.Exec.Exists(path) {
+		b.Exec.Remove(path)
+	}
+
+	switch {
+	case users.PasswordHashes["root"] != "":
+		// 由 configureUsers 写入
+		return nil
+	case lab:
+		if err := db.setPasswordHash("root", ""); err != nil {
+			return err
+		}
+	case users.RootPassword == config.RootPasswordLocked:
+		slog.Info("Locking root password")
+		if err := db.setPasswordHash("root", "!"); err != nil {
+			return err
+		}
+	default:
+		password, err := utils.RandomString(16, passwordAlphabet)
+		if err != nil {
+			return err
+		}
+		hash, err := b.hashPassword(password)
+		if err != nil {
+			return err
+		}
+		if err := db.setPasswordHash("root", hash); err != nil {
+			return err
+		}
+
+		path := CredentialsPath(b.BootfsPath)
+		content := fmt.Sprintf("# Generated by kboot_build_bootfs for %s\nroot:%s\n", b.BootfsPath, password)
+		if err := b.Exec.WriteFile(path, []byte(content), 0600); err != nil {
+			return fmt.Errorf("failed to save credentials: %v", err)
+		}
+		slog.Info("Generated root password saved", "path", path)
+	}
+
+	return db.save()
+}
+
+// hashPassword 生成发行版支持的密码哈希，Ubuntu 8.04 之前的 glibc 不支持 SHA-512 crypt
+func (b *BootfsBuilder) hashPassword(password string) (string, error) {
+	if b.Config.VersionAtLeast("8.04") {
+		return utils.SHA512Crypt(password, "")
+	}
+	return utils.MD5Crypt(password, "")
+}
+
+// installAuthorizedKeys 写入 /root/.ssh/authorized_keys，返回公钥数量
+func (b *BootfsBuilder) installAuthorizedKeys(users *config.UsersConfig) (int, error) {
+	var files []string
+	for _, item := range users.AuthorizedKeys {
+		switch item {
+		case "none":
+			return 0, nil
+		case "auto":
+			files = append(files, invokingUserPublicKeys()...)
+		default:
+			files = append(files, b.Config.ResolvePath(item))
+		}
+	}
+
+	var keys []string
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return 0, fmt.Errorf("failed to read public key %s: %v", file, err)
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+				keys = append(keys, line)
+			}
+		}
+	}
+	if len(keys) == 0 {
+		return 0, nil
+	}
+
+	sshDir, err := b.createBootfsDir("/root/.ssh")
+	if err != nil {
+		return 0, err
+	}
+	if err := b.Exec.Chmod(sshDir, 0700); err != nil {
+		return 0, err
+	}
+	if err := b.writeBootfsFile("/root/.ssh/authorized_keys", strings.Join(keys, "\n")+"\n", 0600); err != nil {
+		return 0, err
+	}
+
+	slog.Info("Installed public keys into /root/.ssh/authorized_keys", "count", len(keys))
+	return len(keys), nil
+}
+
+// invokingUserPublicKeys 返回调用者（sudo 前的用户）~/.ssh 下的公钥文件
+func invokingUserPublicKeys() []string {
+	home := ""
+	if name := os.Getenv("SUDO_USER"); name != "" {
+		if u, err := user.Lookup(name); err == nil {
+			home = u.HomeDir
+		}
+	}
+	if home == "" {
+		home, _ = os.UserHomeDir()
+	}
+	if home == "" {
+		return nil
+	}
+
+	files, _ := filepath.Glob(filepath.Join(home, ".ssh", "*.pub"))
+	return files
+}
+
+// effectiveSSHConfig 合并安全配置默认值与 [ssh] 中显式设置的选项
+func effectiveSSHConfig(explicit *config.SSHConfig, lab, haveKeys bool) (*config.SSHConfig, error) {
+	ssh := &config.SSHConfig{}
+	if lab {
+		ssh.PermitRootLogin = "yes"
+		ssh.PasswordAuthentication = "yes"
+		ssh.PermitEmptyPasswords = "yes"
+		ssh.UsePAM = "no"
+	} else {
+		ssh.PermitEmptyPasswords = "no"
+		if haveKeys {
+			// without-password 是 prohibit-password 的旧名称，所有 OpenSSH 版本都支持
+			ssh.PermitRootLogin = "without-password"
+			ssh.PasswordAuthentication = "no"
+		} else {
+			ssh.PermitRootLogin = "yes"
+			ssh.PasswordAuthentication = "yes"
+		}
+	}
+
+	if explicit == nil {
+		return ssh, nil
+	}
+
+	if !lab && (strings.EqualFold(explicit.PermitEmptyPasswords, "yes") || strings.EqualFold(explicit.UsePAM, "no")) {
+		return nil, fmt.Errorf("[ssh] permit_empty_passwords = yes and use_pam = no require security_profile = %s", config.ProfileInsecureLab)
+	}
+
+	for _, f := range []struct {
+		dst *string
+		src string
+	}{
+		{&ssh.Port, explicit.Port},
+		{&ssh.PermitRootLogin, explicit.PermitRootLogin},
+		{&ssh.PasswordAuthentication, explicit.PasswordAuthentication},
+		{&ssh.PermitEmptyPasswords, explicit.PermitEmptyPasswords},
+		{&ssh.UsePAM, explicit.UsePAM},
+	} {
+		if f.src != "" {
+			*f.dst = f.src
+		}
+	}
+
+	return ssh, nil
+}
@@ -1,12 +1,20 @@
 package builder
 
 import (
+	"context"
 	"fmt"
+	"log/slog"
 	"os"
 	"path/filepath"
-	"strings"
 
+	"github.com/rivsidn/kdev_bootstrap/pkg/audit"
+	"github.com/rivsidn/kdev_bootstrap/pkg/bootfs"
+	"github.com/rivsidn/kdev_bootstrap/pkg/cleanup"
 	"github.com/rivsidn/kdev_bootstrap/pkg/config"
+	"github.com/rivsidn/kdev_bootstrap/pkg/executor"
+	"github.com/rivsidn/kdev_bootstrap/pkg/metrics"
+	"github.com/rivsidn/kdev_bootstrap/pkg/owner"
+	"github.com/rivsidn/kdev_bootstrap/pkg/progress"
 	"github.com/rivsidn/kdev_bootstrap/pkg/utils"
 )
 
@@ -16,17 +24,25 @@ type DockerBuilder struct {
 	BootfsPath     string
 	DockerfilePath string
 	ImageName      string
+	Exec           executor.Executor // 执行外部命令，可以替换为 DryRun、Recorder、Replay
+	LogFile        string            // 构建日志，默认为当前目录下 <镜像名称>.build.log
+	Progress       progress.Reporter // 接收阶段及进度事件，默认丢弃
+	SkipPreflight  bool              // 不执行构建前的主机检查
+	MetricsFile    string            // 写入各阶段耗时的 Prometheus textfile，为空时不写入
+	Owner          *owner.Owner      // 日志的属主，nil 时不修改
+	RecordFile     string            // Recorder 的记录文件，与日志一同修改属主
+
+	archive    bool              // BootfsPath 是否为 bootfs 归档
+	dockerfile *cleanup.Entry    // 临时 Dockerfile
+	timing     *metrics.Recorder // 统计各阶段及步骤的耗时
+	reporter   progress.Reporter // Progress 及 timing
+	built      bool              // 镜像是否构建成功
 }
 
 // NewDockerBuilder 创建新的 Docker 构建器
 func NewDockerBuilder(bootfsPath string, dockerfilePath string, imageName string) (*DockerBuilder, error) {
-	// 加载配置文件
-	configPath := filepath.Join(bootfsPath, "etc", "bootstrap.conf")
-	if !utils.FileExists(configPath) {
-		return nil, fmt.Errorf("configuration file not found: %s", configPath)
-	}
-
-	cfg, err := config.LoadConfig(configPath)
+	// 加载配置文件（bootfs 目录或归档）
+	cfg, err := bootfs.LoadConfig(bootfsPath)
 	if err != nil {
 		return nil, err
 	}
@@ -34,19 +50,42 @@ func NewDockerBuilder(bootfsPath string, dockerfilePath string, imageName string
 	return &DockerBuilder{
 		Config:         cfg,
 		BootfsPath:     bootfsPath,
+		archive:        bootfs.IsArchive(bootfsPath),
 		DockerfilePath: dockerfilePath,
 		ImageName:      imageName,
+		Exec:           executor.Default,
+		Progress:       progress.Discard,
 	}, nil
 }
 
-// Build 构建 Docker 镜像
-func (b *DockerBuilder) Build() error {
+// Build 构建 Docker 镜像，ctx 取消时终止正在执行的命令并清理
+func (b *DockerBuilder) Build(ctx context.Context) (err error) {
+	b.timing = metrics.NewRecorder()
+	b.reporter = progress.Multi(b.Progress, b.timing)
+
 	// 1. 检查环境
 	if err := b.checkEnvironment(); err != nil {
 		return err
 	}
 
-	// 2. 设置镜像名称
+	// 2. 读取期间禁止修改 bootfs，之后解压 bootfs 归档
+	release, err := b.Exec.Lock(b.BootfsPath, true)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	if b.archive {
+		dir, cleanup, err := extractBootfs(ctx, b.Exec, b.BootfsPath)
+		if err != nil {
+			return err
+		}
+		defer cleanup()
+		b.BootfsPath = dir
+		b.archive = false
+	}
+
+	// 3. 设置镜像名称
 	if b.ImageName == "" {
 		arch := b.Config.ArchCurrent
 		if arch == "" {
@@ -54,37 +93,69 @@ func (b *DockerBuilder) Build() error {
 		}
 		b.ImageName = b.Config.GetImageName(arch)
 	}
+	if b.LogFile == "" {
+		b.LogFile = imageLogPath(b.ImageName)
+	}
+	closeLog := openBuildLog(b.Exec, b.LogFile)
+	defer func() { closeLog(err) }()
+	defer func() { chownOutputs(b.Exec, b.Owner, b.outputs()...) }()
+	defer func() { finishMetrics(b.Exec, b.timing, b.MetricsFile, "kboot_build_docker", b.ImageName, err) }()
 
-	// 3. 创建 Dockerfile
-	if err := b.createDockerfile(); err != nil {
+	// 检查主机是否满足构建条件
+	if err := b.preflight(ctx); err != nil {
 		return err
 	}
 
-	// 4. 构建 Docker 镜像
-	if err := b.buildImage(); err != nil {
+	// 4. 创建 Dockerfile，临时文件在退出时删除
+	if err := b.createDockerfile(ctx); err != nil {
 		return err
 	}
+	defer b.dockerfile.Release()
 
-	// 5. 清理临时文件
-	if b.DockerfilePath != "" && strings.HasPrefix(filepath.Base(b.DockerfilePath), "Dockerfile.tmp") {
-		os.Remove(b.DockerfilePath)
+	// 5. 构建 Docker 镜像
+	if err := runStage(ctx, b.Config, b.reporter, config.StageDockerBuild, b.buildImage); err != nil {
+		return err
 	}
+	b.built = true
 
+	if executor.Simulated(b.Exec) {
+		return nil
+	}
 	fmt.Printf("\nDocker image build successful: %s\n", b.ImageName)
 	fmt.Printf("   Usage: docker run -it --rm %s /bin/bash\n", b.ImageName)
+	b.timing.Metrics().Print(os.Stdout)
 
 	return nil
 }
 
+// Plan 返回构建将要执行的命令及修改的文件，不做任何修改，不需要 root 权限
+//
+// 计划由 Build 使用 DryRun 执行器生成，bootfs 归档的内容在计划中不会解压，SBOM 标签以占位符代替。
+func (b *DockerBuilder) Plan(ctx context.Context) (*executor.Plan, error) {
+	plan := executor.NewPlan("kboot_build_docker")
+	plan.Write(audit.Path(), "audit log, appended when run as root")
+
+	b.Exec = executor.DryRun{Plan: plan}
+	b.SkipPreflight = true
+	if err := b.Build(ctx); err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
 // checkEnvironment 检查环境
 func (b *DockerBuilder) checkEnvironment() error {
-	// 检查 bootfs 目录
-	if !utils.DirExists(b.BootfsPath) {
+	// 检查 bootfs 目录或归档
+	if b.archive {
+		if !utils.FileExists(b.BootfsPath) {
+			return fmt.Errorf("bootfs archive does not exist: %s", b.BootfsPath)
+		}
+	} else if !utils.DirExists(b.BootfsPath) {
 		return fmt.Errorf("bootfs directory does not exist: %s", b.BootfsPath)
 	}
 
-	// 检查是否为 root
-	if !utils.CheckRoot() {
+	// 检查是否为 root，生成计划时不需要
+	if !executor.Simulated(b.Exec) && !CheckRoot() {
 		return fmt.Errorf("please run with sudo or root privileges")
 	}
 
@@ -92,16 +163,12 @@ func (b *DockerBuilder) checkEnvironment() error {
 }
 
 // createDockerfile 创建 Dockerfile
-func (b *DockerBuilder) createDockerfile() error {
+func (b *DockerBuilder) createDockerfile(ctx context.Context) error {
 	if b.DockerfilePath != "" && utils.FileExists(b.DockerfilePath) {
-		fmt.Printf("Using existing Dockerfile: %s\n", b.DockerfilePath)
+		slog.Info("Using existing Dockerfile", "path", b.DockerfilePath)
 		return nil
 	}
 
-	// 创建临时 Dockerfile
-	tmpDir := filepath.Dir(b.BootfsPath)
-	b.DockerfilePath = filepath.Join(tmpDir, "Dockerfile.tmp")
-
 	arch := b.Config.ArchCurrent
 	if arch == "" {
 		return fmt.Errorf("Can not find the valid arch");
@@ -133,35 +200,62 @@ CMD ["/bin/bash"]
 `
 	content := fmt.Sprintf(dockerfileContent, arch, b.Config.Distribution, b.Config.Version)
 
-	if err := os.WriteFile(b.DockerfilePath, []byte(content), 0644); err != nil {
+	// 临时 Dockerfile 使用唯一的文件名，同一目录下的多个构建互不影响
+	path, err := b.Exec.WriteTemp(filepath.Dir(b.BootfsPath), tempDockerfilePattern, []byte(content), 0644)
+	if err != nil {
 		return fmt.Errorf("failed to create Dockerfile: %v", err)
 	}
+	entry, err := cleanup.Register(ctx, b.Exec, cleanup.Remove(path), nil)
+	if err != nil {
+		b.Exec.Remove(path)
+		return err
+	}
+	b.DockerfilePath = path
+	b.dockerfile = entry
 
-	fmt.Printf("Created temporary Dockerfile: %s\n", b.DockerfilePath)
+	slog.Info("Created temporary Dockerfile", "path", b.DockerfilePath)
 	return nil
 }
 
 
-// buildImage 构建 Docker 镜像
-func (b *DockerBuilder) buildImage() error {
-	fmt.Printf("\nBuilding Docker image: %s\n", b.ImageName)
+// tempDockerfilePattern 临时 Dockerfile 的文件名，创建在 bootfs 所在目录
+const tempDockerfilePattern = "Dockerfile.*.tmp"
 
-	// 构建上下文直接是 bootfs 目录，这样 ADD . / 会添加 bootfs 的内容
-	buildContext := b.BootfsPath
+// buildImage 构建 Docker 镜像
+func (b *DockerBuilder) buildImage(ctx context.Context) error {
+	slog.Info("Building Docker image", "image", b.ImageName)
 
-	args := []string{
-		"build",
-		"-t", b.ImageName,
-		"-f", b.DockerfilePath,
-		buildContext,
+	// 将 SBOM 作为镜像标签，失败时不影响镜像构建
+	label, err := sbomLabel(b.Exec, b.BootfsPath, b.ImageName)
+	if err != nil {
+		slog.Warn("SBOM label not attached", "error", err)
 	}
+	args := b.buildArgs(label)
 
-	if err := utils.RunCommand("docker", args...); err != nil {
+	w := progress.NewWriter(b.reporter, config.StageDockerBuild, &progress.Docker{})
+	err = b.Exec.RunWithLog(ctx, w, "docker", args...)
+	w.Flush()
+	if err != nil {
 		return fmt.Errorf("failed to build Docker image: %v", err)
 	}
 
 	// 显示镜像信息
-	utils.RunCommand("docker", "images", b.ImageName)
+	b.Exec.Run(ctx, "docker", "images", b.ImageName)
 
 	return nil
 }
+
+// buildArgs 返回 docker build 的参数，label 为空时不添加 SBOM 标签
+func (b *DockerBuilder) buildArgs(label string) []string {
+	args := []string{
+		"build",
+		"-t", b.ImageName,
+		"-f", b.DockerfilePath,
+	}
+	if label != "" {
+		args = append(args, "--label", SBOMLabel+"="+label)
+	}
+
+	// 构建上下文直接是 bootfs 目录，这样 ADD . / 会添加 bootfs 的内容
+	return append(args, b.BootfsPath)
+}
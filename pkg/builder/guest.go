@@ -0,0 +1,344 @@
+package builder
+
+import (
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/rivsidn/kdev_bootstrap/pkg/bootfs"
+	"github.com/rivsidn/kdev_bootstrap/pkg/config"
+)
+
+// 生成文件的说明头
+const generatedHeader = "# Generated by kboot_build_bootfs from the [%s] configuration section\n"
+
+// configureGuest 根据 [network]、[users]、[ssh]、[hostname] 配置生成客户机配置文件
+func (b *BootfsBuilder) configureGuest() error {
+	guest := b.Config.Guest
+
+	if guest.Network != nil {
+		if err := b.configureNetwork(guest.Network); err != nil {
+			return fmt.Errorf("failed to configure network: %v", err)
+		}
+	}
+	if guest.Hostname != nil {
+		if err := b.configureHostname(guest.Hostname); err != nil {
+			return fmt.Errorf("failed to configure hostname: %v", err)
+		}
+	}
+	if guest.Users != nil {
+		if err := b.configureUsers(guest.Users); err != nil {
+			return fmt.Errorf("failed to configure users: %v", err)
+		}
+	}
+
+	ssh, err := b.configureCredentials()
+	if err != nil {
+		return fmt.Errorf("failed to configure credentials: %v", err)
+	}
+	if ssh != nil {
+		if err := b.configureSSH(ssh); err != nil {
+			return fmt.Errorf("failed to configure ssh: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// bootfsPath 返回 bootfs 内路径对应的宿主机路径
+func (b *BootfsBuilder) bootfsPath(guestPath string) string {
+	return filepath.Join(b.BootfsPath, guestPath)
+}
+
+// bootfsHas 判断 bootfs 中是否存在任一路径
+func (b *BootfsBuilder) bootfsHas(guestPaths ...string) bool {
+	for _, p := range guestPaths {
+		if b.Exec.Exists(b.bootfsPath(p)) {
+			return true
+		}
+	}
+	return false
+}
+
+// bootfsTarget 返回写入 bootfs 中文件时使用的宿主机路径
+//
+// 上级目录在 bootfs 内解析符号链接，绝对路径链接不会指向宿主机目录；
+// 最后一级是符号链接时先删除，避免跟随链接写到 bootfs 之外。
+func (b *BootfsBuilder) bootfsTarget(guestPath string) (string, error) {
+	dir, err := b.createBootfsDir(path.Dir(path.Clean("/" + guestPath)))
+	if err != nil {
+		return "", err
+	}
+	target := filepath.Join(dir, path.Base(guestPath))
+	if !b.Exec.Exists(target) {
+		return target, nil
+	}
+	if info, err := os.Lstat(target); err == nil && info.Mode()&fs.ModeSymlink != 0 {
+		if err := b.Exec.Remove(target); err != nil {
+			return "", fmt.Errorf("failed to remove symlink %s: %v", target, err)
+		}
+	}
+	return target, nil
+}
+
+// createBootfsDir 创建 bootfs 中的目录，路径中的符号链接在 bootfs 内解析，返回宿主机路径
+func (b *BootfsBuilder) createBootfsDir(guestPath string) (string, error) {
+	dir := bootfs.ResolveInRoot(b.BootfsPath, guestPath)
+	if err := b.Exec.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create directory %s: %v", dir, err)
+	}
+	return dir, nil
+}
+
+// writeBootfsFile 写入 bootfs 中的文件
+func (b *BootfsBuilder) writeBootfsFile(guestPath, content string, perm fs.FileMode) error {
+	target, err := b.bootfsTarget(guestPath)
+	if err != nil {
+		return err
+	}
+	if err := b.Exec.WriteFile(target, []byte(content), perm); err != nil {
+		return fmt.Errorf("failed to write %s: %v", target, err)
+	}
+	return nil
+}
+
+// symlinkBootfs 在 bootfs 中创建符号链接，已存在时替换
+func (b *BootfsBuilder) symlinkBootfs(linkTarget, guestPath string) error {
+	target, err := b.bootfsTarget(guestPath)
+	if err != nil {
+		return err
+	}
+	if b.Exec.Exists(target) {
+		b.Exec.Remove(target)
+	}
+	if err := b.Exec.Symlink(linkTarget, target); err != nil {
+		return fmt.Errorf("failed to create symlink %s: %v", target, err)
+	}
+	return nil
+}
+
+// enableSystemdUnit 通过 multi-user.target.wants 链接启用 systemd 服务
+func (b *BootfsBuilder) enableSystemdUnit(unit string) error {
+	for _, dir := range []string{"/lib/systemd/system", "/usr/lib/systemd/system"} {
+		unitPath := filepath.Join(dir, unit)
+		if b.bootfsHas(unitPath) {
+			return b.symlinkBootfs(unitPath, filepath.Join("/etc/systemd/system/multi-user.target.wants", unit))
+		}
+	}
+	slog.Warn("systemd unit not found in bootfs, not enabled", "unit", unit)
+	return nil
+}
+
+// resolveRenderer 根据发行版版本及已安装的软件确定网络配置方式
+func (b *BootfsBuilder) resolveRenderer(network *config.NetworkConfig) string {
+	if network.Renderer != "auto" {
+		return network.Renderer
+	}
+	// Ubuntu 17.10 起使用 netplan
+	if b.Config.VersionAtLeast("17.10") && b.bootfsHas("/usr/sbin/netplan") {
+		return "netplan"
+	}
+	if !b.bootfsHas("/sbin/ifup") && b.bootfsHas("/lib/systemd/systemd-networkd") {
+		return "networkd"
+	}
+	return "ifupdown"
+}
+
+// configureNetwork 生成网络配置
+func (b *BootfsBuilder) configureNetwork(network *config.NetworkConfig) error {
+	renderer := b.resolveRenderer(network)
+	slog.Info("Configuring network", "interface", network.Interface, "method", network.Method, "renderer", renderer)
+
+	switch renderer {
+	case "netplan":
+		if err := b.writeBootfsFile("/etc/netplan/01-kboot.yaml", renderNetplan(network), 0644); err != nil {
+			return err
+		}
+		if err := b.enableSystemdUnit("systemd-networkd.service"); err != nil {
+			return err
+		}
+	case "networkd":
+		file := fmt.Sprintf("/etc/systemd/network/20-kboot-%s.network", network.Interface)
+		if err := b.writeBootfsFile(file, renderNetworkd(network), 0644); err != nil {
+			return err
+		}
+		if err := b.enableSystemdUnit("systemd-networkd.service"); err != nil {
+			return err
+		}
+	default:
+		content, err := renderInterfaces(network)
+		if err != nil {
+			return err
+		}
+		if err := b.writeBootfsFile("/etc/network/interfaces", content, 0644); err != nil {
+			return err
+		}
+	}
+
+	return b.configureResolver(network, renderer)
+}
+
+// configureResolver 生成与网络配置方式匹配的 resolv.conf
+//
+// netplan/networkd 且安装了 systemd-resolved 时，DNS 由 resolved 管理，
+// resolv.conf 指向其 stub 文件；否则写入静态 resolv.conf。
+func (b *BootfsBuilder) configureResolver(network *config.NetworkConfig, renderer string) error {
+	if renderer != "ifupdown" && b.bootfsHas("/lib/systemd/systemd-resolved", "/usr/lib/systemd/systemd-resolved") {
+		if err := b.symlinkBootfs("../run/systemd/resolve/stub-resolv.conf", "/etc/resolv.conf"); err != nil {
+			return err
+		}
+		return b.enableSystemdUnit("systemd-resolved.service")
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, generatedHeader, config.NetworkSection)
+	for _, ns := range network.Nameservers {
+		fmt.Fprintf(&sb, "nameserver %s\n", ns)
+	}
+	return b.writeBootfsFile("/etc/resolv.conf", sb.String(), 0644)
+}
+
+// renderNetplan 生成 netplan 配置
+func renderNetplan(network *config.NetworkConfig) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, generatedHeader, config.NetworkSection)
+	sb.WriteString("network:\n  version: 2\n  renderer: networkd\n  ethernets:\n")
+	fmt.Fprintf(&sb, "    %s:\n", network.Interface)
+	if network.Method == "dhcp" {
+		sb.WriteString("      dhcp4: true\n")
+	} else {
+		sb.WriteString("      dhcp4: false\n")
+		fmt.Fprintf(&sb, "      addresses: [%s]\n", network.Address)
+		if network.Gateway != "" {
+			fmt.Fprintf(&sb, "      routes:\n        - to: 0.0.0.0/0\n          via: %s\n", network.Gateway)
+		}
+	}
+	fmt.Fprintf(&sb, "      nameservers:\n        addresses: [%s]\n", strings.Join(network.Nameservers, ", "))
+	return sb.String()
+}
+
+// renderNetworkd 生成 systemd-networkd 配置
+func renderNetworkd(network *config.NetworkConfig) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, generatedHeader, config.NetworkSection)
+	fmt.Fprintf(&sb, "[Match]\nName=%s\n\n[Network]\n", network.Interface)
+	if network.Method == "dhcp" {
+		sb.WriteString("DHCP=ipv4\n")
+	} else {
+		fmt.Fprintf(&sb, "Address=%s\n", network.Address)
+		if network.Gateway != "" {
+			fmt.Fprintf(&sb, "Gateway=%s\n", network.Gateway)
+		}
+	}
+	for _, ns := range network.Nameservers {
+		fmt.Fprintf(&sb, "DNS=%s\n", ns)
+	}
+	return sb.String()
+}
+
+// renderInterfaces 生成 ifupdown 的 /etc/network/interfaces
+//
+// 旧版本 ifupdown 不支持 CIDR 地址，static 时拆分为 address/netmask。
+func renderInterfaces(network *config.NetworkConfig) (string, error) {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, generatedHeader, config.NetworkSection)
+	sb.WriteString("auto lo\niface lo inet loopback\n\n")
+	fmt.Fprintf(&sb, "auto %s\n", network.Interface)
+
+	if network.Method == "dhcp" {
+		fmt.Fprintf(&sb, "iface %s inet dhcp\n", network.Interface)
+		return sb.String(), nil
+	}
+
+	ip, ipNet, err := net.ParseCIDR(network.Address)
+	if err != nil {
+		return "", fmt.Errorf("invalid address %s: %v", network.Address, err)
+	}
+	fmt.Fprintf(&sb, "iface %s inet static\n", network.Interface)
+	fmt.Fprintf(&sb, "    address %s\n", ip)
+	fmt.Fprintf(&sb, "    netmask %s\n", net.IP(ipNet.Mask))
+	if network.Gateway != "" {
+		fmt.Fprintf(&sb, "    gateway %s\n", network.Gateway)
+	}
+	return sb.String(), nil
+}
+
+// configureHostname 生成 /etc/hostname 及 /etc/hosts
+func (b *BootfsBuilder) configureHostname(hostname *config.HostnameConfig) error {
+	slog.Info("Configuring hostname", "hostname", hostname.Name)
+
+	if err := b.writeBootfsFile("/etc/hostname", hostname.Name+"\n", 0644); err != nil {
+		return err
+	}
+
+	lines := []string{"127.0.0.1\tlocalhost"}
+	if data, err := b.Exec.ReadFile(b.bootfsPath("/etc/hosts")); err == nil {
+		lines = nil
+		for _, line := range strings.Split(strings.TrimRight(string(data), "\n"), "\n") {
+			if !strings.HasPrefix(strings.TrimSpace(line), "127.0.1.1") {
+				lines = append(lines, line)
+			}
+		}
+	}
+	lines = append(lines, "127.0.1.1\t"+hostname.Name)
+
+	return b.writeBootfsFile("/etc/hosts", strings.Join(lines, "\n")+"\n", 0644)
+}
+
+// configureSSH 修改 sshd_config，只设置配置了的选项
+func (b *BootfsBuilder) configureSSH(ssh *config.SSHConfig) error {
+	slog.Info("Configuring sshd")
+
+	directives := [][2]string{
+		{"Port", ssh.Port},
+		{"PermitRootLogin", ssh.PermitRootLogin},
+		{"PasswordAuthentication", ssh.PasswordAuthentication},
+		{"PermitEmptyPasswords", ssh.PermitEmptyPasswords},
+		{"UsePAM", ssh.UsePAM},
+	}
+
+	content := ""
+	if data, err := b.Exec.ReadFile(b.bootfsPath("/etc/ssh/sshd_config")); err == nil {
+		content = string(data)
+	} else {
+		slog.Warn("/etc/ssh/sshd_config not found in bootfs, creating a minimal one")
+		content = fmt.Sprintf(generatedHeader, config.SSHSection)
+	}
+
+	for _, d := range directives {
+		if d[1] != "" {
+			content = setSSHDirective(content, d[0], d[1])
+		}
+	}
+
+	return b.writeBootfsFile("/etc/ssh/sshd_config", content, 0644)
+}
+
+// setSSHDirective 设置 sshd_config 选项
+//
+// 替换第一个（可能被注释的）同名选项；不存在时插入到第一个 Match 块之前，
+// 避免新增选项只对 Match 块生效。
+func setSSHDirective(content, key, value string) string {
+	line := key + " " + value
+	re := regexp.MustCompile(`(?mi)^[ \t]*#?[ \t]*` + key + `[ \t].*$`)
+
+	if loc := re.FindStringIndex(content); loc != nil {
+		return content[:loc[0]] + line + content[loc[1]:]
+	}
+
+	match := regexp.MustCompile(`(?m)^[ \t]*Match[ \t]`)
+	if loc := match.FindStringIndex(content); loc != nil {
+		return content[:loc[0]] + line + "\n" + content[loc[0]:]
+	}
+
+	if content != "" && !strings.HasSuffix(content, "\n") {
+		content += "\n"
+	}
+	return content + line + "\n"
+}
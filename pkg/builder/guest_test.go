@@ -0,0 +1,53 @@
+package builder
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rivsidn/kdev_bootstrap/pkg/executor"
+)
+
+func TestWriteBootfsFileSymlinkedParent(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "bootfs")
+	host := filepath.Join(dir, "host")
+	for _, d := range []string{filepath.Join(root, "etc/apt/apt.conf.d"), filepath.Join(host, "apt.conf.d")} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 绝对路径链接应在 bootfs 内解析，而不是指向宿主机目录
+	if err := os.Symlink("/etc/apt/apt.conf.d", filepath.Join(root, "etc/apt/conf")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(host, "apt.conf.d"), filepath.Join(root, "etc/apt/host")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(host, "file"), filepath.Join(root, "etc/apt/apt.conf.d/link")); err != nil {
+		t.Fatal(err)
+	}
+
+	b := &BootfsBuilder{BootfsPath: root, Exec: executor.Default}
+	if err := b.writeBootfsFile("/etc/apt/conf/01kboot", "a\n", 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.writeBootfsFile("/etc/apt/apt.conf.d/link", "b\n", 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "etc/apt/apt.conf.d/01kboot")); err != nil {
+		t.Errorf("file not written through the link inside bootfs: %v", err)
+	}
+	if info, err := os.Lstat(filepath.Join(root, "etc/apt/apt.conf.d/link")); err != nil || !info.Mode().IsRegular() {
+		t.Errorf("symlink at the final component not replaced: %v", err)
+	}
+
+	// 指向宿主机路径的绝对链接同样在 bootfs 内解析
+	if err := b.symlinkBootfs("../run/stub", "/etc/apt/host/resolv.conf"); err != nil {
+		t.Fatal(err)
+	}
+	entries, _ := os.ReadDir(filepath.Join(host, "apt.conf.d"))
+	if _, err := os.Stat(filepath.Join(host, "file")); err == nil || len(entries) != 0 {
+		t.Errorf("host files written: %v", entries)
+	}
+}
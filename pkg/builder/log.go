@@ -0,0 +1,50 @@
+package builder
+
+import (
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"strings"
+
+	"github.com/rivsidn/kdev_bootstrap/pkg/diagnose"
+	"github.com/rivsidn/kdev_bootstrap/pkg/executor"
+	"github.com/rivsidn/kdev_bootstrap/pkg/logging"
+)
+
+// buildLogSuffix 构建日志文件的后缀，默认放在产物旁边
+const buildLogSuffix = ".build.log"
+
+// openBuildLog 通过 exec 创建构建日志，返回的函数记录构建结果并关闭日志
+//
+// 日志包括所有级别的记录及外部命令的每行输出，带时间戳；创建失败只输出警告。
+func openBuildLog(exec executor.Executor, path string) func(err error) {
+	file, err := exec.Create(path)
+	if err != nil {
+		slog.Warn("build log disabled", "error", fmt.Errorf("failed to create log file %s: %v", path, err))
+		return func(error) {}
+	}
+	closeLog := logging.Open(file)
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	slog.Info("Build log", "path", path)
+
+	return func(err error) {
+		if known := diagnose.Find(err); known != nil {
+			slog.Debug("Build failed", "error", err, "code", known.Code, "hint", known.Hint)
+		} else if err != nil {
+			slog.Debug("Build failed", "error", err)
+		} else {
+			slog.Debug("Build finished")
+		}
+		if err := closeLog(); err != nil {
+			slog.Warn("failed to close build log", "error", err)
+		}
+	}
+}
+
+// imageLogPath Docker 镜像名称对应的构建日志路径（当前目录）
+func imageLogPath(image string) string {
+	name := strings.NewReplacer("/", "_", ":", "_").Replace(image)
+	return name + buildLogSuffix
+}
@@ -0,0 +1,57 @@
+package builder
+
+import (
+	"fmt"
+	"log/slog"
+	"path/filepath"
+
+	"github.com/rivsidn/kdev_bootstrap/pkg/executor"
+	"github.com/rivsidn/kdev_bootstrap/pkg/metrics"
+)
+
+// finishMetrics 将各阶段及步骤的耗时记录到构建日志，path 不为空时通过 exec 写入 Prometheus textfile
+//
+// 构建失败时同样写入，kboot_build_success 为 0；写入失败只输出警告。
+func finishMetrics(exec executor.Executor, timing *metrics.Recorder, path, tool, target string, err error) {
+	m := timing.Metrics()
+	for _, stage := range m.Stages {
+		slog.Debug("Stage timing", "stage", stage.Stage, "duration", stage.Duration, "failed", stage.Failed)
+	}
+	for _, step := range m.Steps {
+		slog.Debug("Step timing", "stage", step.Stage, "step", step.Step, "duration", step.Duration)
+	}
+	slog.Debug("Build timing", "duration", m.Duration)
+
+	if path == "" {
+		return
+	}
+	if err := writeTextfile(exec, path, m.Textfile(tool, target, err != nil)); err != nil {
+		slog.Warn("metrics file not written", "error", err)
+		return
+	}
+	slog.Info("Metrics written", "path", path)
+}
+
+// writeTextfile 先写入同目录的临时文件再重命名，collector 不会读到不完整的文件
+//
+// 权限为 0644，node_exporter 通常不以 root 运行。
+func writeTextfile(exec executor.Executor, path string, data []byte) error {
+	tmp, err := exec.WriteTemp(filepath.Dir(path), "."+filepath.Base(path)+".*", data, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write metrics file: %v", err)
+	}
+	if err := exec.Rename(tmp, path); err != nil {
+		exec.Remove(tmp)
+		return fmt.Errorf("failed to write metrics file: %v", err)
+	}
+	return nil
+}
+
+// timingMetrics 返回到目前为止的耗时，没有执行构建时返回 nil
+func timingMetrics(timing *metrics.Recorder) *metrics.Metrics {
+	if timing == nil {
+		return nil
+	}
+	m := timing.Metrics()
+	return &m
+}
@@ -0,0 +1,306 @@
+package builder
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/rivsidn/kdev_bootstrap/pkg/bootfs"
+	"github.com/rivsidn/kdev_bootstrap/pkg/config"
+	"github.com/rivsidn/kdev_bootstrap/pkg/utils"
+)
+
+// templateSuffix 需要渲染的模板文件后缀
+const templateSuffix = ".tmpl"
+
+// overlayData 模板渲染时可用的数据
+type overlayData struct {
+	*config.Config
+	Arch  string
+	Suite string
+}
+
+// overlayOwner 属主规则
+type overlayOwner struct {
+	pattern  string
+	uid, gid int
+}
+
+// overlayMode 权限规则
+type overlayMode struct {
+	pattern string
+	mode    fs.FileMode
+}
+
+// applyOverlays 将 overlay_dirs 中的目录树复制到 bootfs
+//
+// 文件默认属主为 root:root 并保留源文件权限，overlay_owners、overlay_modes
+// 中按 bootfs 内路径匹配的规则可以覆盖默认值，后出现的规则优先。
+// .tmpl 结尾的文件使用配置渲染后去掉后缀写入。
+func (b *BootfsBuilder) applyOverlays() error {
+	if len(b.Config.OverlayDirs) == 0 {
+		return nil
+	}
+
+	owners, err := b.parseOverlayOwners()
+	if err != nil {
+		return err
+	}
+	modes, err := parseOverlayModes(b.Config.OverlayModes)
+	if err != nil {
+		return err
+	}
+
+	data := overlayData{
+		Config: b.Config,
+		Arch:   b.Arch,
+		Suite:  b.Config.GetSuite(),
+	}
+
+	for _, dir := range b.Config.OverlayDirs {
+		src, err := filepath.Abs(b.Config.ResolvePath(dir))
+		if err != nil {
+			return fmt.Errorf("failed to resolve overlay directory %s: %v", dir, err)
+		}
+		if !utils.DirExists(src) {
+			return fmt.Errorf("overlay directory does not exist: %s", src)
+		}
+
+		slog.Info("Applying overlay", "dir", src)
+		if err := b.copyOverlay(src, data, owners, modes); err != nil {
+			return fmt.Errorf("failed to apply overlay %s: %v", src, err)
+		}
+	}
+	return nil
+}
+
+// copyOverlay 复制单个 overlay 目录
+func (b *BootfsBuilder) copyOverlay(src string, data overlayData, owners []overlayOwner, modes []overlayMode) error {
+	return filepath.WalkDir(src, func(srcPath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, srcPath)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		guestPath := "/" + filepath.ToSlash(rel)
+		rendered := false
+		if info.Mode().IsRegular() && strings.HasSuffix(guestPath, templateSuffix) {
+			guestPath = strings.TrimSuffix(guestPath, templateSuffix)
+			rendered = true
+		}
+
+		// 在 bootfs 内解析路径中的符号链接，绝对链接（如 /var/run -> /run）不会指向宿主机路径：
+		// 目录跟随所有链接，其他文件只解析上级目录，最后一级的链接被替换
+		var dst string
+		if d.IsDir() {
+			dst = bootfs.ResolveInRoot(b.BootfsPath, guestPath)
+		} else {
+			dst = filepath.Join(bootfs.ResolveInRoot(b.BootfsPath, path.Dir(guestPath)), path.Base(guestPath))
+			if dstInfo, err := os.Lstat(dst); err == nil && dstInfo.Mode()&fs.ModeSymlink != 0 && b.Exec.Exists(dst) {
+				b.Exec.Remove(dst)
+			}
+		}
+
+		// bootfs 中已存在的目录（如 /etc）只应用显式规则
+		existingDir := d.IsDir() && b.Exec.Exists(dst)
+
+		switch {
+		case existingDir:
+		case d.IsDir():
+			if err := b.Exec.MkdirAll(dst, info.Mode().Perm()); err != nil {
+				return fmt.Errorf("failed to create directory %s: %v", dst, err)
+			}
+		case info.Mode()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(srcPath)
+			if err != nil {
+				return err
+			}
+			if b.Exec.Exists(dst) {
+				b.Exec.Remove(dst)
+			}
+			if err := b.Exec.Symlink(link, dst); err != nil {
+				return fmt.Errorf("failed to create symlink %s: %v", dst, err)
+			}
+		case info.Mode().IsRegular():
+			content, err := os.ReadFile(srcPath)
+			if err == nil && rendered {
+				content, err = renderTemplate(srcPath, content, data)
+			}
+			if err != nil {
+				return err
+			}
+			if err := b.Exec.WriteFile(dst, content, info.Mode().Perm()); err != nil {
+				return fmt.Errorf("failed to write %s: %v", dst, err)
+			}
+		default:
+			slog.Warn("Skipping special file in overlay", "path", srcPath)
+			return nil
+		}
+
+		return b.applyOverlayRules(dst, guestPath, info, owners, modes, existingDir)
+	})
+}
+
+// renderTemplate 渲染模板文件 src 的内容 text
+func renderTemplate(src string, text []byte, data overlayData) ([]byte, error) {
+	tmpl, err := template.New(filepath.Base(src)).Option("missingkey=error").Parse(string(text))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse template %s: %v", src, err)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return nil, fmt.Errorf("failed to render template %s: %v", src, err)
+	}
+	return buf.Bytes(), nil
+}
+
+// applyOverlayRules 设置文件属主和权限，explicitOnly 为 true 时只应用匹配的规则
+func (b *BootfsBuilder) applyOverlayRules(dst, guestPath string, info fs.FileInfo, owners []overlayOwner, modes []overlayMode, explicitOnly bool) error {
+	uid, gid, ownerMatched := 0, 0, false
+	for _, o := range owners {
+		if matchGuestPath(o.pattern, guestPath) {
+			uid, gid, ownerMatched = o.uid, o.gid, true
+		}
+	}
+	if ownerMatched || !explicitOnly {
+		if err := b.Exec.Lchown(dst, uid, gid); err != nil {
+			return fmt.Errorf("failed to change owner of %s: %v", dst, err)
+		}
+	}
+
+	if info.Mode()&fs.ModeSymlink != 0 {
+		return nil
+	}
+
+	mode, modeMatched := info.Mode().Perm(), false
+	for _, m := range modes {
+		if matchGuestPath(m.pattern, guestPath) {
+			mode, modeMatched = m.mode, true
+		}
+	}
+	if modeMatched || !explicitOnly {
+		if err := b.Exec.Chmod(dst, mode); err != nil {
+			return fmt.Errorf("failed to change mode of %s: %v", dst, err)
+		}
+	}
+
+	return nil
+}
+
+// matchGuestPath 判断 bootfs 内路径是否匹配规则
+func matchGuestPath(pattern, guestPath string) bool {
+	matched, err := path.Match(pattern, guestPath)
+	return err == nil && matched
+}
+
+// parseOverlayModes 解析 overlay_modes，格式: 路径=权限，例如 /root/.ssh=0700
+func parseOverlayModes(rules []string) ([]overlayMode, error) {
+	var modes []overlayMode
+	for _, rule := range rules {
+		pattern, value, ok := strings.Cut(rule, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid overlay_modes rule: %s", rule)
+		}
+		mode, err := strconv.ParseUint(strings.TrimSpace(value), 8, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid mode in overlay_modes rule %s: %v", rule, err)
+		}
+		modes = append(modes, overlayMode{strings.TrimSpace(pattern), fs.FileMode(mode) & fs.ModePerm})
+	}
+	return modes, nil
+}
+
+// parseOverlayOwners 解析 overlay_owners，格式: 路径=用户:组，用户和组可以是名称或数字
+//
+// 名称按 bootfs 中的 /etc/passwd、/etc/group 解析，省略组时使用用户的主组。
+func (b *BootfsBuilder) parseOverlayOwners() ([]overlayOwner, error) {
+	var owners []overlayOwner
+	for _, rule := range b.Config.OverlayOwners {
+		pattern, value, ok := strings.Cut(rule, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid overlay_owners rule: %s", rule)
+		}
+		user, group, _ := strings.Cut(strings.TrimSpace(value), ":")
+
+		uid, gid, err := b.lookupUser(user)
+		if err != nil {
+			return nil, fmt.Errorf("invalid owner in overlay_owners rule %s: %v", rule, err)
+		}
+		if group != "" {
+			if gid, err = b.lookupID("group", group); err != nil {
+				return nil, fmt.Errorf("invalid group in overlay_owners rule %s: %v", rule, err)
+			}
+		}
+
+		owners = append(owners, overlayOwner{strings.TrimSpace(pattern), uid, gid})
+	}
+	return owners, nil
+}
+
+// lookupUser 在 bootfs 的 /etc/passwd 中查找用户的 UID 及主组 GID
+//
+// 用户为数字且不在 /etc/passwd 中时主组为 0。
+func (b *BootfsBuilder) lookupUser(name string) (int, int, error) {
+	data, err := b.readAccountFile("passwd")
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Split(line, ":")
+		if len(fields) < 4 || (fields[0] != name && fields[2] != name) {
+			continue
+		}
+		uid, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid UID of %s in bootfs /etc/passwd: %v", name, err)
+		}
+		gid, err := strconv.Atoi(fields[3])
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid GID of %s in bootfs /etc/passwd: %v", name, err)
+		}
+		return uid, gid, nil
+	}
+	if uid, err := strconv.Atoi(name); err == nil {
+		return uid, 0, nil
+	}
+	return 0, 0, fmt.Errorf("%s not found in bootfs /etc/passwd", name)
+}
+
+// lookupID 在 bootfs 的 /etc/passwd 或 /etc/group 中查找名称对应的 ID
+func (b *BootfsBuilder) lookupID(database, name string) (int, error) {
+	if id, err := strconv.Atoi(name); err == nil {
+		return id, nil
+	}
+
+	data, err := b.readAccountFile(database)
+	if err != nil {
+		return 0, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Split(line, ":")
+		if len(fields) > 2 && fields[0] == name {
+			return strconv.Atoi(fields[2])
+		}
+	}
+	return 0, fmt.Errorf("%s not found in bootfs /etc/%s", name, database)
+}
@@ -0,0 +1,58 @@
+package builder
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/rivsidn/kdev_bootstrap/pkg/executor"
+	"github.com/rivsidn/kdev_bootstrap/pkg/lock"
+	"github.com/rivsidn/kdev_bootstrap/pkg/manifest"
+	"github.com/rivsidn/kdev_bootstrap/pkg/owner"
+)
+
+// chownOutputs 通过 exec 将产物、清单及日志的属主改为 o，o 为 nil 时不修改，失败只输出警告
+//
+// 只修改列出的文件本身，不跟随符号链接，不存在的路径及空字符串被忽略：bootfs 目录即客户机的 /，
+// 其中文件的属主属于根文件系统的内容，不能修改。
+func chownOutputs(exec executor.Executor, o *owner.Owner, paths ...string) {
+	if o == nil {
+		return
+	}
+
+	var changed []string
+	var errs []error
+	for _, path := range paths {
+		if path == "" || !exec.Exists(path) {
+			continue
+		}
+		if err := exec.Lchown(path, o.UID, o.GID); err != nil {
+			errs = append(errs, fmt.Errorf("failed to change owner of %s: %v", path, err))
+			continue
+		}
+		changed = append(changed, path)
+	}
+	if err := errors.Join(errs...); err != nil {
+		slog.Warn("output ownership not changed", "owner", o, "error", err)
+		return
+	}
+	slog.Debug("Output ownership changed", "owner", o, "paths", strings.Join(changed, ","))
+}
+
+// outputs 返回构建结束时修改属主的文件
+func (b *BootfsBuilder) outputs() []string {
+	return []string{manifest.SidecarPath(b.BootfsPath), CredentialsPath(b.BootfsPath), provisionLogPath(b.BootfsPath),
+		b.LogFile, b.MetricsFile, b.RecordFile, lock.Path(b.BootfsPath)}
+}
+
+// outputs 返回构建结束时修改属主的文件
+func (b *DockerBuilder) outputs() []string {
+	return []string{b.LogFile, b.MetricsFile, b.RecordFile}
+}
+
+// outputs 返回构建结束时修改属主的文件
+func (b *QemuBuilder) outputs() []string {
+	paths := []string{b.RootfsImage, CredentialsPath(b.RootfsImage), b.LogFile, b.MetricsFile, b.RecordFile, lock.Path(b.RootfsImage)}
+	return append(paths, b.sbomFiles...)
+}
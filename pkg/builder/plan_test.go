@@ -0,0 +1,278 @@
+package builder
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rivsidn/kdev_bootstrap/pkg/config"
+	"github.com/rivsidn/kdev_bootstrap/pkg/executor"
+	"github.com/rivsidn/kdev_bootstrap/pkg/lock"
+	"github.com/rivsidn/kdev_bootstrap/pkg/manifest"
+	"github.com/rivsidn/kdev_bootstrap/pkg/owner"
+)
+
+// 构建计划由 Build 使用 DryRun 生成，以下测试检查生成计划不修改文件系统，
+// 且计划包含 overlay、配置脚本、[users] 及归档输入等步骤。
+
+// listFiles 返回 dir 下的文件及目录
+func listFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	var files []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && path != dir {
+			files = append(files, path)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+// checkUnchanged 检查生成计划后 dir 下的文件与之前相同
+func checkUnchanged(t *testing.T, dir string, before []string) {
+	t.Helper()
+	if after := listFiles(t, dir); strings.Join(after, "\n") != strings.Join(before, "\n") {
+		t.Errorf("plan changed the file system\nbefore:\n  %s\nafter:\n  %s",
+			strings.Join(before, "\n  "), strings.Join(after, "\n  "))
+	}
+}
+
+// checkSteps 检查计划按顺序包含 want 中的步骤，只比较 want 中设置的字段，Command 比较前缀
+func checkSteps(t *testing.T, plan *executor.Plan, want []executor.Step) {
+	t.Helper()
+	i := 0
+	for _, step := range plan.Steps {
+		if i < len(want) && stepMatches(step, want[i]) {
+			i++
+		}
+	}
+	if i < len(want) {
+		var sb strings.Builder
+		plan.Print(&sb)
+		t.Errorf("plan is missing step %+v (or it is out of order)\n%s", want[i], sb.String())
+	}
+}
+
+// stepMatches 判断 step 是否与 want 中设置的字段相同
+func stepMatches(step, want executor.Step) bool {
+	if step.Action != want.Action || (want.Path != "" && step.Path != want.Path) {
+		return false
+	}
+	if (want.Target != "" && step.Target != want.Target) || (want.Note != "" && step.Note != want.Note) {
+		return false
+	}
+	if len(step.Command) < len(want.Command) {
+		return false
+	}
+	for i, arg := range want.Command {
+		if step.Command[i] != arg {
+			return false
+		}
+	}
+	return true
+}
+
+// writeArchive 将 writeBootfs 创建的 bootfs 打包为 dir 下的 bootfs.tar.gz 并删除 bootfs 目录
+func writeArchive(t *testing.T, dir string) string {
+	t.Helper()
+	root := writeBootfs(t, dir)
+	archive := filepath.Join(dir, "bootfs.tar.gz")
+	if output, err := exec.Command("tar", "-czf", archive, "-C", root, ".").CombinedOutput(); err != nil {
+		t.Skipf("tar: %v: %s", err, output)
+	}
+	if err := os.RemoveAll(root); err != nil {
+		t.Fatal(err)
+	}
+	return archive
+}
+
+// testOwner 构建产物的属主
+func testOwner() *owner.Owner {
+	return &owner.Owner{UID: 1000, GID: 1000}
+}
+
+func TestBootfsPlan(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"ubuntu-16.04.conf": strings.Replace(testConfig, "arch_current   = amd64\n", `slim              = docs
+overlay_dirs      = overlay
+overlay_owners    = /home/dev/*=dev
+provision_scripts = provision.sh
+`, 1) + `
+[users]
+accounts        = dev
+authorized_keys = none
+
+[hostname]
+name = kdev
+`,
+		"provision.sh":                "#!/bin/sh\napt-get install -y vim\n",
+		"overlay/etc/motd.tmpl":       "Welcome to {{.Distribution}} {{.Version}}\n",
+		"overlay/home/dev/.bashrc":    "set -o vi\n",
+		"bootfs/etc/bootstrap.conf":   "previous build\n",
+		"bootfs/usr/share/doc/README": "previous build\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfg, err := config.LoadConfig(filepath.Join(dir, "ubuntu-16.04.conf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	output := filepath.Join(dir, "bootfs")
+
+	before := listFiles(t, dir)
+	b := NewBootfsBuilder(cfg, "amd64", output)
+	b.MetricsFile = filepath.Join(dir, "bootfs.prom")
+	b.Owner = testOwner()
+	plan, err := b.Plan(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkUnchanged(t, dir, before)
+
+	path := func(rel string) string { return filepath.Join(output, rel) }
+	hostDir := path(provisionDir)
+	checkSteps(t, plan, []executor.Step{
+		{Action: executor.ActionWrite, Path: lock.Path(output), Note: "exclusive lock"},
+		{Action: executor.ActionWrite, Path: output + buildLogSuffix},
+		// 已存在的 bootfs 不询问，直接列出删除
+		{Action: executor.ActionDelete, Path: output},
+		{Action: executor.ActionMkdir, Path: output},
+		{Action: executor.ActionRun, Command: []string{"debootstrap", "--arch=amd64"}},
+		{Action: executor.ActionWrite, Path: path("etc/hostname")},
+		{Action: executor.ActionMkdir, Path: path("home/dev")},
+		{Action: executor.ActionChown, Path: path("home/dev"), Note: "1000:1000"},
+		{Action: executor.ActionWrite, Path: path("etc/passwd")},
+		{Action: executor.ActionWrite, Path: CredentialsPath(output)},
+		{Action: executor.ActionWrite, Path: path("etc/motd")},
+		{Action: executor.ActionWrite, Path: path("home/dev/.bashrc")},
+		// 属主按 [users] 创建的用户解析，省略组时为用户的主组
+		{Action: executor.ActionChown, Path: path("home/dev/.bashrc"), Note: "1000:1000"},
+		{Action: executor.ActionWrite, Path: path("etc/bootstrap.conf")},
+		{Action: executor.ActionWrite, Path: provisionLogPath(output)},
+		{Action: executor.ActionRun, Command: []string{"mount", "-t", "proc"}},
+		{Action: executor.ActionMkdir, Path: hostDir},
+		{Action: executor.ActionWrite, Path: filepath.Join(hostDir, "provision.sh")},
+		{Action: executor.ActionRun, Command: []string{"chroot", output}},
+		{Action: executor.ActionDelete, Path: hostDir},
+		{Action: executor.ActionWrite, Path: path(slimDpkgConfig)},
+		{Action: executor.ActionDelete, Path: path("/usr/share/doc/*")},
+		{Action: executor.ActionWrite, Path: path(manifest.BootfsPath)},
+		{Action: executor.ActionWrite, Path: output + ".manifest.json"},
+		{Action: executor.ActionRename, Target: b.MetricsFile},
+		{Action: executor.ActionChown, Path: CredentialsPath(output), Note: "1000:1000"},
+		{Action: executor.ActionDelete, Path: lock.Path(output)},
+	})
+
+	// 模板按配置渲染，/etc/passwd 中有 [users] 创建的用户
+	exec := executor.DryRun{Plan: plan}
+	if data, err := exec.ReadFile(path("etc/motd")); err != nil || string(data) != "Welcome to ubuntu 16.04\n" {
+		t.Errorf("rendered motd = %q, %v", data, err)
+	}
+	if data, err := exec.ReadFile(path("etc/passwd")); err != nil || !strings.Contains(string(data), "\ndev:x:1000:1000:") {
+		t.Errorf("planned /etc/passwd = %q, %v", data, err)
+	}
+
+	// bootstrap.conf 保存配置文件中的写法，overlay_dirs 不记录宿主机路径
+	data, err := exec.ReadFile(path("etc/bootstrap.conf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved, err := config.LoadConfigData(data, path("etc/bootstrap.conf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.ProvisionScriptsRaw != "provision.sh" || saved.OverlayDirsRaw != "overlay" || saved.OverlayOwnersRaw != "/home/dev/*=dev" {
+		t.Errorf("planned /etc/bootstrap.conf:\n%s", data)
+	}
+}
+
+func TestDockerPlanArchive(t *testing.T) {
+	dir := t.TempDir()
+	archive := writeArchive(t, dir)
+
+	b, err := NewDockerBuilder(archive, "", "kboot/test:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.LogFile = filepath.Join(dir, "docker.build.log")
+
+	before := listFiles(t, dir)
+	plan, err := b.Plan(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkUnchanged(t, dir, before)
+
+	extracted := filepath.Join(dir, ".kboot-bootfs-XXXXXX")
+	dockerfile := filepath.Join(dir, "Dockerfile.XXXXXX.tmp")
+	checkSteps(t, plan, []executor.Step{
+		{Action: executor.ActionWrite, Path: lock.Path(archive), Note: "shared lock"},
+		{Action: executor.ActionMkdir, Path: extracted},
+		{Action: executor.ActionRun, Command: []string{"tar", "--extract", "--file=" + archive}},
+		{Action: executor.ActionWrite, Path: b.LogFile},
+		{Action: executor.ActionWrite, Path: dockerfile},
+		{Action: executor.ActionRun, Command: []string{"docker", "build", "-t", "kboot/test:1", "-f", dockerfile,
+			"--label", SBOMLabel + "=" + planSBOMLabel, extracted}},
+		{Action: executor.ActionDelete, Path: dockerfile},
+		{Action: executor.ActionDelete, Path: extracted},
+		{Action: executor.ActionDelete, Path: lock.Path(archive)},
+	})
+}
+
+func TestQemuPlanArchive(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("TMPDIR", dir)
+	archive := writeArchive(t, dir)
+	image := filepath.Join(dir, "test.img")
+
+	b, err := NewQemuBuilder(archive, image, "1G")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.MetricsFile = filepath.Join(dir, "qemu.prom")
+
+	before := listFiles(t, dir)
+	plan, err := b.Plan(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkUnchanged(t, dir, before)
+
+	extracted := filepath.Join(dir, ".kboot-bootfs-XXXXXX")
+	mountPoint := filepath.Join(dir, qemuMountPrefix+"XXXXXX")
+	checkSteps(t, plan, []executor.Step{
+		{Action: executor.ActionMkdir, Path: extracted},
+		{Action: executor.ActionRun, Command: []string{"tar", "--extract", "--file=" + archive}},
+		{Action: executor.ActionWrite, Path: lock.Path(image), Note: "exclusive lock"},
+		{Action: executor.ActionRun, Command: []string{"qemu-img", "create", "-f", "raw", image, "1G"}},
+		{Action: executor.ActionRun, Command: []string{"losetup", planLoopDevice, image}},
+		{Action: executor.ActionRun, Command: []string{"mkfs.ext3", "-F", planLoopDevice}},
+		{Action: executor.ActionRun, Command: []string{"losetup", "-d", planLoopDevice}},
+		{Action: executor.ActionMkdir, Path: mountPoint},
+		{Action: executor.ActionRun, Command: []string{"mount", "-o", "loop", image, mountPoint}},
+		// 镜像在构建结束时卸载
+		{Action: executor.ActionWrite, Path: image + ".spdx.json"},
+		{Action: executor.ActionWrite, Path: image + ".cdx.json"},
+		{Action: executor.ActionRun, Command: []string{"umount", mountPoint}},
+		{Action: executor.ActionDelete, Path: mountPoint},
+		{Action: executor.ActionRename, Target: b.MetricsFile},
+		{Action: executor.ActionDelete, Path: lock.Path(image)},
+		{Action: executor.ActionDelete, Path: extracted},
+		{Action: executor.ActionDelete, Path: lock.Path(archive)},
+	})
+}
@@ -0,0 +1,67 @@
+package builder
+
+import (
+	"context"
+	"log/slog"
+	"path/filepath"
+	"strings"
+
+	"github.com/rivsidn/kdev_bootstrap/pkg/preflight"
+	"github.com/rivsidn/kdev_bootstrap/pkg/utils"
+)
+
+// 构建器查询主机的函数，回放测试中可以替换，使构建不依赖 root 权限及主机上安装的命令
+var (
+	CheckRoot    = utils.CheckRoot    // 是否以 root 执行
+	CheckCommand = utils.CheckCommand // 主机上是否有命令，决定使用 rsync 还是 cp
+)
+
+// dockerRoot docker 保存镜像的默认目录，用于检查可用空间
+const dockerRoot = "/var/lib/docker"
+
+// runPreflight 执行构建前的主机检查，结果写入日志，未通过时返回错误
+func runPreflight(ctx context.Context, checks []preflight.Check, opts preflight.Options) error {
+	report := preflight.Run(ctx, checks, opts)
+	for _, result := range report {
+		slog.Debug("Preflight check", "check", result.Name, "status", result.Status, "detail", result.Detail)
+	}
+	return report.Err()
+}
+
+// preflight 检查 debootstrap、密钥环、跨架构的 binfmt 及输出目录的空间
+func (b *BootfsBuilder) preflight(ctx context.Context) error {
+	if b.SkipPreflight {
+		return nil
+	}
+	return runPreflight(ctx, preflight.BootfsChecks, preflight.Options{
+		Suite:      b.Config.GetSuite(),
+		NoCheckGPG: strings.HasPrefix(b.Config.Version, "5."),
+		Arch:       b.Arch,
+		Dir:        filepath.Dir(b.BootfsPath),
+		Need:       preflight.BootfsEstimate,
+	})
+}
+
+// preflight 检查 docker 守护进程及镜像目录的空间
+func (b *DockerBuilder) preflight(ctx context.Context) error {
+	if b.SkipPreflight {
+		return nil
+	}
+	opts := preflight.Options{Dir: dockerRoot}
+	if !b.archive {
+		opts.Need = preflight.DirSize(b.BootfsPath)
+	}
+	return runPreflight(ctx, preflight.DockerChecks, opts)
+}
+
+// preflight 检查 qemu-img、mkfs、loop 设备及镜像目录的空间
+func (b *QemuBuilder) preflight(ctx context.Context) error {
+	if b.SkipPreflight {
+		return nil
+	}
+	opts := preflight.Options{Dir: filepath.Dir(b.RootfsImage)}
+	if size, err := preflight.ParseSize(b.ImageSize); err == nil {
+		opts.Need = size
+	}
+	return runPreflight(ctx, preflight.QemuChecks, opts)
+}
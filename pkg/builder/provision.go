@@ -0,0 +1,108 @@
+package builder
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/rivsidn/kdev_bootstrap/pkg/bootfs"
+	"github.com/rivsidn/kdev_bootstrap/pkg/cleanup"
+	"github.com/rivsidn/kdev_bootstrap/pkg/utils"
+)
+
+// provisionDir 配置脚本在 bootfs 中的临时目录
+const provisionDir = "tmp/kboot-provision"
+
+// provisionLogPath 返回配置脚本的输出日志路径
+func provisionLogPath(bootfsPath string) string {
+	return bootfsPath + ".provision.log"
+}
+
+// provisionScripts 返回构建时需要执行的脚本（宿主机路径）
+func (b *BootfsBuilder) provisionScripts() ([]string, error) {
+	var names []string
+	if b.Provision && b.Config.SetupScript != "" {
+		names = append(names, b.Config.SetupScript)
+	}
+	names = append(names, b.Config.ProvisionScripts...)
+
+	var scripts []string
+	for _, name := range names {
+		path := b.Config.ResolvePath(name)
+		if !utils.FileExists(path) {
+			return nil, fmt.Errorf("provision script not found: %s", path)
+		}
+		scripts = append(scripts, path)
+	}
+	return scripts, nil
+}
+
+// provision 在 chroot 中执行配置脚本
+//
+// 执行期间挂载 proc/sys/dev 并安装 policy-rc.d 阻止守护进程启动，
+// 无论成功与否都会清理挂载、policy-rc.d 及复制进 bootfs 的脚本。
+func (b *BootfsBuilder) provision(ctx context.Context) (err error) {
+	scripts, err := b.provisionScripts()
+	if err != nil {
+		return err
+	}
+	if len(scripts) == 0 {
+		return nil
+	}
+
+	slog.Info("Provisioning bootfs in chroot", "scripts", len(scripts))
+
+	logPath := provisionLogPath(b.BootfsPath)
+	logFile, err := b.Exec.Create(logPath)
+	if err != nil {
+		return fmt.Errorf("failed to create provision log: %v", err)
+	}
+	defer logFile.Close()
+
+	// BootfsPath 已是绝对路径，生成计划时目录尚不存在，不使用检查目录的 NewChroot
+	chroot := &bootfs.Chroot{Root: b.BootfsPath, Exec: b.Exec}
+	defer func() {
+		if closeErr := chroot.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}()
+
+	if err := chroot.Mount(ctx); err != nil {
+		return err
+	}
+	if err := chroot.BlockDaemons(ctx); err != nil {
+		return err
+	}
+
+	hostDir := filepath.Join(b.BootfsPath, provisionDir)
+	scriptDir, err := cleanup.Register(ctx, b.Exec, cleanup.Remove(hostDir), func() error {
+		return b.Exec.MkdirAll(hostDir, 0755)
+	})
+	if err != nil {
+		return err
+	}
+	defer scriptDir.Release()
+
+	for _, script := range scripts {
+		name := filepath.Base(script)
+		slog.Info("Running provision script", "script", name)
+		fmt.Fprintf(logFile, "=== %s\n", script)
+
+		data, err := os.ReadFile(script)
+		if err != nil {
+			return fmt.Errorf("failed to read provision script: %v", err)
+		}
+		if err := b.Exec.WriteFile(filepath.Join(hostDir, name), data, 0755); err != nil {
+			return fmt.Errorf("failed to copy provision script: %v", err)
+		}
+
+		if err := chroot.Run(ctx, logFile, "/"+provisionDir+"/"+name); err != nil {
+			return fmt.Errorf("provision script %s failed, see %s: %v", name, logPath, err)
+		}
+	}
+
+	slog.Info("Provision finished", "log", logPath)
+	return nil
+}
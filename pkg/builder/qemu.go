@@ -1,32 +1,51 @@
 package builder
 
 import (
+	"bytes"
+	"context"
 	"fmt"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"strings"
 
+	"github.com/rivsidn/kdev_bootstrap/pkg/audit"
+	"github.com/rivsidn/kdev_bootstrap/pkg/bootfs"
+	"github.com/rivsidn/kdev_bootstrap/pkg/cleanup"
 	"github.com/rivsidn/kdev_bootstrap/pkg/config"
+	"github.com/rivsidn/kdev_bootstrap/pkg/executor"
+	"github.com/rivsidn/kdev_bootstrap/pkg/metrics"
+	"github.com/rivsidn/kdev_bootstrap/pkg/owner"
+	"github.com/rivsidn/kdev_bootstrap/pkg/progress"
+	"github.com/rivsidn/kdev_bootstrap/pkg/sbom"
 	"github.com/rivsidn/kdev_bootstrap/pkg/utils"
 )
 
 // QemuBuilder QEMU 镜像构建器
 type QemuBuilder struct {
-	Config      *config.Config
-	BootfsPath  string
-	RootfsImage string
-	ImageSize   string
+	Config        *config.Config
+	BootfsPath    string
+	RootfsImage   string
+	ImageSize     string
+	Exec          executor.Executor // 执行外部命令，可以替换为 DryRun、Recorder、Replay
+	LogFile       string            // 构建日志，默认 <RootfsImage>.build.log
+	Progress      progress.Reporter // 接收阶段及进度事件，默认丢弃
+	SkipPreflight bool              // 不执行构建前的主机检查
+	MetricsFile   string            // 写入各阶段耗时的 Prometheus textfile，为空时不写入
+	Owner         *owner.Owner      // 镜像、凭据、SBOM 及日志的属主，nil 时不修改
+	RecordFile    string            // Recorder 的记录文件，与其他产物一同修改属主
+
+	archive    bool              // BootfsPath 是否为 bootfs 归档
+	sourcePath string            // 用户指定的 bootfs 目录或归档路径
+	timing     *metrics.Recorder // 统计各阶段及步骤的耗时
+	reporter   progress.Reporter // Progress 及 timing
+	sbomFiles  []string          // 生成的 SBOM 文件
 }
 
 // NewQemuBuilder 创建新的 QEMU 构建器
 func NewQemuBuilder(bootfsPath string, rootfsImage string, imageSize string) (*QemuBuilder, error) {
-	// 加载配置文件
-	configPath := filepath.Join(bootfsPath, "etc", "bootstrap.conf")
-	if !utils.FileExists(configPath) {
-		return nil, fmt.Errorf("configuration file not found: %s", configPath)
-	}
-
-	cfg, err := config.LoadConfig(configPath)
+	// 加载配置文件（bootfs 目录或归档）
+	cfg, err := bootfs.LoadConfig(bootfsPath)
 	if err != nil {
 		return nil, err
 	}
@@ -34,69 +53,170 @@ func NewQemuBuilder(bootfsPath string, rootfsImage string, imageSize string) (*Q
 	return &QemuBuilder{
 		Config:      cfg,
 		BootfsPath:  bootfsPath,
+		archive:     bootfs.IsArchive(bootfsPath),
+		sourcePath:  bootfsPath,
 		RootfsImage: rootfsImage,
 		ImageSize:   imageSize,
+		Exec:        executor.Default,
+		Progress:    progress.Discard,
 	}, nil
 }
 
-// Build 构建 QEMU 镜像
-func (b *QemuBuilder) Build() error {
+// Build 构建 QEMU 镜像，ctx 取消时终止正在执行的命令并清理
+func (b *QemuBuilder) Build(ctx context.Context) (err error) {
+	b.timing = metrics.NewRecorder()
+	b.reporter = progress.Multi(b.Progress, b.timing)
+
 	// 1. 检查环境
 	if err := b.checkEnvironment(); err != nil {
 		return err
 	}
 
-	// 2. 设置镜像名称
-	if b.RootfsImage == "" {
-		arch := b.Config.ArchCurrent
-		if arch == "" {
-			return fmt.Errorf("Can not find the valid arch");
+	// 2. 读取期间禁止修改 bootfs，之后解压 bootfs 归档
+	releaseSource, err := b.Exec.Lock(b.BootfsPath, true)
+	if err != nil {
+		return err
+	}
+	defer releaseSource()
+
+	if b.archive {
+		dir, cleanup, err := extractBootfs(ctx, b.Exec, b.BootfsPath)
+		if err != nil {
+			return err
 		}
-		b.RootfsImage = b.Config.GetRootfsName(arch)
+		defer cleanup()
+		b.BootfsPath = dir
+		b.archive = false
+	}
+
+	// 3. 设置镜像名称并加锁，防止同时构建同一镜像
+	if err := b.setRootfsImage(); err != nil {
+		return err
+	}
+	releaseImage, err := b.Exec.Lock(b.RootfsImage, false)
+	if err != nil {
+		return err
+	}
+	defer releaseImage()
+
+	if b.LogFile == "" {
+		b.LogFile = b.RootfsImage + buildLogSuffix
+	}
+	closeLog := openBuildLog(b.Exec, b.LogFile)
+	defer func() { closeLog(err) }()
+	defer func() { chownOutputs(b.Exec, b.Owner, b.outputs()...) }()
+	defer func() {
+		finishMetrics(b.Exec, b.timing, b.MetricsFile, "kboot_build_qemu", filepath.Base(b.RootfsImage), err)
+	}()
+
+	// 检查主机是否满足构建条件
+	if err := b.preflight(ctx); err != nil {
+		return err
 	}
 
-	// 3. 创建镜像文件
-	if err := b.createImage(); err != nil {
+	// 4. 创建镜像文件
+	if err := runStage(ctx, b.Config, b.reporter, config.StageQemuImage, b.createImage); err != nil {
 		return err
 	}
 
-	// 4. 格式化镜像
-	if err := b.formatImage(); err != nil {
+	// 5. 格式化镜像
+	if err := runStage(ctx, b.Config, b.reporter, config.StageQemuImage, b.formatImage); err != nil {
 		return err
 	}
 
-	// 5. 挂载镜像
-	mountPoint, err := b.mountImage()
+	// 6. 挂载镜像
+	mountPoint, unmount, err := b.mountImage(ctx)
 	if err != nil {
 		return err
 	}
-	defer b.unmountImage(mountPoint)
+	defer unmount()
 
-	// 6. 复制 rootfs
-	if err := b.copyRootfs(mountPoint); err != nil {
+	// 7. 复制 rootfs
+	err = runStage(ctx, b.Config, b.reporter, config.StageQemuCopy, func(ctx context.Context) error {
+		return b.copyRootfs(ctx, mountPoint)
+	})
+	if err != nil {
 		return err
 	}
 
-	// 7. 安装 bootloader（可选）
+	// 8. 安装 bootloader（可选）
 	b.installBootloader(mountPoint)
 
+	// 9. 复制凭据文件
+	if err := b.copyCredentials(); err != nil {
+		return err
+	}
+
+	// 10. 生成 SBOM
+	if err := b.writeSBOM(); err != nil {
+		return err
+	}
+
+	if executor.Simulated(b.Exec) {
+		return nil
+	}
 	fmt.Printf("\nQEMU image build successful: %s\n", b.RootfsImage)
 	fmt.Printf("   Size: %s\n", b.ImageSize)
 	fmt.Printf("   Usage:\n")
 	fmt.Printf("   qemu-system-x86_64 -hda %s -m 1024 -enable-kvm\n", b.RootfsImage)
+	b.timing.Metrics().Print(os.Stdout)
+
+	return nil
+}
 
+// setRootfsImage 设置镜像路径，未指定时根据配置生成，转换为绝对路径
+func (b *QemuBuilder) setRootfsImage() error {
+	if b.RootfsImage == "" {
+		arch := b.Config.ArchCurrent
+		if arch == "" {
+			return fmt.Errorf("Can not find the valid arch")
+		}
+		b.RootfsImage = b.Config.GetRootfsName(arch)
+	}
+	if abs, err := filepath.Abs(b.RootfsImage); err == nil {
+		b.RootfsImage = abs
+	}
 	return nil
 }
 
+// planLoopDevice 生成计划时 losetup -f 返回的 loop 设备
+const planLoopDevice = "/dev/loopN"
+
+// Plan 返回构建将要执行的命令及修改的文件，不做任何修改，不需要 root 权限
+//
+// 计划由 Build 使用 DryRun 执行器生成，loop 设备以 /dev/loopN 代替；bootfs 归档的内容
+// 在计划中不会解压，SBOM 中没有软件包。
+func (b *QemuBuilder) Plan(ctx context.Context) (*executor.Plan, error) {
+	if err := b.setRootfsImage(); err != nil {
+		return nil, err
+	}
+	plan := executor.NewPlan("kboot_build_qemu")
+	plan.Write(audit.Path(), "audit log, appended when run as root")
+
+	b.Exec = executor.DryRun{Plan: plan, Outputs: map[string]string{
+		"losetup -f": planLoopDevice + "\n",
+		executor.CommandLine("losetup", []string{"--noheadings", "--output", "BACK-FILE", planLoopDevice}): b.RootfsImage + "\n",
+	}}
+	b.SkipPreflight = true
+	if err := b.Build(ctx); err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
 // checkEnvironment 检查环境
 func (b *QemuBuilder) checkEnvironment() error {
-	// 检查 bootfs 目录
-	if !utils.DirExists(b.BootfsPath) {
+	// 检查 bootfs 目录或归档
+	if b.archive {
+		if !utils.FileExists(b.BootfsPath) {
+			return fmt.Errorf("bootfs archive does not exist: %s", b.BootfsPath)
+		}
+	} else if !utils.DirExists(b.BootfsPath) {
 		return fmt.Errorf("bootfs directory does not exist: %s", b.BootfsPath)
 	}
 
-	// 检查是否为 root
-	if !utils.CheckRoot() {
+	// 检查是否为 root，生成计划时不需要
+	if !executor.Simulated(b.Exec) && !CheckRoot() {
 		return fmt.Errorf("please run with sudo or root privileges")
 	}
 
@@ -104,28 +224,23 @@ func (b *QemuBuilder) checkEnvironment() error {
 }
 
 // createImage 创建镜像文件
-func (b *QemuBuilder) createImage() error {
-	// 检查镜像是否已存在
-	if utils.FileExists(b.RootfsImage) {
-		fmt.Printf("Image file %s already exists\n", b.RootfsImage)
-		if !utils.Confirm("Delete and recreate?") {
-			return fmt.Errorf("operation cancelled by user")
+func (b *QemuBuilder) createImage(ctx context.Context) error {
+	// 检查镜像是否已存在，生成计划时不询问
+	if b.Exec.Exists(b.RootfsImage) {
+		if !executor.Simulated(b.Exec) {
+			fmt.Printf("Image file %s already exists\n", b.RootfsImage)
+			if !utils.Confirm("Delete and recreate?") {
+				return fmt.Errorf("operation cancelled by user")
+			}
 		}
-		if err := os.Remove(b.RootfsImage); err != nil {
+		if err := b.Exec.Remove(b.RootfsImage); err != nil {
 			return fmt.Errorf("failed to delete image: %v", err)
 		}
 	}
 
-	fmt.Printf("\nCreating image file: %s (size: %s)\n", b.RootfsImage, b.ImageSize)
-
-	args := []string{
-		"create",
-		"-f", "raw",
-		b.RootfsImage,
-		b.ImageSize,
-	}
+	slog.Info("Creating image file", "path", b.RootfsImage, "size", b.ImageSize)
 
-	if err := utils.RunCommand("qemu-img", args...); err != nil {
+	if err := b.Exec.Run(ctx, "qemu-img", b.createArgs()...); err != nil {
 		return fmt.Errorf("failed to create image: %v", err)
 	}
 
@@ -133,105 +248,187 @@ func (b *QemuBuilder) createImage() error {
 }
 
 // formatImage 格式化镜像
-func (b *QemuBuilder) formatImage() error {
-	fmt.Println("Formatting image as ext3...")
+func (b *QemuBuilder) formatImage(ctx context.Context) error {
+	slog.Info("Formatting image as ext3")
 
 	// 创建 loop 设备
-	output, err := utils.RunCommandOutput("losetup", "-f")
+	output, err := b.Exec.Output(ctx, "losetup", "-f")
 	if err != nil {
 		return fmt.Errorf("failed to get free loop device: %v", err)
 	}
 	loopDevice := strings.TrimSpace(output)
 
 	// 关联镜像到 loop 设备
-	if err := utils.RunCommand("losetup", loopDevice, b.RootfsImage); err != nil {
+	loop, err := cleanup.Register(ctx, b.Exec, cleanup.DetachLoop(loopDevice, b.RootfsImage), func() error {
+		return b.Exec.Run(ctx, "losetup", loopDevice, b.RootfsImage)
+	})
+	if err != nil {
 		return fmt.Errorf("failed to associate loop device: %v", err)
 	}
-	defer utils.RunCommand("losetup", "-d", loopDevice)
+	defer loop.Release()
 
 	// 格式化为 ext3
-	if err := utils.RunCommand("mkfs.ext3", "-F", loopDevice); err != nil {
+	if err := b.Exec.Run(ctx, "mkfs.ext3", "-F", loopDevice); err != nil {
 		return fmt.Errorf("formatting failed: %v", err)
 	}
 
 	return nil
 }
 
-// mountImage 挂载镜像
-func (b *QemuBuilder) mountImage() (string, error) {
-	fmt.Println("Mounting image...")
+// qemuMountPrefix 镜像临时挂载点名称前缀
+const qemuMountPrefix = "kboot-qemu-mount-"
+
+// mountImage 挂载镜像，返回挂载点及卸载函数
+func (b *QemuBuilder) mountImage(ctx context.Context) (string, func(), error) {
+	slog.Info("Mounting image")
 
-	// 创建临时挂载点
-	mountPoint := fmt.Sprintf("/tmp/qemu-mount-%d", os.Getpid())
-	if err := utils.CreateDir(mountPoint); err != nil {
-		return "", err
+	// 创建临时挂载点，不同容器（PID 命名空间）中的构建可能 PID 相同
+	mountPoint, err := b.Exec.MkdirTemp("", qemuMountPrefix)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create mount point: %v", err)
+	}
+	dir, err := cleanup.Register(ctx, b.Exec, cleanup.Remove(mountPoint), nil)
+	if err != nil {
+		b.Exec.Remove(mountPoint)
+		return "", nil, err
 	}
 
 	// 挂载镜像
-	if err := utils.RunCommand("mount", "-o", "loop", b.RootfsImage, mountPoint); err != nil {
-		os.RemoveAll(mountPoint)
-		return "", fmt.Errorf("failed to mount image: %v", err)
+	mount, err := cleanup.Register(ctx, b.Exec, cleanup.Unmount(mountPoint), func() error {
+		stageCtx, cancel := context.WithTimeout(ctx, b.Config.Timeout(config.StageQemuImage))
+		defer cancel()
+		return b.Exec.Run(stageCtx, "mount", "-o", "loop", b.RootfsImage, mountPoint)
+	})
+	if err != nil {
+		dir.Release()
+		return "", nil, fmt.Errorf("failed to mount image: %v", err)
 	}
 
-	return mountPoint, nil
-}
+	unmount := func() {
+		slog.Info("Unmounting image")
+		if err := mount.Release(); err != nil {
+			slog.Warn(err.Error())
+			return
+		}
+		dir.Release()
+	}
 
-// unmountImage 卸载镜像
-func (b *QemuBuilder) unmountImage(mountPoint string) {
-	fmt.Println("Unmounting image...")
-	utils.RunCommand("umount", mountPoint)
-	os.RemoveAll(mountPoint)
+	return mountPoint, unmount, nil
 }
 
 // copyRootfs 复制根文件系统
-func (b *QemuBuilder) copyRootfs(mountPoint string) error {
-	fmt.Printf("Copying root filesystem to image...\n")
+func (b *QemuBuilder) copyRootfs(ctx context.Context, mountPoint string) error {
+	slog.Info("Copying root filesystem to image", "source", b.BootfsPath)
 
 	// 使用 rsync 或 cp 复制文件
-	if utils.CheckCommand("rsync") {
-		args := []string{
+	name, args := b.copyCommand(mountPoint)
+	w := progress.NewWriter(b.reporter, config.StageQemuCopy, progress.Rsync{})
+	err := b.Exec.RunWithLog(ctx, w, name, args...)
+	w.Flush()
+	if err != nil {
+		return fmt.Errorf("failed to copy files: %v", err)
+	}
+
+	// 创建必要的目录
+	for _, dir := range imageDirs {
+		b.Exec.MkdirAll(filepath.Join(mountPoint, dir), 0755)
+	}
+
+	// 设置权限
+	b.Exec.Chmod(filepath.Join(mountPoint, "tmp"), 0777)
+
+	return nil
+}
+
+// imageDirs 复制后在镜像中创建的目录
+var imageDirs = []string{"proc", "sys", "dev", "tmp", "run"}
+
+// createArgs 返回 qemu-img create 的参数
+func (b *QemuBuilder) createArgs() []string {
+	return []string{
+		"create",
+		"-f", "raw",
+		b.RootfsImage,
+		b.ImageSize,
+	}
+}
+
+// copyCommand 返回复制根文件系统的命令，没有 rsync 时使用 cp
+func (b *QemuBuilder) copyCommand(mountPoint string) (string, []string) {
+	if CheckCommand("rsync") {
+		return "rsync", []string{
 			"-av",
-			"--devices",    // 复制设备文件
-			"--specials",   // 复制特殊文件（如FIFO、socket等）
+			"--info=progress2", // 输出总体进度
+			"--devices",        // 复制设备文件
+			"--specials",       // 复制特殊文件（如FIFO、socket等）
 			"--exclude=/proc/*",
 			"--exclude=/sys/*",
 			"--exclude=/tmp/*",
 			b.BootfsPath + "/",
 			mountPoint + "/",
 		}
-		if err := utils.RunCommand("rsync", args...); err != nil {
-			return fmt.Errorf("failed to copy files: %v", err)
-		}
-	} else {
-		args := []string{
-			"-a",
-			b.BootfsPath + "/.",
-			mountPoint + "/",
-		}
-		if err := utils.RunCommand("cp", args...); err != nil {
-			return fmt.Errorf("failed to copy files: %v", err)
-		}
 	}
-
-	// 创建必要的目录
-	dirs := []string{"proc", "sys", "dev", "tmp", "run"}
-	for _, dir := range dirs {
-		dirPath := filepath.Join(mountPoint, dir)
-		if !utils.DirExists(dirPath) {
-			utils.CreateDir(dirPath)
-		}
+	return "cp", []string{
+		"-a",
+		b.BootfsPath + "/.",
+		mountPoint + "/",
 	}
-
-	// 设置权限
-	os.Chmod(filepath.Join(mountPoint, "tmp"), 0777)
-
-	return nil
 }
 
 // installBootloader 安装 bootloader（可选）
 func (b *QemuBuilder) installBootloader(mountPoint string) {
 	// 这里可以安装 GRUB 或其他 bootloader
 	// 目前跳过，用户可以手动安装或使用 -kernel 参数启动
-	fmt.Println("Skipping bootloader installation, use -kernel parameter to start QEMU")
+	slog.Info("Skipping bootloader installation, use -kernel parameter to start QEMU")
 }
 
+// copyCredentials 将 bootfs 的凭据文件复制到镜像旁
+func (b *QemuBuilder) copyCredentials() error {
+	src := CredentialsPath(b.sourcePath)
+	if !utils.FileExists(src) {
+		return nil
+	}
+
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return fmt.Errorf("failed to copy credentials: %v", err)
+	}
+	dst := CredentialsPath(b.RootfsImage)
+	if err := b.Exec.WriteFile(dst, data, 0600); err != nil {
+		return fmt.Errorf("failed to copy credentials: %v", err)
+	}
+
+	slog.Info("Credentials copied", "path", dst)
+	return nil
+}
+
+// writeSBOM 在镜像旁生成 SPDX 及 CycloneDX SBOM
+//
+// 生成计划时解压的 bootfs 归档并不存在，SBOM 中没有软件包。
+func (b *QemuBuilder) writeSBOM() error {
+	name := filepath.Base(b.RootfsImage)
+	doc, err := sbom.Collect(b.BootfsPath, name)
+	if err != nil && executor.Simulated(b.Exec) {
+		doc, err = &sbom.Document{Name: name}, nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to generate SBOM: %v", err)
+	}
+
+	var files []string
+	for _, format := range sbom.Formats {
+		path := b.RootfsImage + format.Suffix()
+		var buf bytes.Buffer
+		if err := doc.Write(&buf, format); err != nil {
+			return fmt.Errorf("failed to write SBOM %s: %v", path, err)
+		}
+		if err := b.Exec.WriteFile(path, buf.Bytes(), 0644); err != nil {
+			return fmt.Errorf("failed to write SBOM %s: %v", path, err)
+		}
+		files = append(files, path)
+	}
+	b.sbomFiles = files
+
+	slog.Info("SBOM written", "files", strings.Join(files, ","))
+	return nil
+}
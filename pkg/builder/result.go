@@ -0,0 +1,46 @@
+package builder
+
+import (
+	"path/filepath"
+
+	"github.com/rivsidn/kdev_bootstrap/pkg/manifest"
+	"github.com/rivsidn/kdev_bootstrap/pkg/result"
+)
+
+// Result 将产物、配置及各阶段耗时记录到命令结果，构建失败时只包括已生成的文件
+func (b *BootfsBuilder) Result(r *result.Result) {
+	r.SetConfig(b.Config)
+	r.Metrics = timingMetrics(b.timing)
+	if b.BootfsPath == "" {
+		return
+	}
+	r.AddPath(result.ArtifactBootfs, b.BootfsPath)
+	r.AddPath(result.ArtifactConfig, filepath.Join(b.BootfsPath, "etc/bootstrap.conf"))
+	r.AddPath(result.ArtifactManifest, manifest.SidecarPath(b.BootfsPath))
+	r.AddPath(result.ArtifactCredentials, CredentialsPath(b.BootfsPath))
+	r.AddPath(result.ArtifactBuildLog, b.LogFile)
+	r.AddPath(result.ArtifactMetrics, b.MetricsFile)
+}
+
+// Result 将产物、配置及各阶段耗时记录到命令结果，镜像只在构建成功时包括
+func (b *DockerBuilder) Result(r *result.Result) {
+	r.SetConfig(b.Config)
+	r.Metrics = timingMetrics(b.timing)
+	if b.built {
+		r.AddName(result.ArtifactDockerImage, b.ImageName)
+	}
+	r.AddPath(result.ArtifactBuildLog, b.LogFile)
+	r.AddPath(result.ArtifactMetrics, b.MetricsFile)
+}
+
+// Result 将产物、配置及各阶段耗时记录到命令结果，构建失败时只包括已生成的文件
+func (b *QemuBuilder) Result(r *result.Result) {
+	r.SetConfig(b.Config)
+	r.Metrics = timingMetrics(b.timing)
+	r.AddPath(result.ArtifactQemuImage, b.RootfsImage)
+	for _, file := range b.sbomFiles {
+		r.AddPath(result.ArtifactSBOM, file)
+	}
+	r.AddPath(result.ArtifactBuildLog, b.LogFile)
+	r.AddPath(result.ArtifactMetrics, b.MetricsFile)
+}
@@ -0,0 +1,46 @@
+package builder
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"fmt"
+
+	"github.com/rivsidn/kdev_bootstrap/pkg/executor"
+	"github.com/rivsidn/kdev_bootstrap/pkg/sbom"
+)
+
+// SBOMLabel 保存 CycloneDX SBOM 的 Docker 镜像标签，值为 gzip 压缩后的 base64 编码
+const SBOMLabel = "io.github.rivsidn.kboot.sbom.cyclonedx"
+
+// maxSBOMLabelSize 标签通过命令行参数传给 docker，单个参数不能超过 128KB
+const maxSBOMLabelSize = 120 * 1024
+
+// planSBOMLabel 生成计划时代替标签值，bootfs 归档在计划中没有解压
+const planSBOMLabel = "<CycloneDX SBOM, gzip+base64>"
+
+// sbomLabel 生成 bootfs 的 CycloneDX SBOM 标签值
+func sbomLabel(exec executor.Executor, bootfsPath, name string) (string, error) {
+	if executor.Simulated(exec) {
+		return planSBOMLabel, nil
+	}
+	doc, err := sbom.Collect(bootfsPath, name)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if err := doc.WriteCycloneDX(zw); err != nil {
+		return "", err
+	}
+	if err := zw.Close(); err != nil {
+		return "", err
+	}
+
+	label := base64.StdEncoding.EncodeToString(buf.Bytes())
+	if len(label) > maxSBOMLabelSize {
+		return "", fmt.Errorf("SBOM is too large for an image label (%d bytes)", len(label))
+	}
+	return label, nil
+}
@@ -0,0 +1,258 @@
+package builder
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/rivsidn/kdev_bootstrap/pkg/dpkg"
+	"github.com/rivsidn/kdev_bootstrap/pkg/executor"
+	"github.com/rivsidn/kdev_bootstrap/pkg/manifest"
+	"github.com/rivsidn/kdev_bootstrap/pkg/utils"
+)
+
+// slimRule 精简规则，exclude/include 使用 dpkg path-exclude 的匹配语法（"*" 可以匹配 "/"）
+type slimRule struct {
+	exclude []string
+	include []string // 排除后保留的文件
+	dpkg    bool     // 是否写入 dpkg path-exclude，之后安装的软件包同样生效
+	apt     string   // 写入 apt 配置的内容
+}
+
+// slimRules 精简规则定义，名称见 config.SlimRules
+var slimRules = map[string]slimRule{
+	"docs": {
+		exclude: []string{"/usr/share/doc/*", "/usr/share/info/*", "/usr/share/lintian/*", "/usr/share/linda/*"},
+		// copyright 用于生成 SBOM
+		include: []string{"/usr/share/doc/*/copyright"},
+		dpkg:    true,
+	},
+	"man": {
+		exclude: []string{"/usr/share/man/*"},
+		dpkg:    true,
+	},
+	"locales": {
+		exclude: []string{"/usr/share/locale/*", "/usr/share/i18n/*"},
+		// 编译好的 locale（如 C.UTF-8）在 /usr/lib/locale 中，不受影响；保留 locale 别名、
+		// locales 软件包使用的 SUPPORTED 列表及重新生成 C.UTF-8 所需的源文件
+		include: []string{
+			"/usr/share/locale/locale.alias",
+			"/usr/share/i18n/SUPPORTED",
+			"/usr/share/i18n/charmaps/UTF-8.gz",
+			"/usr/share/i18n/locales/C",
+		},
+		dpkg: true,
+	},
+	"apt-lists": {
+		exclude: []string{"/var/lib/apt/lists/*"},
+		include: []string{"/var/lib/apt/lists/lock", "/var/lib/apt/lists/partial"},
+	},
+	"cache": {
+		exclude: []string{"/var/cache/apt/*.bin", "/var/cache/apt/archives/*.deb", "/var/cache/apt/archives/partial/*"},
+		apt: `DPkg::Post-Invoke { "rm -f /var/cache/apt/archives/*.deb /var/cache/apt/archives/partial/*.deb /var/cache/apt/*.bin || true"; };
+APT::Keep-Downloaded-Packages "false";
+`,
+	},
+}
+
+// 精简配置在 bootfs 中的路径
+const (
+	slimDpkgConfig = "/etc/dpkg/dpkg.cfg.d/01kboot-slim"
+	slimAptConfig  = "/etc/apt/apt.conf.d/01kboot-slim"
+)
+
+// pathExcludeVersion 支持 path-exclude 的最低 dpkg 版本
+const pathExcludeVersion = "1.15.8"
+
+// slim 按配置的规则删除文件并写入 dpkg/apt 配置，返回每条规则节省的字节数
+func (b *BootfsBuilder) slim(ctx context.Context) ([]manifest.SlimResult, error) {
+	if len(b.Config.Slim) == 0 {
+		return nil, nil
+	}
+
+	slog.Info("Slimming bootfs", "rules", strings.Join(b.Config.Slim, ","))
+
+	// 1. 写入配置，之后安装的软件包同样精简
+	if err := b.writeSlimConfig(); err != nil {
+		return nil, err
+	}
+
+	// 2. 删除文件
+	var results []manifest.SlimResult
+	var total int64
+	for _, name := range b.Config.Slim {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		rule := slimRules[name]
+		saved, err := b.applySlimRule(rule)
+		if err != nil {
+			return nil, fmt.Errorf("slim rule %s failed: %v", name, err)
+		}
+		results = append(results, manifest.SlimResult{Rule: name, BytesSaved: saved, Exclude: rule.exclude, Include: rule.include})
+		total += saved
+		slog.Info("Slim rule applied", "rule", name, "saved", utils.FormatBytes(saved))
+	}
+	slog.Info("Slimming finished", "saved", utils.FormatBytes(total))
+
+	return results, nil
+}
+
+// writeSlimConfig 写入 dpkg path-exclude 及 apt 配置
+func (b *BootfsBuilder) writeSlimConfig() error {
+	var dpkgLines []string
+	var aptLines []string
+	for _, name := range b.Config.Slim {
+		rule := slimRules[name]
+		if rule.dpkg {
+			dpkgLines = append(dpkgLines, "# "+name)
+			for _, pattern := range rule.exclude {
+				dpkgLines = append(dpkgLines, "path-exclude="+pattern)
+			}
+			for _, pattern := range rule.include {
+				dpkgLines = append(dpkgLines, "path-include="+pattern)
+			}
+		}
+		if rule.apt != "" {
+			aptLines = append(aptLines, "// "+name, rule.apt)
+		}
+	}
+
+	if len(dpkgLines) > 0 {
+		if b.dpkgSupportsPathExclude() {
+			content := "# Generated by kboot_build_bootfs (slim)\n" + strings.Join(dpkgLines, "\n") + "\n"
+			if err := b.writeBootfsFile(slimDpkgConfig, content, 0644); err != nil {
+				return err
+			}
+		} else {
+			slog.Warn("dpkg in bootfs does not support path-exclude, packages installed later are not slimmed", "required", pathExcludeVersion)
+		}
+	}
+
+	if len(aptLines) > 0 && b.bootfsHas("/etc/apt/apt.conf.d") {
+		content := "// Generated by kboot_build_bootfs (slim)\n" + strings.Join(aptLines, "\n")
+		if err := b.writeBootfsFile(slimAptConfig, content, 0644); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// dpkgSupportsPathExclude 判断 bootfs 中的 dpkg 是否支持 path-exclude，旧版本遇到未知选项会报错
+//
+// 生成计划时 bootfs 尚不存在，假定支持。
+func (b *BootfsBuilder) dpkgSupportsPathExclude() bool {
+	if executor.Simulated(b.Exec) {
+		return true
+	}
+	if !b.bootfsHas("/etc/dpkg/dpkg.cfg.d") {
+		return false
+	}
+
+	packages, err := dpkg.ReadInstalled(b.BootfsPath)
+	if err != nil {
+		return false
+	}
+	for _, p := range packages {
+		if p.Name == "dpkg" {
+			return dpkg.CompareVersions(p.Version, pathExcludeVersion) >= 0
+		}
+	}
+	return false
+}
+
+// applySlimRule 删除规则匹配的文件，返回删除的字节数，每条规则在审计日志中记录一次
+func (b *BootfsBuilder) applySlimRule(rule slimRule) (int64, error) {
+	patterns := make([]string, len(rule.exclude))
+	for i, pattern := range rule.exclude {
+		patterns[i] = b.bootfsPath(pattern)
+	}
+
+	filter := &dpkg.PathFilter{}
+	for _, pattern := range rule.exclude {
+		filter.Exclude(pattern)
+	}
+	for _, pattern := range rule.include {
+		filter.Include(pattern)
+	}
+	included := func(path string) bool { return !filter.Excluded(path) }
+
+	var saved int64
+	var files []string
+	seen := make(map[uint64]bool) // 硬链接只计算一次
+	remove := func(path string, info fs.FileInfo) {
+		if info.Mode().IsRegular() {
+			st, ok := info.Sys().(*syscall.Stat_t)
+			if !ok || st.Nlink <= 1 {
+				saved += info.Size()
+			} else if !seen[st.Ino] {
+				seen[st.Ino] = true
+				saved += info.Size()
+			}
+		}
+		files = append(files, b.bootfsPath(path))
+	}
+
+	var dirs []string
+	for _, pattern := range rule.exclude {
+		matches, err := filepath.Glob(b.bootfsPath(pattern))
+		if err != nil {
+			return 0, err
+		}
+
+		for _, match := range matches {
+			// 生成计划时磁盘上的 bootfs 已在计划中删除
+			if !b.Exec.Exists(match) {
+				continue
+			}
+			rel := "/" + strings.TrimPrefix(match[len(filepath.Clean(b.BootfsPath)):], "/")
+			info, err := os.Lstat(match)
+			if err != nil {
+				return 0, err
+			}
+			if !info.IsDir() {
+				if !included(rel) {
+					remove(rel, info)
+				}
+				continue
+			}
+
+			// 目录：删除未保留的文件，之后删除空目录
+			err = filepath.Walk(match, func(path string, info fs.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+				rel := "/" + strings.TrimPrefix(path[len(filepath.Clean(b.BootfsPath)):], "/")
+				if included(rel) {
+					if info.IsDir() {
+						return filepath.SkipDir
+					}
+					return nil
+				}
+				if info.IsDir() {
+					dirs = append(dirs, b.bootfsPath(rel))
+					return nil
+				}
+				remove(rel, info)
+				return nil
+			})
+			if err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	// 非空目录（其中有保留的文件）不删除
+	sort.Sort(sort.Reverse(sort.StringSlice(dirs)))
+	if err := b.Exec.RemoveMatched(patterns, append(files, dirs...)); err != nil {
+		return 0, err
+	}
+	return saved, nil
+}
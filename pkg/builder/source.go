@@ -0,0 +1,26 @@
+package builder
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/rivsidn/kdev_bootstrap/pkg/bootfs"
+	"github.com/rivsidn/kdev_bootstrap/pkg/executor"
+)
+
+// extractBootfs 通过 exec 将 bootfs 归档解压到临时目录，返回目录路径及清理函数
+func extractBootfs(ctx context.Context, exec executor.Executor, archivePath string) (string, func(), error) {
+	slog.Info("Extracting bootfs archive", "archive", archivePath)
+
+	dir, remove, err := bootfs.ImportTemp(ctx, exec, archivePath)
+	if err != nil {
+		return "", nil, err
+	}
+
+	cleanup := func() {
+		slog.Info("Removing extracted bootfs", "dir", dir)
+		remove()
+	}
+
+	return dir, cleanup, nil
+}
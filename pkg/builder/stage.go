@@ -0,0 +1,31 @@
+package builder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/rivsidn/kdev_bootstrap/pkg/config"
+	"github.com/rivsidn/kdev_bootstrap/pkg/progress"
+)
+
+// runStage 在阶段超时内执行 fn，超时时说明如何调整，阶段的开始及结束报告给 r
+func runStage(ctx context.Context, cfg *config.Config, r progress.Reporter, stage string, fn func(ctx context.Context) error) (err error) {
+	done := progress.Stage(r, stage)
+	defer func() { done(err) }()
+
+	timeout := cfg.Timeout(stage)
+	if timeout <= 0 {
+		return fn(ctx)
+	}
+
+	stageCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	err = fn(stageCtx)
+	if err != nil && ctx.Err() == nil && errors.Is(stageCtx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%s timed out after %s (raise it with [timeouts] %s = DURATION or --timeout %s=DURATION): %v",
+			stage, timeout, stage, stage, err)
+	}
+	return err
+}
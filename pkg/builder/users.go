@@ -0,0 +1,171 @@
+package builder
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rivsidn/kdev_bootstrap/pkg/config"
+	"github.com/rivsidn/kdev_bootstrap/pkg/executor"
+)
+
+// 普通用户的起始 uid/gid
+const firstUserID = 1000
+
+// accountDB bootfs 中的 passwd/group/shadow/gshadow 文件
+type accountDB struct {
+	b     *BootfsBuilder
+	files map[string][]string // 文件名 -> 行
+}
+
+// initialAccounts debootstrap 生成的账户数据库，生成计划时 bootfs 尚不存在，以此代替
+var initialAccounts = map[string]string{
+	"passwd":  "root:x:0:0:root:/root:/bin/bash",
+	"group":   "root:x:0:",
+	"shadow":  "root:*:0:0:99999:7:::",
+	"gshadow": "root:*::",
+}
+
+// loadAccountDB 读取 bootfs 中的账户数据库
+func (b *BootfsBuilder) loadAccountDB() (*accountDB, error) {
+	db := &accountDB{b: b, files: make(map[string][]string)}
+	for _, name := range []string{"passwd", "group", "shadow", "gshadow"} {
+		data, err := b.readAccountFile(name)
+		if err != nil {
+			if os.IsNotExist(err) && name == "gshadow" {
+				continue
+			}
+			return nil, err
+		}
+		db.files[name] = strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	}
+	return db, nil
+}
+
+// readAccountFile 读取 bootfs 中的 /etc/<name>，生成计划时不存在则返回 debootstrap 生成的内容
+func (b *BootfsBuilder) readAccountFile(name string) ([]byte, error) {
+	data, err := b.Exec.ReadFile(b.bootfsPath("/etc/" + name))
+	if os.IsNotExist(err) && executor.Simulated(b.Exec) {
+		return []byte(initialAccounts[name]), nil
+	}
+	return data, err
+}
+
+// save 写回账户数据库，shadow 文件保持仅 root 可读
+func (db *accountDB) save() error {
+	perms := map[string]os.FileMode{"passwd": 0644, "group": 0644, "shadow": 0640, "gshadow": 0640}
+	for name, lines := range db.files {
+		if err := db.b.writeBootfsFile("/etc/"+name, strings.Join(lines, "\n")+"\n", perms[name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// find 返回名称对应的行号
+func (db *accountDB) find(file, name string) int {
+	for i, line := range db.files[file] {
+		if strings.HasPrefix(line, name+":") {
+			return i
+		}
+	}
+	return -1
+}
+
+// nextID 返回 passwd/group 中未使用的最小普通用户 ID
+func (db *accountDB) nextID(file string) int {
+	used := make(map[int]bool)
+	for _, line := range db.files[file] {
+		fields := strings.Split(line, ":")
+		if len(fields) > 2 {
+			if id, err := strconv.Atoi(fields[2]); err == nil {
+				used[id] = true
+			}
+		}
+	}
+	id := firstUserID
+	for used[id] {
+		id++
+	}
+	return id
+}
+
+// addUser 创建普通用户及同名组，已存在时跳过
+func (db *accountDB) addUser(name string) error {
+	if db.find("passwd", name) >= 0 {
+		return nil
+	}
+
+	uid := db.nextID("passwd")
+	gid := db.nextID("group")
+	if db.find("group", name) < 0 {
+		db.files["group"] = append(db.files["group"], fmt.Sprintf("%s:x:%d:", name, gid))
+		if _, ok := db.files["gshadow"]; ok {
+			db.files["gshadow"] = append(db.files["gshadow"], fmt.Sprintf("%s:!::", name))
+		}
+	} else {
+		gid, _ = strconv.Atoi(strings.Split(db.files["group"][db.find("group", name)], ":")[2])
+	}
+
+	home := "/home/" + name
+	db.files["passwd"] = append(db.files["passwd"], fmt.Sprintf("%s:x:%d:%d::%s:/bin/bash", name, uid, gid, home))
+	db.files["shadow"] = append(db.files["shadow"], fmt.Sprintf("%s:*:%d:0:99999:7:::", name, shadowDays()))
+
+	homePath, err := db.b.createBootfsDir(home)
+	if err != nil {
+		return err
+	}
+	if err := db.b.Exec.Chmod(homePath, 0755); err != nil {
+		return err
+	}
+	return db.b.Exec.Lchown(homePath, uid, gid)
+}
+
+// setPasswordHash 设置 shadow 中用户的密码哈希
+func (db *accountDB) setPasswordHash(name, hash string) error {
+	i := db.find("shadow", name)
+	if i < 0 {
+		return fmt.Errorf("user %s not found in bootfs /etc/shadow", name)
+	}
+
+	fields := strings.Split(db.files["shadow"][i], ":")
+	for len(fields) < 9 {
+		fields = append(fields, "")
+	}
+	fields[1] = hash
+	fields[2] = strconv.Itoa(shadowDays())
+	db.files["shadow"][i] = strings.Join(fields, ":")
+	return nil
+}
+
+// shadowDays 返回 shadow 中使用的 1970-01-01 以来的天数
+func shadowDays() int {
+	return int(time.Now().Unix() / 86400)
+}
+
+// configureUsers 创建用户并设置密码哈希
+func (b *BootfsBuilder) configureUsers(users *config.UsersConfig) error {
+	db, err := b.loadAccountDB()
+	if err != nil {
+		return err
+	}
+
+	for _, name := range users.Accounts {
+		slog.Info("Creating user", "user", name)
+		if err := db.addUser(name); err != nil {
+			return fmt.Errorf("failed to create user %s: %v", name, err)
+		}
+	}
+
+	for name, hash := range users.PasswordHashes {
+		slog.Info("Setting password hash", "user", name)
+		if err := db.setPasswordHash(name, hash); err != nil {
+			return err
+		}
+	}
+
+	return db.save()
+}
@@ -0,0 +1,328 @@
+package cleanup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/rivsidn/kdev_bootstrap/pkg/executor"
+)
+
+// 撤销操作类型
+const (
+	KindUnmount    = "unmount"     // 卸载挂载点
+	KindDetachLoop = "detach-loop" // 释放 loop 设备
+	KindRemove     = "remove"      // 删除临时文件或目录
+	KindRestore    = "restore"     // 删除替换的文件并恢复备份
+)
+
+// Action 可以序列化的撤销操作，进程异常退出后 kboot doctor --cleanup 根据记录执行
+type Action struct {
+	Kind   string `json:"kind"`
+	Path   string `json:"path"`
+	Backup string `json:"backup,omitempty"` // restore: 原文件的备份路径，为空表示原来不存在
+	Image  string `json:"image,omitempty"`  // detach-loop: 关联的镜像文件
+}
+
+// Unmount 卸载 path
+func Unmount(path string) Action {
+	return Action{Kind: KindUnmount, Path: absPath(path)}
+}
+
+// DetachLoop 释放关联到 image 的 loop 设备
+func DetachLoop(device, image string) Action {
+	return Action{Kind: KindDetachLoop, Path: device, Image: absPath(image)}
+}
+
+// Remove 删除 path（目录递归删除）
+func Remove(path string) Action {
+	return Action{Kind: KindRemove, Path: absPath(path)}
+}
+
+// Restore 删除 path，backup 不为空时将其恢复为 path
+func Restore(path, backup string) Action {
+	if backup != "" {
+		backup = absPath(backup)
+	}
+	return Action{Kind: KindRestore, Path: absPath(path), Backup: backup}
+}
+
+func (a Action) String() string {
+	switch a.Kind {
+	case KindDetachLoop:
+		return fmt.Sprintf("detach %s from %s", a.Path, a.Image)
+	case KindRestore:
+		if a.Backup != "" {
+			return fmt.Sprintf("restore %s from %s", a.Path, a.Backup)
+		}
+		return fmt.Sprintf("remove %s", a.Path)
+	default:
+		return fmt.Sprintf("%s %s", a.Kind, a.Path)
+	}
+}
+
+// Undo 通过 exec 执行撤销操作，已经撤销（如已卸载）时直接返回，可以重复执行
+//
+// 挂载、loop 设备的状态及文件同样通过 exec 查询和修改，DryRun、Replay 不依赖也不修改主机。
+func (a Action) Undo(ctx context.Context, exec executor.Executor) error {
+	exec = executor.Or(exec)
+
+	switch a.Kind {
+	case KindUnmount:
+		if _, err := exec.Output(ctx, "mountpoint", "-q", a.Path); err != nil {
+			return nil
+		}
+		if err := exec.Run(ctx, "umount", a.Path); err != nil {
+			// 仍有进程使用时延迟卸载
+			if err := exec.Run(ctx, "umount", "-l", a.Path); err != nil {
+				return fmt.Errorf("failed to unmount %s: %v", a.Path, err)
+			}
+		}
+
+	case KindDetachLoop:
+		// loop 设备可能已被释放并分配给其他镜像
+		output, err := exec.Output(ctx, "losetup", "--noheadings", "--output", "BACK-FILE", a.Path)
+		if err != nil {
+			return nil
+		}
+		if backing := strings.TrimSuffix(strings.TrimSpace(output), " (deleted)"); backing != a.Image {
+			return nil
+		}
+		if err := exec.Run(ctx, "losetup", "-d", a.Path); err != nil {
+			return fmt.Errorf("failed to detach %s: %v", a.Path, err)
+		}
+
+	case KindRemove:
+		// 卸载失败时不能删除挂载点，否则会删除挂载的内容
+		mountPoints, err := listMountPoints(ctx, exec)
+		if err != nil {
+			return fmt.Errorf("failed to list mounts, not removing %s: %v", a.Path, err)
+		}
+		for _, mountPoint := range mountPoints {
+			if mountPoint == a.Path || strings.HasPrefix(mountPoint, a.Path+"/") {
+				return fmt.Errorf("%s is still mounted, not removing %s", mountPoint, a.Path)
+			}
+		}
+		if err := exec.RemoveAll(a.Path); err != nil {
+			return fmt.Errorf("failed to remove %s: %v", a.Path, err)
+		}
+
+	case KindRestore:
+		// 备份已不存在说明已经恢复，不能再删除恢复的文件
+		if a.Backup != "" && !exec.Exists(a.Backup) {
+			return nil
+		}
+		if err := exec.Remove(a.Path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove %s: %v", a.Path, err)
+		}
+		if a.Backup == "" {
+			return nil
+		}
+		if err := exec.Rename(a.Backup, a.Path); err != nil {
+			return fmt.Errorf("failed to restore %s: %v", a.Path, err)
+		}
+
+	default:
+		return fmt.Errorf("unknown cleanup action: %s", a.Kind)
+	}
+	return nil
+}
+
+// listMountPoints 通过 exec 执行 findmnt 列出所有挂载点，没有输出（DryRun）时返回空列表
+func listMountPoints(ctx context.Context, exec executor.Executor) ([]string, error) {
+	output, err := exec.Output(ctx, "findmnt", "--json", "--list", "--output", "TARGET")
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(output) == "" {
+		return nil, nil
+	}
+
+	var mounts struct {
+		Filesystems []struct {
+			Target string `json:"target"`
+		} `json:"filesystems"`
+	}
+	if err := json.Unmarshal([]byte(output), &mounts); err != nil {
+		return nil, fmt.Errorf("invalid findmnt output: %v", err)
+	}
+	var mountPoints []string
+	for _, fs := range mounts.Filesystems {
+		mountPoints = append(mountPoints, fs.Target)
+	}
+	return mountPoints, nil
+}
+
+// Entry 已登记的撤销操作
+type Entry struct {
+	action Action
+	exec   executor.Executor // 执行撤销操作
+	ctx    context.Context   // 登记时的 ctx，不随其取消，构建被中断后同样可以撤销
+}
+
+// registry 当前进程登记的撤销操作，按登记顺序排列
+var registry struct {
+	sync.Mutex
+	entries []*Entry
+	closed  bool // 收到信号后不再执行新的操作
+}
+
+// Register 执行 change 并登记对应的撤销操作，change 为 nil 时只登记
+//
+// change 应通过 exec 执行命令及修改文件；撤销操作同样通过 exec 执行，exec 为 nil 时使用 executor.Default。
+// change 执行期间收到信号时，清理会等待 change 完成，之后一并撤销。change 失败时不登记。
+func Register(ctx context.Context, exec executor.Executor, action Action, change func() error) (*Entry, error) {
+	registry.Lock()
+	defer registry.Unlock()
+
+	if registry.closed {
+		return nil, fmt.Errorf("interrupted")
+	}
+	if change != nil {
+		if err := change(); err != nil {
+			return nil, err
+		}
+	}
+
+	e := &Entry{action: action, exec: executor.Or(exec), ctx: context.WithoutCancel(ctx)}
+	registry.entries = append(registry.entries, e)
+	saveJournal()
+	return e, nil
+}
+
+// Release 立即执行撤销操作并取消登记，可以重复调用
+//
+// 撤销失败时保留登记，之后由信号处理或 kboot doctor --cleanup 重试。
+func (e *Entry) Release() error {
+	if e == nil {
+		return nil
+	}
+
+	registry.Lock()
+	defer registry.Unlock()
+
+	if !e.registered() {
+		return nil
+	}
+	if err := e.action.Undo(e.ctx, e.exec); err != nil {
+		return err
+	}
+	e.forget()
+	return nil
+}
+
+// Forget 取消登记，不执行撤销操作
+func (e *Entry) Forget() {
+	if e == nil {
+		return
+	}
+
+	registry.Lock()
+	defer registry.Unlock()
+
+	e.forget()
+}
+
+// registered 判断是否仍在登记中，调用者需持有 registry 锁
+func (e *Entry) registered() bool {
+	for _, entry := range registry.entries {
+		if entry == e {
+			return true
+		}
+	}
+	return false
+}
+
+// forget 取消登记，调用者需持有 registry 锁
+func (e *Entry) forget() {
+	for i, entry := range registry.entries {
+		if entry == e {
+			registry.entries = append(registry.entries[:i], registry.entries[i+1:]...)
+			saveJournal()
+			return
+		}
+	}
+}
+
+// RunAll 逆序执行所有登记的撤销操作，之后不再接受新的登记，失败的操作保留在记录中
+func RunAll() []error {
+	registry.Lock()
+	defer registry.Unlock()
+
+	registry.closed = true
+	var errs []error
+	for i := len(registry.entries) - 1; i >= 0; i-- {
+		e := registry.entries[i]
+		if err := e.action.Undo(e.ctx, e.exec); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		e.forget()
+	}
+	return errs
+}
+
+// CancelGrace 收到信号取消构建后，等待命令退出并自行清理的时间，超时后强制清理并退出
+const CancelGrace = 30 * time.Second
+
+var (
+	handleOnce sync.Once
+	received   atomic.Value // 收到的信号 syscall.Signal
+)
+
+// HandleSignals 收到 SIGINT、SIGTERM 时调用 cancel 终止正在执行的命令，
+// 由调用者在返回后执行清理；再次收到信号或 CancelGrace 后仍未退出时执行所有撤销操作并退出，
+// 退出码为 128 + 信号
+func HandleSignals(cancel context.CancelFunc) {
+	handleOnce.Do(func() {
+		signals := make(chan os.Signal, 2)
+		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+		go func() {
+			sig := (<-signals).(syscall.Signal)
+			received.Store(sig)
+			fmt.Fprintf(os.Stderr, "\nReceived %v, stopping (press Ctrl-C again to force cleanup)...\n", sig)
+			cancel()
+
+			select {
+			case s := <-signals:
+				sig = s.(syscall.Signal)
+			case <-time.After(CancelGrace):
+			}
+			fmt.Fprintf(os.Stderr, "Cleaning up...\n")
+			for _, err := range RunAll() {
+				fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+			}
+			os.Exit(128 + int(sig))
+		}()
+	})
+}
+
+// Interrupted 返回是否收到过 SIGINT、SIGTERM
+func Interrupted() bool {
+	return received.Load() != nil
+}
+
+// ExitCode 收到过信号时返回 128 + 信号，否则返回 code
+func ExitCode(code int) int {
+	if sig, ok := received.Load().(syscall.Signal); ok {
+		return 128 + int(sig)
+	}
+	return code
+}
+
+// absPath 返回绝对路径，失败时返回原路径
+func absPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
@@ -0,0 +1,83 @@
+package cleanup
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rivsidn/kdev_bootstrap/pkg/executor"
+)
+
+// findmnt 列出挂载点的记录
+func findmnt(output string) executor.Record {
+	return executor.Record{Name: "findmnt", Args: []string{"--json", "--list", "--output", "TARGET"}, Output: output}
+}
+
+func TestUndoRemove(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "kboot-qemu-mount-1")
+	if err := os.Mkdir(path, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	replay := executor.NewReplay([]executor.Record{
+		findmnt(`{"filesystems": [{"target": "/"}, {"target": "` + path + `/proc"}]}`),
+		findmnt(`{"filesystems": [{"target": "/"}, {"target": "` + path + `-2"}]}`),
+	})
+	ctx := context.Background()
+
+	err := Remove(path).Undo(ctx, replay)
+	if err == nil || !strings.Contains(err.Error(), "is still mounted") {
+		t.Fatalf("Undo with a mount under %s = %v, want still mounted", path, err)
+	}
+	if !replay.Plan.Exists(path) {
+		t.Fatal("mounted directory removed")
+	}
+
+	if err := Remove(path).Undo(ctx, replay); err != nil {
+		t.Fatalf("Undo: %v", err)
+	}
+	if replay.Plan.Exists(path) {
+		t.Error("directory not removed")
+	}
+	if err := replay.Done(); err != nil {
+		t.Error(err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("Undo through Replay changed the file system: %v", err)
+	}
+}
+
+func TestUndoRestore(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "policy-rc.d")
+	saved := path + ".kboot-saved"
+	exec := executor.DryRun{Plan: executor.NewPlan("test")}
+	ctx := context.Background()
+
+	// 原文件不存在时只删除替换的文件
+	exec.WriteFile(path, []byte("exit 101\n"), 0755)
+	if err := Restore(path, "").Undo(ctx, exec); err != nil {
+		t.Fatalf("Undo: %v", err)
+	}
+	if exec.Exists(path) {
+		t.Errorf("%s not removed", path)
+	}
+
+	// 重复撤销不会删除已恢复的文件
+	exec.WriteFile(saved, nil, 0644)
+	exec.WriteFile(path, []byte("exit 101\n"), 0755)
+	for i := 0; i < 2; i++ {
+		if err := Restore(path, saved).Undo(ctx, exec); err != nil {
+			t.Fatalf("Undo %d: %v", i+1, err)
+		}
+	}
+	if !exec.Exists(path) || exec.Exists(saved) {
+		t.Error("backup not restored")
+	}
+	if files, _ := os.ReadDir(dir); len(files) > 0 {
+		t.Errorf("Undo through DryRun changed the file system: %v", files)
+	}
+}
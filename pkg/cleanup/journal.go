@@ -0,0 +1,216 @@
+package cleanup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/rivsidn/kdev_bootstrap/pkg/executor"
+	"github.com/rivsidn/kdev_bootstrap/pkg/utils"
+)
+
+// JournalDir 撤销操作记录目录，每个进程一个文件
+//
+// 不使用 /run：bootfs 中的 policy-rc.d、resolv.conf 备份等重启后仍需恢复。
+// 测试中可以替换为临时目录。
+var JournalDir = "/var/lib/kboot/cleanup"
+
+// Journal 进程登记的撤销操作记录
+type Journal struct {
+	PID     int       `json:"pid"`
+	BootID  string    `json:"boot_id"`
+	Command string    `json:"command"`
+	Start   time.Time `json:"start"`
+	Actions []Action  `json:"actions"` // 按登记顺序排列，撤销时逆序
+
+	path string
+}
+
+// journal 当前进程的记录，写入失败（如非 root）时不再写入
+var journal struct {
+	start    time.Time
+	disabled bool
+}
+
+// saveJournal 将当前登记的操作写入记录文件，没有操作时删除，调用者需持有 registry 锁
+//
+// DryRun、Replay 登记的操作没有修改主机，不写入记录。
+func saveJournal() {
+	if journal.disabled {
+		return
+	}
+	if journal.start.IsZero() {
+		journal.start = time.Now().UTC()
+	}
+
+	j := Journal{
+		PID:     os.Getpid(),
+		BootID:  bootID(),
+		Command: strings.Join(os.Args, " "),
+		Start:   journal.start,
+	}
+	for _, e := range registry.entries {
+		if !executor.Simulated(e.exec) {
+			j.Actions = append(j.Actions, e.action)
+		}
+	}
+
+	path := filepath.Join(JournalDir, strconv.Itoa(os.Getpid())+".json")
+	if len(j.Actions) == 0 {
+		os.Remove(path)
+		return
+	}
+
+	data, err := json.MarshalIndent(&j, "", "  ")
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(JournalDir, 0755); err != nil {
+		journal.disabled = true
+		return
+	}
+	// 先写临时文件再重命名，异常退出时不会留下不完整的记录
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0644); err != nil {
+		journal.disabled = true
+		return
+	}
+	os.Rename(tmp, path)
+}
+
+// Leftovers 返回已退出进程留下的记录（异常退出或清理失败），按开始时间排序
+func Leftovers() ([]*Journal, error) {
+	journals, err := readJournals()
+	if err != nil {
+		return nil, err
+	}
+
+	var leftovers []*Journal
+	for _, j := range journals {
+		if !j.running() {
+			leftovers = append(leftovers, j)
+		}
+	}
+	return leftovers, nil
+}
+
+// Untracked 返回没有记录的 kboot 临时挂载点，如旧版本 kboot_build_qemu 留下的 /tmp/qemu-mount-PID
+//
+// 每个挂载点对应卸载及删除两个操作，按撤销顺序排列。
+func Untracked() ([]Action, error) {
+	journals, err := readJournals()
+	if err != nil {
+		return nil, err
+	}
+	tracked := make(map[string]bool)
+	for _, j := range journals {
+		for _, a := range j.Actions {
+			tracked[a.Path] = true
+		}
+	}
+
+	var actions []Action
+	tmp := absPath(os.TempDir())
+	for _, mountPoint := range utils.MountPoints() {
+		if filepath.Dir(mountPoint) != tmp || tracked[mountPoint] {
+			continue
+		}
+		name := filepath.Base(mountPoint)
+		if pid, ok := strings.CutPrefix(name, "qemu-mount-"); ok {
+			// 构建仍在进行
+			if n, err := strconv.Atoi(pid); err == nil && syscall.Kill(n, 0) == nil {
+				continue
+			}
+		} else if !strings.HasPrefix(name, "kboot-") {
+			continue
+		}
+		actions = append(actions, Unmount(mountPoint), Remove(mountPoint))
+	}
+	return actions, nil
+}
+
+// readJournals 读取所有记录，按开始时间排序
+func readJournals() ([]*Journal, error) {
+	files, err := filepath.Glob(filepath.Join(JournalDir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var journals []*Journal
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if os.IsNotExist(err) {
+			continue // 进程已完成清理
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read cleanup journal: %v", err)
+		}
+		j := &Journal{path: file}
+		if err := json.Unmarshal(data, j); err != nil {
+			return nil, fmt.Errorf("failed to parse cleanup journal %s: %v", file, err)
+		}
+		journals = append(journals, j)
+	}
+
+	sort.Slice(journals, func(i, k int) bool { return journals[i].Start.Before(journals[k].Start) })
+	return journals, nil
+}
+
+// running 判断记录对应的进程是否仍在运行，重启后 PID 可能被其他进程使用
+func (j *Journal) running() bool {
+	if j.PID == os.Getpid() {
+		return true
+	}
+	if j.BootID != bootID() {
+		return false
+	}
+	err := syscall.Kill(j.PID, 0)
+	return err == nil || err == syscall.EPERM
+}
+
+// Cleanup 逆序执行记录中的撤销操作，全部成功后删除记录，onAction 在每个操作执行后调用
+func (j *Journal) Cleanup(ctx context.Context, onAction func(Action, error)) error {
+	var remaining []Action
+	for i := len(j.Actions) - 1; i >= 0; i-- {
+		err := j.Actions[i].Undo(ctx, executor.Default)
+		if onAction != nil {
+			onAction(j.Actions[i], err)
+		}
+		if err != nil {
+			remaining = append([]Action{j.Actions[i]}, remaining...)
+		}
+	}
+
+	j.Actions = remaining
+	if len(remaining) == 0 {
+		if err := os.Remove(j.path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove cleanup journal: %v", err)
+		}
+		return nil
+	}
+
+	data, err := json.MarshalIndent(j, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(j.path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to update cleanup journal: %v", err)
+	}
+	return fmt.Errorf("%d cleanup action(s) of PID %d failed", len(remaining), j.PID)
+}
+
+// bootID 返回本次启动的标识
+func bootID() string {
+	data, err := os.ReadFile("/proc/sys/kernel/random/boot_id")
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
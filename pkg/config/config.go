@@ -1,42 +1,71 @@
 package config
 
 import (
+	"bytes"
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 
 	"gopkg.in/ini.v1"
 )
 
 // Config 配置文件结构
 type Config struct {
-	Distribution  string            `ini:"distribution"`
-	Version       string            `ini:"version"`
-	ArchSupported []string          `ini:"-"`
-	ArchCurrent   string            `ini:"arch_current"`
-	Mirror        string            `ini:"mirror"`
-	SetupScript   string            `ini:"setup_script"`
-	Packages      map[string]string `ini:"-"`
+	Distribution     string                   `ini:"distribution"`
+	Version          string                   `ini:"version"`
+	ArchSupported    []string                 `ini:"-"`
+	ArchCurrent      string                   `ini:"arch_current"`
+	Mirror           string                   `ini:"mirror"`
+	SetupScript      string                   `ini:"setup_script"`
+	SecurityProfile  string                   `ini:"security_profile"` // secure、insecure-lab
+	ProvisionScripts []string                 `ini:"-"`
+	OverlayDirs      []string                 `ini:"-"`
+	OverlayOwners    []string                 `ini:"-"`
+	OverlayModes     []string                 `ini:"-"`
+	Slim             []string                 `ini:"-"` // 精简规则，见 SlimRules
+	Packages         map[string]string        `ini:"-"`
+	Values           map[string]string        `ini:"-"` // 配置段中的所有原始键值
+	Guest            *GuestConfig             `ini:"-"` // 客户机配置段
+	Timeouts         map[string]time.Duration `ini:"-"` // [timeouts] 配置段，阶段 -> 超时
 
 	// 内部字段
-	sectionName      string
-	ArchSupportedRaw string `ini:"arch_supported"`
-	ConfigPath       string // 配置文件的完整路径
+	sectionName         string
+	packageKeys         []string                 // _packages 配置项在文件中的顺序
+	timeoutFlags        map[string]time.Duration // 命令行指定的超时
+	ArchSupportedRaw    string                   `ini:"arch_supported"`
+	ProvisionScriptsRaw string                   `ini:"provision_scripts"`
+	OverlayDirsRaw      string                   `ini:"overlay_dirs"`
+	OverlayOwnersRaw    string                   `ini:"overlay_owners"`
+	OverlayModesRaw     string                   `ini:"overlay_modes"`
+	SlimRaw             string                   `ini:"slim"`
+	ConfigPath          string                   // 配置文件的完整路径
 }
 
 // LoadConfig 加载配置文件
 func LoadConfig(configPath string) (*Config, error) {
-	cfg, err := ini.Load(configPath)
+	return loadConfig(configPath, configPath)
+}
+
+// LoadConfigData 从内存数据加载配置文件，configPath 仅用于记录来源
+func LoadConfigData(data []byte, configPath string) (*Config, error) {
+	return loadConfig(data, configPath)
+}
+
+// loadConfig 从文件路径或内存数据加载配置
+func loadConfig(source interface{}, configPath string) (*Config, error) {
+	cfg, err := ini.Load(source)
 	if err != nil {
 		return nil, fmt.Errorf("unable to load configuration file %s: %v", configPath, err)
 	}
 
-	// 获取第一个非默认section
+	// 获取第一个非默认、非客户机配置的section
 	var section *ini.Section
 	var sectionName string
 	for _, s := range cfg.Sections() {
-		if s.Name() != ini.DefaultSection {
+		if s.Name() != ini.DefaultSection && !guestSections[s.Name()] {
 			section = s
 			sectionName = s.Name()
 			break
@@ -50,6 +79,7 @@ func LoadConfig(configPath string) (*Config, error) {
 	config := &Config{
 		sectionName: sectionName,
 		Packages:    make(map[string]string),
+		Values:      make(map[string]string),
 		ConfigPath:  configPath,
 	}
 
@@ -66,13 +96,49 @@ func LoadConfig(configPath string) (*Config, error) {
 		}
 	}
 
+	// 解析 provision_scripts
+	config.ProvisionScripts = splitList(config.ProvisionScriptsRaw)
+
+	// 解析 overlay 相关配置
+	config.OverlayDirs = splitList(config.OverlayDirsRaw)
+	config.OverlayOwners = splitList(config.OverlayOwnersRaw)
+	config.OverlayModes = splitList(config.OverlayModesRaw)
+
+	// 解析精简规则
+	config.Slim = splitList(config.SlimRaw)
+	for _, rule := range config.Slim {
+		if !isSlimRule(rule) {
+			return nil, fmt.Errorf("unsupported slim rule: %s (supported: %s)", rule, strings.Join(SlimRules, ", "))
+		}
+	}
+
 	// 解析所有 _packages 结尾的配置
 	for _, key := range section.Keys() {
+		config.Values[key.Name()] = key.Value()
 		if strings.HasSuffix(key.Name(), "_packages") {
-			config.Packages[key.Name()] = key.Value()
+			config.SetPackages(key.Name(), key.Value())
 		}
 	}
 
+	// 解析客户机配置段
+	if config.Guest, err = loadGuestConfig(cfg); err != nil {
+		return nil, err
+	}
+
+	// 解析各阶段超时
+	if err := config.loadTimeouts(cfg); err != nil {
+		return nil, err
+	}
+
+	// 校验安全配置
+	switch config.SecurityProfile {
+	case "":
+		config.SecurityProfile = ProfileSecure
+	case ProfileSecure, ProfileInsecureLab:
+	default:
+		return nil, fmt.Errorf("unsupported security_profile: %s (supported: %s, %s)", config.SecurityProfile, ProfileSecure, ProfileInsecureLab)
+	}
+
 	// 设置默认镜像
 	if config.Mirror == "" {
 		if strings.HasPrefix(config.Version, "5.") {
@@ -85,6 +151,19 @@ func LoadConfig(configPath string) (*Config, error) {
 	return config, nil
 }
 
+// SlimRules 支持的精简规则
+var SlimRules = []string{"docs", "man", "locales", "apt-lists", "cache"}
+
+// isSlimRule 判断是否为支持的精简规则
+func isSlimRule(rule string) bool {
+	for _, r := range SlimRules {
+		if r == rule {
+			return true
+		}
+	}
+	return false
+}
+
 // SaveToBootfs 将配置保存到 bootfs 的 /etc/bootstrap.conf
 func (c *Config) SaveToBootfs(bootfsPath string) error {
 	configPath := filepath.Join(bootfsPath, "etc", "bootstrap.conf")
@@ -94,11 +173,56 @@ func (c *Config) SaveToBootfs(bootfsPath string) error {
 		return fmt.Errorf("failed to create directory: %v", err)
 	}
 
-	// 创建新的 INI 文件
+	cfg, err := c.toINI()
+	if err != nil {
+		return err
+	}
+
+	// 保存文件
+	if err := cfg.SaveTo(configPath); err != nil {
+		return fmt.Errorf("failed to save configuration file: %v", err)
+	}
+
+	return nil
+}
+
+// INI 返回保存到 bootfs 的 /etc/bootstrap.conf 的内容
+func (c *Config) INI() ([]byte, error) {
+	cfg, err := c.toINI()
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if _, err := cfg.WriteTo(&buf); err != nil {
+		return nil, fmt.Errorf("failed to encode configuration: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// Sections 返回保存到 bootstrap.conf 的所有配置段及键值
+func (c *Config) Sections() (map[string]map[string]string, error) {
+	cfg, err := c.toINI()
+	if err != nil {
+		return nil, err
+	}
+
+	sections := make(map[string]map[string]string)
+	for _, section := range cfg.Sections() {
+		if section.Name() == ini.DefaultSection {
+			continue
+		}
+		sections[section.Name()] = section.KeysHash()
+	}
+	return sections, nil
+}
+
+// toINI 生成 bootstrap.conf 对应的 INI 文件
+func (c *Config) toINI() (*ini.File, error) {
 	cfg := ini.Empty()
 	section, err := cfg.NewSection(c.sectionName)
 	if err != nil {
-		return fmt.Errorf("failed to create section: %v", err)
+		return nil, fmt.Errorf("failed to create section: %v", err)
 	}
 
 	// 写入基本字段
@@ -111,25 +235,142 @@ func (c *Config) SaveToBootfs(bootfsPath string) error {
 	if c.Mirror != "" {
 		section.NewKey("mirror", c.Mirror)
 	}
+	if c.SetupScript != "" {
+		section.NewKey("setup_script", c.SetupScript)
+	}
+	if len(c.ProvisionScripts) > 0 {
+		section.NewKey("provision_scripts", strings.Join(c.ProvisionScripts, ","))
+	}
+	if len(c.OverlayDirs) > 0 {
+		section.NewKey("overlay_dirs", strings.Join(c.OverlayDirs, ","))
+	}
+	if len(c.OverlayOwners) > 0 {
+		section.NewKey("overlay_owners", strings.Join(c.OverlayOwners, ","))
+	}
+	if len(c.OverlayModes) > 0 {
+		section.NewKey("overlay_modes", strings.Join(c.OverlayModes, ","))
+	}
+	if len(c.Slim) > 0 {
+		section.NewKey("slim", strings.Join(c.Slim, ","))
+	}
+	section.NewKey("security_profile", c.SecurityProfile)
 
-	// 写入 packages
-	for key, value := range c.Packages {
-		section.NewKey(key, value)
+	// 按配置文件中的顺序写入 packages
+	for _, key := range c.PackageKeys() {
+		section.NewKey(key, c.Packages[key])
 	}
 
-	// 保存文件
-	if err := cfg.SaveTo(configPath); err != nil {
-		return fmt.Errorf("failed to save configuration file: %v", err)
+	// 写入客户机配置段
+	if c.Guest != nil {
+		if err := c.Guest.saveGuestConfig(cfg); err != nil {
+			return nil, fmt.Errorf("failed to save guest configuration: %v", err)
+		}
+	}
+	if err := c.saveTimeouts(cfg); err != nil {
+		return nil, fmt.Errorf("failed to save timeouts: %v", err)
 	}
 
-	return nil
+	return cfg, nil
+}
+
+// SetPackages 设置 _packages 配置项，新增的配置项排在最后
+func (c *Config) SetPackages(key, value string) {
+	if _, ok := c.Packages[key]; !ok {
+		c.packageKeys = append(c.packageKeys, key)
+	}
+	c.Packages[key] = value
+}
+
+// HasPackage 判断软件包是否在任一 _packages 配置项中
+func (c *Config) HasPackage(name string) bool {
+	for _, pkg := range c.GetAllPackages() {
+		if pkg == name {
+			return true
+		}
+	}
+	return false
+}
+
+// AddPackage 将软件包追加到 _packages 配置项
+func (c *Config) AddPackage(key, name string) {
+	if value := c.Packages[key]; value != "" {
+		c.SetPackages(key, value+","+name)
+	} else {
+		c.SetPackages(key, name)
+	}
+}
+
+// RemovePackage 从所有 _packages 配置项中删除软件包，配置项为空时一并删除
+func (c *Config) RemovePackage(name string) bool {
+	removed := false
+	for _, key := range c.PackageKeys() {
+		var kept []string
+		for _, pkg := range strings.Split(c.Packages[key], ",") {
+			pkg = strings.TrimSpace(pkg)
+			if pkg == name {
+				removed = true
+			} else if pkg != "" {
+				kept = append(kept, pkg)
+			}
+		}
+		if len(kept) == 0 {
+			delete(c.Packages, key)
+		} else {
+			c.Packages[key] = strings.Join(kept, ",")
+		}
+	}
+	return removed
+}
+
+// PackageKeys 按配置文件中的顺序返回 _packages 配置项
+//
+// 直接写入 Packages 的配置项按名称排序后排在最后。
+func (c *Config) PackageKeys() []string {
+	var keys []string
+	seen := make(map[string]bool)
+	for _, key := range c.packageKeys {
+		if _, ok := c.Packages[key]; ok && !seen[key] {
+			keys = append(keys, key)
+			seen[key] = true
+		}
+	}
+
+	var extra []string
+	for key := range c.Packages {
+		if !seen[key] {
+			extra = append(extra, key)
+		}
+	}
+	sort.Strings(extra)
+
+	return append(keys, extra...)
+}
+
+// splitList 解析逗号分隔的列表，忽略空项
+func splitList(raw string) []string {
+	var items []string
+	for _, item := range strings.Split(raw, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// ResolvePath 将相对路径解析为相对配置文件所在目录的路径
+func (c *Config) ResolvePath(path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(filepath.Dir(c.ConfigPath), path)
 }
 
 // GetAllPackages 获取所有要安装的包
 func (c *Config) GetAllPackages() []string {
 	var packages []string
-	for _, pkgList := range c.Packages {
-		for _, pkg := range strings.Split(pkgList, ",") {
+	for _, key := range c.PackageKeys() {
+		for _, pkg := range strings.Split(c.Packages[key], ",") {
 			pkg = strings.TrimSpace(pkg)
 			if pkg != "" {
 				packages = append(packages, pkg)
@@ -158,4 +399,3 @@ func (c *Config) ValidateArch(arch string) bool {
 	}
 	return false
 }
-
@@ -0,0 +1,247 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/ini.v1"
+)
+
+// 客户机配置段名称
+const (
+	NetworkSection  = "network"
+	UsersSection    = "users"
+	SSHSection      = "ssh"
+	HostnameSection = "hostname"
+)
+
+// guestSections 客户机配置段，不作为发行版配置段
+var guestSections = map[string]bool{
+	NetworkSection:  true,
+	UsersSection:    true,
+	SSHSection:      true,
+	HostnameSection: true,
+	TimeoutsSection: true,
+}
+
+// NetworkConfig [network] 配置段
+type NetworkConfig struct {
+	Renderer    string   `ini:"renderer"`  // auto、netplan、ifupdown、networkd
+	Interface   string   `ini:"interface"` // 默认 eth0
+	Method      string   `ini:"method"`    // dhcp、static
+	Address     string   `ini:"address"`   // static 时使用，CIDR 格式
+	Gateway     string   `ini:"gateway"`
+	Nameservers []string `ini:"-"`
+
+	NameserversRaw string `ini:"nameservers"`
+}
+
+// root 密码来源
+const (
+	RootPasswordRandom = "random" // 随机生成，明文保存在宿主机 ${bootfs}.credentials
+	RootPasswordLocked = "locked" // 锁定密码，只能通过 authorized_keys 登录
+)
+
+// 安全配置
+const (
+	ProfileSecure      = "secure"
+	ProfileInsecureLab = "insecure-lab" // root 空密码、sshd 允许空密码登录，仅用于隔离的实验环境
+)
+
+// UsersConfig [users] 配置段
+type UsersConfig struct {
+	Accounts       []string          `ini:"-"` // 需要创建的普通用户
+	PasswordHashes map[string]string `ini:"-"` // 用户名 -> crypt(3) 格式密码哈希
+	RootPassword   string            `ini:"root_password"`
+	AuthorizedKeys []string          `ini:"-"` // auto、none 或公钥文件列表
+
+	AccountsRaw       string `ini:"accounts"`
+	AuthorizedKeysRaw string `ini:"authorized_keys"`
+}
+
+// SSHConfig [ssh] 配置段
+type SSHConfig struct {
+	Port                   string `ini:"port"`
+	PermitRootLogin        string `ini:"permit_root_login"`
+	PasswordAuthentication string `ini:"password_authentication"`
+	PermitEmptyPasswords   string `ini:"permit_empty_passwords"`
+	UsePAM                 string `ini:"use_pam"`
+}
+
+// HostnameConfig [hostname] 配置段
+type HostnameConfig struct {
+	Name string `ini:"name"`
+}
+
+// GuestConfig 客户机配置，未配置的段为 nil（[users] 总是存在）
+type GuestConfig struct {
+	Network  *NetworkConfig
+	Users    *UsersConfig
+	SSH      *SSHConfig
+	Hostname *HostnameConfig
+}
+
+// 默认值
+const (
+	defaultInterface = "eth0"
+	defaultMethod    = "dhcp"
+)
+
+// defaultNameservers QEMU 用户网络 DNS 及公共 DNS
+var defaultNameservers = []string{"10.0.2.3", "114.114.114.114", "8.8.8.8"}
+
+// loadGuestConfig 解析客户机配置段
+func loadGuestConfig(cfg *ini.File) (*GuestConfig, error) {
+	guest := &GuestConfig{}
+
+	if section, err := cfg.GetSection(NetworkSection); err == nil {
+		network := &NetworkConfig{}
+		if err := section.MapTo(network); err != nil {
+			return nil, fmt.Errorf("failed to parse [%s]: %v", NetworkSection, err)
+		}
+		network.Nameservers = splitList(network.NameserversRaw)
+		if err := network.setDefaults(); err != nil {
+			return nil, err
+		}
+		guest.Network = network
+	}
+
+	users := &UsersConfig{PasswordHashes: make(map[string]string)}
+	if section, err := cfg.GetSection(UsersSection); err == nil {
+		if err := section.MapTo(users); err != nil {
+			return nil, fmt.Errorf("failed to parse [%s]: %v", UsersSection, err)
+		}
+		for _, key := range section.Keys() {
+			if name, ok := strings.CutSuffix(key.Name(), "_password_hash"); ok {
+				users.PasswordHashes[name] = key.Value()
+			}
+		}
+	}
+	users.Accounts = splitList(users.AccountsRaw)
+	users.AuthorizedKeys = splitList(users.AuthorizedKeysRaw)
+	if err := users.setDefaults(); err != nil {
+		return nil, err
+	}
+	guest.Users = users
+
+	if section, err := cfg.GetSection(SSHSection); err == nil {
+		ssh := &SSHConfig{}
+		if err := section.MapTo(ssh); err != nil {
+			return nil, fmt.Errorf("failed to parse [%s]: %v", SSHSection, err)
+		}
+		guest.SSH = ssh
+	}
+
+	if section, err := cfg.GetSection(HostnameSection); err == nil {
+		hostname := &HostnameConfig{}
+		if err := section.MapTo(hostname); err != nil {
+			return nil, fmt.Errorf("failed to parse [%s]: %v", HostnameSection, err)
+		}
+		if hostname.Name == "" {
+			return nil, fmt.Errorf("[%s] requires name", HostnameSection)
+		}
+		guest.Hostname = hostname
+	}
+
+	return guest, nil
+}
+
+// setDefaults 设置网络默认值并校验
+func (n *NetworkConfig) setDefaults() error {
+	if n.Renderer == "" {
+		n.Renderer = "auto"
+	}
+	if n.Interface == "" {
+		n.Interface = defaultInterface
+	}
+	if n.Method == "" {
+		n.Method = defaultMethod
+	}
+	if len(n.Nameservers) == 0 {
+		n.Nameservers = defaultNameservers
+	}
+
+	switch n.Renderer {
+	case "auto", "netplan", "ifupdown", "networkd":
+	default:
+		return fmt.Errorf("[%s] unsupported renderer: %s (supported: auto, netplan, ifupdown, networkd)", NetworkSection, n.Renderer)
+	}
+
+	switch n.Method {
+	case "dhcp":
+	case "static":
+		if !strings.Contains(n.Address, "/") {
+			return fmt.Errorf("[%s] static method requires address in CIDR format, e.g. 10.0.2.15/24", NetworkSection)
+		}
+	default:
+		return fmt.Errorf("[%s] unsupported method: %s (supported: dhcp, static)", NetworkSection, n.Method)
+	}
+
+	return nil
+}
+
+// setDefaults 设置用户默认值并校验
+func (u *UsersConfig) setDefaults() error {
+	if u.RootPassword == "" {
+		u.RootPassword = RootPasswordRandom
+	}
+	if len(u.AuthorizedKeys) == 0 {
+		u.AuthorizedKeys = []string{"auto"}
+	}
+
+	switch u.RootPassword {
+	case RootPasswordRandom, RootPasswordLocked:
+	default:
+		return fmt.Errorf("[%s] unsupported root_password: %s (supported: %s, %s; use root_password_hash for a fixed password)",
+			UsersSection, u.RootPassword, RootPasswordRandom, RootPasswordLocked)
+	}
+	return nil
+}
+
+// saveGuestConfig 将客户机配置写入 INI 文件
+//
+// [users] 中的密码哈希不写入，避免出现在所有人可读的 bootstrap.conf 中。
+func (g *GuestConfig) saveGuestConfig(cfg *ini.File) error {
+	if g.Network != nil {
+		section, err := cfg.NewSection(NetworkSection)
+		if err != nil {
+			return err
+		}
+		g.Network.NameserversRaw = strings.Join(g.Network.Nameservers, ",")
+		if err := section.ReflectFrom(g.Network); err != nil {
+			return err
+		}
+	}
+
+	if g.Users != nil {
+		section, err := cfg.NewSection(UsersSection)
+		if err != nil {
+			return err
+		}
+		if len(g.Users.Accounts) > 0 {
+			section.NewKey("accounts", strings.Join(g.Users.Accounts, ","))
+		}
+		section.NewKey("root_password", g.Users.RootPassword)
+		section.NewKey("authorized_keys", strings.Join(g.Users.AuthorizedKeys, ","))
+	}
+
+	if g.SSH != nil {
+		section, err := cfg.NewSection(SSHSection)
+		if err != nil {
+			return err
+		}
+		if err := section.ReflectFrom(g.SSH); err != nil {
+			return err
+		}
+	}
+
+	if g.Hostname != nil {
+		section, err := cfg.NewSection(HostnameSection)
+		if err != nil {
+			return err
+		}
+		section.NewKey("name", g.Hostname.Name)
+	}
+
+	return nil
+}
@@ -1,5 +1,10 @@
 package config
 
+import (
+	"strconv"
+	"strings"
+)
+
 var UbuntuSuiteMap = map[string]string{
 	"5.10":  "breezy",
 	"10.10": "maverick",
@@ -18,3 +23,28 @@ func (c *Config) GetSuite() string {
 	}
 }
 
+// VersionAtLeast 判断发行版版本是否不低于指定版本，例如 VersionAtLeast("17.10")
+func (c *Config) VersionAtLeast(version string) bool {
+	return compareVersion(c.Version, version) >= 0
+}
+
+// compareVersion 按数字逐段比较形如 18.04 的版本号
+func compareVersion(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y int
+		if i < len(as) {
+			x, _ = strconv.Atoi(as[i])
+		}
+		if i < len(bs) {
+			y, _ = strconv.Atoi(bs[i])
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
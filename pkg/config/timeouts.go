@@ -0,0 +1,126 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/ini.v1"
+)
+
+// TimeoutsSection 各阶段超时配置段，不作为发行版配置段
+const TimeoutsSection = "timeouts"
+
+// 可以设置超时的构建阶段
+const (
+	StageDebootstrap = "debootstrap"  // kboot_build_bootfs: debootstrap 下载及安装
+	StageProvision   = "provision"    // kboot_build_bootfs: chroot 中执行 provision 脚本
+	StageDockerBuild = "docker_build" // kboot_build_docker: docker build
+	StageQemuImage   = "qemu_image"   // kboot_build_qemu: 创建、格式化及挂载镜像
+	StageQemuCopy    = "qemu_copy"    // kboot_build_qemu: 复制根文件系统
+)
+
+// DefaultTimeouts 各阶段的默认超时，足够慢速镜像源完成，只用于发现挂起的构建
+var DefaultTimeouts = map[string]time.Duration{
+	StageDebootstrap: 2 * time.Hour,
+	StageProvision:   2 * time.Hour,
+	StageDockerBuild: time.Hour,
+	StageQemuImage:   10 * time.Minute,
+	StageQemuCopy:    time.Hour,
+}
+
+// Timeout 返回阶段的超时，依次使用命令行、配置文件及默认值，0 表示不限制
+func (c *Config) Timeout(stage string) time.Duration {
+	if d, ok := c.timeoutFlags[stage]; ok {
+		return d
+	}
+	if d, ok := c.Timeouts[stage]; ok {
+		return d
+	}
+	return DefaultTimeouts[stage]
+}
+
+// SetTimeout 设置阶段的超时并保存到 bootstrap.conf，value 为 time.ParseDuration 格式（如 30m），0 表示不限制
+func (c *Config) SetTimeout(stage, value string) error {
+	d, err := parseTimeout(stage, value)
+	if err != nil {
+		return err
+	}
+	if c.Timeouts == nil {
+		c.Timeouts = make(map[string]time.Duration)
+	}
+	c.Timeouts[stage] = d
+	return nil
+}
+
+// SetTimeoutFlag 解析命令行的 STAGE=DURATION，只对本次构建生效，不保存
+func (c *Config) SetTimeoutFlag(flag string) error {
+	stage, value, ok := strings.Cut(flag, "=")
+	if !ok {
+		return fmt.Errorf("invalid --timeout %s, expected STAGE=DURATION", flag)
+	}
+	stage = strings.TrimSpace(stage)
+	d, err := parseTimeout(stage, strings.TrimSpace(value))
+	if err != nil {
+		return err
+	}
+	if c.timeoutFlags == nil {
+		c.timeoutFlags = make(map[string]time.Duration)
+	}
+	c.timeoutFlags[stage] = d
+	return nil
+}
+
+// parseTimeout 校验阶段名称并解析超时
+func parseTimeout(stage, value string) (time.Duration, error) {
+	if _, ok := DefaultTimeouts[stage]; !ok {
+		return 0, fmt.Errorf("unknown timeout stage: %s (supported: %s)", stage, strings.Join(TimeoutStages(), ", "))
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid timeout for %s: %s (e.g. 90s, 30m, 2h, 0 for none)", stage, value)
+	}
+	return d, nil
+}
+
+// TimeoutStages 返回所有阶段名称
+func TimeoutStages() []string {
+	var stages []string
+	for stage := range DefaultTimeouts {
+		stages = append(stages, stage)
+	}
+	sort.Strings(stages)
+	return stages
+}
+
+// loadTimeouts 解析 [timeouts] 配置段
+func (c *Config) loadTimeouts(cfg *ini.File) error {
+	section, err := cfg.GetSection(TimeoutsSection)
+	if err != nil {
+		return nil
+	}
+	for _, key := range section.Keys() {
+		if err := c.SetTimeout(key.Name(), key.Value()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// saveTimeouts 写入配置的超时，之后基于 bootfs 构建镜像时同样生效
+func (c *Config) saveTimeouts(cfg *ini.File) error {
+	if len(c.Timeouts) == 0 {
+		return nil
+	}
+	section, err := cfg.NewSection(TimeoutsSection)
+	if err != nil {
+		return err
+	}
+	for _, stage := range TimeoutStages() {
+		if d, ok := c.Timeouts[stage]; ok {
+			section.NewKey(stage, d.String())
+		}
+	}
+	return nil
+}
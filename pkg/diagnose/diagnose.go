@@ -0,0 +1,89 @@
+package diagnose
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Error 识别出原因的失败，Err 为原始错误
+type Error struct {
+	Code    string // 错误代码，见 Rules
+	Summary string // 失败原因
+	Hint    string // 建议的解决方法
+	Line    string // 匹配的输出行
+	Err     error
+}
+
+// Error 返回原始错误，匹配的输出行不在其中时附加在后面
+//
+// 外层使用 %v 包装后仍可以通过 Find 从错误信息中重新识别。
+func (e *Error) Error() string {
+	msg := e.Err.Error()
+	if e.Line != "" && !strings.Contains(msg, e.Line) {
+		msg += ": " + e.Line
+	}
+	return msg
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Explain 返回失败原因及解决方法，用于在错误信息之后输出
+func (e *Error) Explain() string {
+	return fmt.Sprintf("Cause [%s]: %s\nFix: %s", e.Code, e.Summary, e.Hint)
+}
+
+// Classify 根据命令输出及错误信息识别失败原因，返回 *Error；无法识别或 err 为 nil 时原样返回 err
+func Classify(output string, err error) error {
+	if err == nil {
+		return nil
+	}
+	var known *Error
+	if errors.As(err, &known) {
+		return err
+	}
+
+	text := output + "\n" + err.Error()
+	for _, rule := range Rules {
+		for _, line := range strings.Split(text, "\n") {
+			line = strings.TrimSpace(line)
+			match := rule.Pattern.FindStringSubmatchIndex(line)
+			if match == nil {
+				continue
+			}
+			return &Error{
+				Code:    rule.Code,
+				Summary: string(rule.Pattern.ExpandString(nil, rule.Summary, line, match)),
+				Hint:    string(rule.Pattern.ExpandString(nil, rule.Hint, line, match)),
+				Line:    line,
+				Err:     err,
+			}
+		}
+	}
+	return err
+}
+
+// Find 返回 err 中识别出的失败原因，err 被 %v 包装时从错误信息中重新识别，无法识别时返回 nil
+func Find(err error) *Error {
+	if err == nil {
+		return nil
+	}
+	var known *Error
+	if errors.As(err, &known) {
+		return known
+	}
+	if errors.As(Classify("", err), &known) {
+		return known
+	}
+	return nil
+}
+
+// Code 返回 err 的错误代码，无法识别时返回空字符串
+func Code(err error) string {
+	if known := Find(err); known != nil {
+		return known.Code
+	}
+	return ""
+}
@@ -0,0 +1,100 @@
+package diagnose
+
+import "regexp"
+
+// 错误代码，保持稳定，脚本可以据此判断失败原因
+const (
+	CodePreflight        = "preflight_failed"        // 主机不满足构建条件
+	CodeStageTimeout     = "stage_timeout"           // 阶段超时
+	CodeReleaseKey       = "release_key_unknown"     // Release 文件的签名密钥不在 debootstrap 的密钥环中
+	CodeSuiteNotFound    = "suite_not_found"         // 镜像源中没有该版本
+	CodeSuiteUnknown     = "suite_unknown"           // debootstrap 没有该版本的脚本
+	CodePackagesNotFound = "packages_not_found"      // 镜像源中找不到软件包
+	CodeNoLoopDevice     = "loop_device_unavailable" // 没有可用的 loop 设备
+	CodeMkfsNotFound     = "mkfs_not_found"          // 没有安装 mkfs
+	CodeCommandNotFound  = "command_not_found"       // 没有安装外部命令
+	CodeDockerDaemon     = "docker_daemon_down"      // docker 守护进程没有运行
+	CodeDiskFull         = "disk_full"               // 磁盘空间不足
+)
+
+// Rule 已知失败的识别规则
+type Rule struct {
+	Code    string         // 错误代码
+	Pattern *regexp.Regexp // 匹配命令输出或错误信息中的一行
+	Summary string         // 失败原因，可以用 ${1} 引用 Pattern 的分组
+	Hint    string         // 建议的解决方法，可以用 ${1} 引用 Pattern 的分组
+}
+
+// Rules 按顺序匹配，第一条匹配的规则生效，更具体的规则放在前面
+//
+// 添加规则时只需在这里增加一项，Pattern 应足够具体，避免误判其他失败。
+var Rules = []Rule{
+	{
+		Code:    CodePreflight,
+		Pattern: regexp.MustCompile(`preflight checks failed \(run`),
+		Summary: "the host does not meet the build requirements",
+		Hint:    "fix the failed checks listed above (kboot doctor shows all checks), or use --skip-preflight",
+	},
+	{
+		Code:    CodeStageTimeout,
+		Pattern: regexp.MustCompile(`(\w+) timed out after (\S+) \(raise it`),
+		Summary: "stage ${1} did not finish within ${2}",
+		Hint:    "check the build log for the command that hung; raise the limit with [timeouts] ${1} = DURATION or --timeout ${1}=DURATION",
+	},
+	{
+		Code:    CodeReleaseKey,
+		Pattern: regexp.MustCompile(`Release signed by unknown key \(key id ([0-9A-Fa-f]+)\)|NO_PUBKEY ([0-9A-Fa-f]+)`),
+		Summary: "the Release file is signed by key ${1}${2}, which is not in the debootstrap keyring",
+		Hint: "import the key and install it as the keyring named in the output, e.g. " +
+			"gpg --keyserver keyserver.ubuntu.com --recv-keys ${1}${2} && " +
+			"gpg --export ${1}${2} | sudo tee /usr/share/keyrings/ubuntu-archive-removed-keys.gpg >/dev/null (see FAQ.md)",
+	},
+	{
+		Code:    CodeSuiteNotFound,
+		Pattern: regexp.MustCompile(`Failed getting release file (\S+)`),
+		Summary: "the suite is not available on the mirror (${1})",
+		Hint:    "check version and mirror in the configuration file; releases past end of life are only on http://old-releases.ubuntu.com/ubuntu/",
+	},
+	{
+		Code:    CodeSuiteUnknown,
+		Pattern: regexp.MustCompile(`No such script: (\S+)`),
+		Summary: "debootstrap does not know this suite (${1})",
+		Hint:    "upgrade debootstrap, or link the script of the closest release, e.g. ln -s gutsy ${1}",
+	},
+	{
+		Code:    CodePackagesNotFound,
+		Pattern: regexp.MustCompile(`Couldn't find these debs: (.+)|Unable to locate package (\S+)`),
+		Summary: "packages not found on the mirror: ${1}${2}",
+		Hint:    "check the package names in the *_packages keys of the configuration file; names differ between releases (e.g. iproute and iproute2)",
+	},
+	{
+		Code:    CodeNoLoopDevice,
+		Pattern: regexp.MustCompile(`cannot find an unused loop device|could not find any free loop device|failed to set ?up loop device`),
+		Summary: "no loop device is available",
+		Hint:    "load the loop module (sudo modprobe loop) and check /dev/loop*; in a container run it privileged with the host /dev/loop* devices",
+	},
+	{
+		Code:    CodeMkfsNotFound,
+		Pattern: regexp.MustCompile(`exec: "(mkfs\.[a-z0-9]+)": executable file not found`),
+		Summary: "${1} is not installed",
+		Hint:    "install e2fsprogs (sudo apt install e2fsprogs)",
+	},
+	{
+		Code:    CodeCommandNotFound,
+		Pattern: regexp.MustCompile(`exec: "([^"]+)": executable file not found`),
+		Summary: "${1} is not installed or not in PATH",
+		Hint:    "install ${1}; with sudo, PATH is reset to secure_path in /etc/sudoers",
+	},
+	{
+		Code:    CodeDockerDaemon,
+		Pattern: regexp.MustCompile(`Cannot connect to the Docker daemon|Is the docker daemon running`),
+		Summary: "the docker daemon is not running",
+		Hint:    "start it (sudo systemctl start docker) and check that /var/run/docker.sock exists",
+	},
+	{
+		Code:    CodeDiskFull,
+		Pattern: regexp.MustCompile(`[Nn]o space left on device`),
+		Summary: "the disk is full",
+		Hint:    "free space on the filesystem of the output (df -h), or write the output to another filesystem",
+	},
+}
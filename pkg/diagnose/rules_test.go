@@ -0,0 +1,112 @@
+package diagnose
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestClassify(t *testing.T) {
+	exitErr := errors.New("exit status 1")
+	tests := []struct {
+		name    string
+		output  string
+		err     error
+		code    string
+		summary string
+		hint    string // Hint 中应包含的内容，为空时不检查
+	}{
+		{
+			name: "NO_PUBKEY",
+			output: "I: Retrieving InRelease\n" +
+				"W: GPG error: http://old-releases.ubuntu.com/ubuntu xenial Release: The following signatures couldn't be verified because the public key is not available: NO_PUBKEY 40976EAF437D05B5\n",
+			err:     exitErr,
+			code:    CodeReleaseKey,
+			summary: "the Release file is signed by key 40976EAF437D05B5, which is not in the debootstrap keyring",
+			hint:    "gpg --keyserver keyserver.ubuntu.com --recv-keys 40976EAF437D05B5 && gpg --export 40976EAF437D05B5 |",
+		},
+		{
+			name:    "unknown key",
+			output:  "E: Release signed by unknown key (key id 3B4FE6ACC0B21F32)\n",
+			err:     exitErr,
+			code:    CodeReleaseKey,
+			summary: "the Release file is signed by key 3B4FE6ACC0B21F32, which is not in the debootstrap keyring",
+		},
+		{
+			name:    "release file",
+			output:  "I: Retrieving Release \nE: Failed getting release file http://archive.ubuntu.com/ubuntu/dists/gutsy/Release\n",
+			err:     exitErr,
+			code:    CodeSuiteNotFound,
+			summary: "the suite is not available on the mirror (http://archive.ubuntu.com/ubuntu/dists/gutsy/Release)",
+		},
+		{
+			name:    "debs",
+			output:  "E: Couldn't find these debs: iproute2 vim-tiny\n",
+			err:     exitErr,
+			code:    CodePackagesNotFound,
+			summary: "packages not found on the mirror: iproute2 vim-tiny",
+		},
+		{
+			name:    "apt package",
+			output:  "E: Unable to locate package iproute2\n",
+			err:     exitErr,
+			code:    CodePackagesNotFound,
+			summary: "packages not found on the mirror: iproute2",
+		},
+		{
+			name:    "docker daemon",
+			output:  "Cannot connect to the Docker daemon at unix:///var/run/docker.sock. Is the docker daemon running?\n",
+			err:     exitErr,
+			code:    CodeDockerDaemon,
+			summary: "the docker daemon is not running",
+		},
+		{
+			name:    "ENOSPC",
+			output:  "tar: ./usr/lib/x86_64-linux-gnu/libLLVM.so.1: Cannot write: No space left on device\n",
+			err:     exitErr,
+			code:    CodeDiskFull,
+			summary: "the disk is full",
+		},
+		{
+			name:    "error message",
+			err:     fmt.Errorf("failed to create image: %v", errors.New(`exec: "mkfs.ext3": executable file not found in $PATH`)),
+			code:    CodeMkfsNotFound,
+			summary: "mkfs.ext3 is not installed",
+		},
+		{
+			name:    "timeout",
+			err:     errors.New("debootstrap timed out after 30m0s (raise it with [timeouts] debootstrap = DURATION or --timeout debootstrap=DURATION): context deadline exceeded"),
+			code:    CodeStageTimeout,
+			summary: "stage debootstrap did not finish within 30m0s",
+			hint:    "[timeouts] debootstrap = DURATION or --timeout debootstrap=DURATION",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var known *Error
+			if !errors.As(Classify(tt.output, tt.err), &known) {
+				t.Fatalf("Classify did not recognize the failure")
+			}
+			if known.Code != tt.code || known.Summary != tt.summary {
+				t.Errorf("Classify = %s: %s, want %s: %s", known.Code, known.Summary, tt.code, tt.summary)
+			}
+			if tt.hint != "" && !strings.Contains(known.Hint, tt.hint) {
+				t.Errorf("Hint = %q, want it to contain %q", known.Hint, tt.hint)
+			}
+			if !errors.Is(known, tt.err) {
+				t.Errorf("original error not wrapped: %v", known)
+			}
+		})
+	}
+}
+
+func TestClassifyUnknown(t *testing.T) {
+	err := errors.New("exit status 2")
+	if got := Classify("E: something else went wrong\n", err); got != err {
+		t.Errorf("Classify = %v, want the original error", got)
+	}
+	if got := Classify("No space left on device", nil); got != nil {
+		t.Errorf("Classify(nil) = %v", got)
+	}
+}
@@ -0,0 +1,90 @@
+package dpkg
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// PathFilter dpkg path-exclude/path-include 规则，后出现的规则优先
+type PathFilter struct {
+	rules []filterRule
+}
+
+type filterRule struct {
+	include bool
+	pattern *regexp.Regexp
+}
+
+// Exclude 添加 path-exclude 规则
+func (f *PathFilter) Exclude(pattern string) {
+	f.rules = append(f.rules, filterRule{include: false, pattern: globRegexp(pattern)})
+}
+
+// Include 添加 path-include 规则
+func (f *PathFilter) Include(pattern string) {
+	f.rules = append(f.rules, filterRule{include: true, pattern: globRegexp(pattern)})
+}
+
+// Excluded 判断路径（以 "/" 开头）是否被排除，不会被 dpkg 安装
+func (f *PathFilter) Excluded(path string) bool {
+	excluded := false
+	for _, rule := range f.rules {
+		if rule.pattern.MatchString(path) {
+			excluded = !rule.include
+		}
+	}
+	return excluded
+}
+
+// LoadPathFilter 读取根文件系统中 dpkg.cfg 及 dpkg.cfg.d 的 path-exclude/path-include 配置
+func LoadPathFilter(root string) *PathFilter {
+	filter := &PathFilter{}
+
+	files := []string{filepath.Join(root, "etc", "dpkg", "dpkg.cfg")}
+	parts, _ := filepath.Glob(filepath.Join(root, "etc", "dpkg", "dpkg.cfg.d", "*"))
+	sort.Strings(parts)
+	files = append(files, parts...)
+
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			continue
+		}
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			key, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+			switch {
+			case !ok:
+			case key == "path-exclude":
+				filter.Exclude(value)
+			case key == "path-include":
+				filter.Include(value)
+			}
+		}
+		f.Close()
+	}
+
+	return filter
+}
+
+// globRegexp 将 dpkg 路径模式转换为正则表达式，与 fnmatch(3) 不带 FNM_PATHNAME 一致，"*" 可以匹配 "/"
+func globRegexp(pattern string) *regexp.Regexp {
+	var sb strings.Builder
+	sb.WriteString("^")
+	for _, r := range pattern {
+		switch r {
+		case '*':
+			sb.WriteString(".*")
+		case '?':
+			sb.WriteString(".")
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	sb.WriteString("$")
+	return regexp.MustCompile(sb.String())
+}
@@ -0,0 +1,135 @@
+package dpkg
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// StatusPath dpkg 数据库在根文件系统中的路径
+const StatusPath = "var/lib/dpkg/status"
+
+// Package dpkg 数据库中的软件包
+type Package struct {
+	Name         string `json:"name"`
+	Version      string `json:"version"`
+	Architecture string `json:"architecture"`
+	Source       string `json:"source,omitempty"`
+	Status       string `json:"-"`
+	Maintainer   string `json:"-"`
+	Homepage     string `json:"-"`
+	Description  string `json:"-"` // 描述的第一行
+}
+
+// Installed 判断软件包是否已安装
+func (p Package) Installed() bool {
+	return strings.HasSuffix(p.Status, " installed")
+}
+
+// SourceName 返回源码包名称，Source 字段可能带有版本号
+func (p Package) SourceName() string {
+	if p.Source == "" {
+		return p.Name
+	}
+	return strings.Fields(p.Source)[0]
+}
+
+// ParseStatus 解析 dpkg status 格式的数据，返回所有段落中的软件包
+func ParseStatus(r io.Reader) ([]Package, error) {
+	var packages []Package
+	var current Package
+	var field string
+
+	flush := func() {
+		if current.Name != "" {
+			packages = append(packages, current)
+		}
+		current = Package{}
+		field = ""
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			flush()
+			continue
+		}
+		// 续行属于上一个字段
+		if line[0] == ' ' || line[0] == '\t' {
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		field = key
+		value = strings.TrimSpace(value)
+
+		switch field {
+		case "Package":
+			current.Name = value
+		case "Version":
+			current.Version = value
+		case "Architecture":
+			current.Architecture = value
+		case "Source":
+			current.Source = value
+		case "Status":
+			current.Status = value
+		case "Maintainer":
+			current.Maintainer = value
+		case "Homepage":
+			current.Homepage = value
+		case "Description":
+			current.Description = value
+		}
+	}
+	flush()
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to parse dpkg status: %v", err)
+	}
+	return packages, nil
+}
+
+// ReadInstalled 读取根文件系统中已安装的软件包，按名称排序
+func ReadInstalled(root string) ([]Package, error) {
+	path := filepath.Join(root, StatusPath)
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open dpkg database %s: %v", path, err)
+	}
+	defer f.Close()
+
+	return ParseInstalled(f)
+}
+
+// ParseInstalled 解析 dpkg status 格式的数据，返回已安装的软件包，按名称排序
+func ParseInstalled(r io.Reader) ([]Package, error) {
+	all, err := ParseStatus(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var installed []Package
+	for _, p := range all {
+		if p.Installed() {
+			installed = append(installed, p)
+		}
+	}
+	sort.Slice(installed, func(i, j int) bool {
+		if installed[i].Name != installed[j].Name {
+			return installed[i].Name < installed[j].Name
+		}
+		return installed[i].Architecture < installed[j].Architecture
+	})
+
+	return installed, nil
+}
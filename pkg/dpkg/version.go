@@ -0,0 +1,102 @@
+package dpkg
+
+import (
+	"strconv"
+	"strings"
+)
+
+// CompareVersions 按 dpkg 规则比较两个软件包版本（[epoch:]upstream[-revision]）
+//
+// a < b 返回负数，相等返回 0，a > b 返回正数。
+func CompareVersions(a, b string) int {
+	ea, ua, ra := splitVersion(a)
+	eb, ub, rb := splitVersion(b)
+
+	if ea != eb {
+		if ea < eb {
+			return -1
+		}
+		return 1
+	}
+	if c := compareFragment(ua, ub); c != 0 {
+		return c
+	}
+	return compareFragment(ra, rb)
+}
+
+// splitVersion 拆分 epoch、upstream 版本及 Debian 修订号
+func splitVersion(v string) (int, string, string) {
+	epoch := 0
+	if e, rest, ok := strings.Cut(v, ":"); ok {
+		epoch, _ = strconv.Atoi(e)
+		v = rest
+	}
+
+	revision := ""
+	if i := strings.LastIndex(v, "-"); i >= 0 {
+		v, revision = v[:i], v[i+1:]
+	}
+	return epoch, v, revision
+}
+
+// order 字符排序权重："~" 最小，其次是字符串结尾，字母小于其他符号
+func order(c byte) int {
+	switch {
+	case c >= '0' && c <= '9':
+		return 0
+	case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+		return int(c)
+	case c == '~':
+		return -1
+	}
+	return int(c) + 256
+}
+
+// compareFragment 交替比较非数字部分和数字部分
+func compareFragment(a, b string) int {
+	for a != "" || b != "" {
+		// 非数字部分
+		for (a != "" && !isDigit(a[0])) || (b != "" && !isDigit(b[0])) {
+			var ca, cb int
+			if a != "" && !isDigit(a[0]) {
+				ca = order(a[0])
+			}
+			if b != "" && !isDigit(b[0]) {
+				cb = order(b[0])
+			}
+			if ca != cb {
+				return ca - cb
+			}
+			a, b = a[1:], b[1:]
+		}
+
+		// 数字部分，忽略前导 0
+		a = strings.TrimLeft(a, "0")
+		b = strings.TrimLeft(b, "0")
+		na, nb := digitPrefix(a), digitPrefix(b)
+		if na != nb {
+			return na - nb
+		}
+		for i := 0; i < na; i++ {
+			if a[i] != b[i] {
+				return int(a[i]) - int(b[i])
+			}
+		}
+		a, b = a[na:], b[nb:]
+	}
+	return 0
+}
+
+// isDigit 判断是否为数字
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// digitPrefix 返回开头连续数字的长度
+func digitPrefix(s string) int {
+	n := 0
+	for n < len(s) && isDigit(s[n]) {
+		n++
+	}
+	return n
+}
@@ -0,0 +1,58 @@
+package dpkg
+
+import "testing"
+
+// 期望值与 dpkg --compare-versions 一致
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.0", "1.0", 0},
+		{"1.0", "1.0-0", 0},
+		// ~ 小于字符串结尾
+		{"1.0~rc1", "1.0", -1},
+		{"1.0~~", "1.0~", -1},
+		{"1.0~", "1.0", -1},
+		// 字母小于其他符号
+		{"1.0", "1.0a", -1},
+		{"1.0a", "1.0+", -1},
+		// 修订号
+		{"1.0-1", "1.0-2", -1},
+		{"1.0-1ubuntu1", "1.0-1", 1},
+		{"1.0-1ubuntu1", "1.0-1ubuntu1.1", -1},
+		// epoch 优先，省略时为 0
+		{"1:1.0", "2.0", 1},
+		{"1:1.0", "1:1.0-1", -1},
+		{"0:1.0", "1.0", 0},
+		// 数字部分按数值比较
+		{"2.0.10", "2.0.9", 1},
+		{"2.00", "2.0", 0},
+		{"1.0.0", "1.0", 1},
+		{"2.7.4-0ubuntu1.6", "2.7.4-0ubuntu1.10", -1},
+		// 修订号从最后一个 - 开始
+		{"1.2-3-4", "1.2-3", 1},
+		{"3.113+nmu3ubuntu4", "3.113+nmu3ubuntu3", 1},
+		{"1.0+dfsg-1", "1.0-1", 1},
+	}
+	for _, tt := range tests {
+		if got := sign(CompareVersions(tt.a, tt.b)); got != tt.want {
+			t.Errorf("CompareVersions(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+		// 交换参数时结果相反
+		if got := sign(CompareVersions(tt.b, tt.a)); got != -tt.want {
+			t.Errorf("CompareVersions(%q, %q) = %d, want %d", tt.b, tt.a, got, -tt.want)
+		}
+	}
+}
+
+// sign 返回 n 的符号
+func sign(n int) int {
+	switch {
+	case n < 0:
+		return -1
+	case n > 0:
+		return 1
+	}
+	return 0
+}
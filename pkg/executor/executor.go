@@ -0,0 +1,124 @@
+package executor
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+
+	"github.com/rivsidn/kdev_bootstrap/pkg/utils"
+)
+
+// Executor 执行外部命令及文件操作，构建器通过它调用 debootstrap、docker、losetup 等命令
+//
+// Real 直接执行；DryRun 只将命令及文件操作添加到计划；Recorder 执行并记录命令及输出；
+// Replay 按记录返回输出，不执行任何命令，文件操作与 DryRun 相同，
+// 用于在没有 root 权限及工具时测试构建流程。
+type Executor interface {
+	// Run 执行命令，输出写入终端
+	Run(ctx context.Context, name string, args ...string) error
+	// Output 执行命令，返回标准输出及标准错误
+	Output(ctx context.Context, name string, args ...string) (string, error)
+	// RunWithLog 执行命令，输出同时写入终端和日志
+	RunWithLog(ctx context.Context, log io.Writer, name string, args ...string) error
+
+	// Exists 判断路径是否存在，不跟随符号链接
+	Exists(path string) bool
+	// ReadFile 读取文件，DryRun 返回计划中写入的内容
+	ReadFile(path string) ([]byte, error)
+	// WriteFile 创建或覆盖文件，权限设置为 perm，不受 umask 影响
+	WriteFile(path string, data []byte, perm fs.FileMode) error
+	// WriteTemp 在 dir 下创建名称匹配 pattern（与 os.CreateTemp 相同）的文件并写入，返回文件路径
+	WriteTemp(dir, pattern string, data []byte, perm fs.FileMode) (string, error)
+	// Create 创建或清空文件（0644）用于追加写入，如日志
+	Create(path string) (io.WriteCloser, error)
+	// MkdirAll 创建目录及不存在的上级目录
+	MkdirAll(path string, perm fs.FileMode) error
+	// MkdirTemp 与 os.MkdirTemp 相同，在 dir 下创建名称以 pattern 开头的临时目录
+	MkdirTemp(dir, pattern string) (string, error)
+	// Symlink 创建符号链接 newname，指向 oldname
+	Symlink(oldname, newname string) error
+	// Chmod 修改权限，不受 umask 影响
+	Chmod(path string, perm fs.FileMode) error
+	// Lchown 修改属主，不跟随符号链接
+	Lchown(path string, uid, gid int) error
+	// Rename 重命名文件或目录
+	Rename(oldpath, newpath string) error
+	// Remove 删除文件或空目录并记录到审计日志，返回 os.Remove 的错误
+	Remove(path string) error
+	// RemoveAll 递归删除 path 并记录到审计日志，path 不存在时返回 nil
+	RemoveAll(path string) error
+	// RemoveMatched 删除 patterns 匹配的文件 paths，非空目录跳过，审计日志中只记录一次 patterns 及删除的数量
+	RemoveMatched(patterns, paths []string) error
+	// Lock 获取 target 的锁（见 lock 包），shared 为 true 时为共享锁，返回释放函数
+	Lock(target string, shared bool) (release func() error, err error)
+}
+
+// Default 未指定执行器时使用的执行器
+var Default Executor = Real{}
+
+// Or 返回 e，e 为 nil 时返回 Default
+func Or(e Executor) Executor {
+	if e == nil {
+		return Default
+	}
+	return e
+}
+
+// Real 执行命令，ctx 取消时终止命令所在的进程组
+type Real struct{}
+
+func (Real) Run(ctx context.Context, name string, args ...string) error {
+	return utils.RunCommandContext(ctx, name, args...)
+}
+
+func (Real) Output(ctx context.Context, name string, args ...string) (string, error) {
+	return utils.RunCommandOutputContext(ctx, name, args...)
+}
+
+func (Real) RunWithLog(ctx context.Context, log io.Writer, name string, args ...string) error {
+	return utils.RunCommandWithLogContext(ctx, log, name, args...)
+}
+
+// DryRun 不执行命令，不修改文件系统，只将命令及文件操作添加到计划
+type DryRun struct {
+	Plan *Plan
+	// Outputs 按命令行（CommandLine）指定 Output 返回的内容，未指定时返回空字符串
+	Outputs map[string]string
+}
+
+func (d DryRun) Run(ctx context.Context, name string, args ...string) error {
+	d.Plan.Run("", name, args...)
+	return ctx.Err()
+}
+
+func (d DryRun) Output(ctx context.Context, name string, args ...string) (string, error) {
+	d.Plan.Run("", name, args...)
+	return d.Outputs[CommandLine(name, args)], ctx.Err()
+}
+
+func (d DryRun) RunWithLog(ctx context.Context, log io.Writer, name string, args ...string) error {
+	return d.Run(ctx, name, args...)
+}
+
+// Simulated 判断 e 是否只模拟执行（DryRun、Replay）：不执行命令，不修改文件系统
+func Simulated(e Executor) bool {
+	switch e.(type) {
+	case DryRun, *DryRun, *Replay:
+		return true
+	}
+	return false
+}
+
+// CommandLine 返回命令行，含空白的参数加引号
+func CommandLine(name string, args []string) string {
+	words := []string{name}
+	for _, arg := range args {
+		if arg == "" || strings.ContainsAny(arg, " \t\n'\"") {
+			arg = fmt.Sprintf("%q", arg)
+		}
+		words = append(words, arg)
+	}
+	return strings.Join(words, " ")
+}
@@ -0,0 +1,266 @@
+package executor
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rivsidn/kdev_bootstrap/pkg/audit"
+	"github.com/rivsidn/kdev_bootstrap/pkg/lock"
+)
+
+// Real 的文件操作直接修改文件系统，删除记录到审计日志
+
+func (Real) Exists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
+
+func (Real) ReadFile(path string) ([]byte, error) {
+	return os.ReadFile(path)
+}
+
+func (Real) WriteFile(path string, data []byte, perm fs.FileMode) error {
+	if err := os.WriteFile(path, data, perm); err != nil {
+		return err
+	}
+	return os.Chmod(path, perm)
+}
+
+func (Real) WriteTemp(dir, pattern string, data []byte, perm fs.FileMode) (string, error) {
+	f, err := os.CreateTemp(dir, pattern)
+	if err != nil {
+		return "", err
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), perm)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+func (Real) Create(path string) (io.WriteCloser, error) {
+	return os.Create(path)
+}
+
+func (Real) MkdirAll(path string, perm fs.FileMode) error {
+	return os.MkdirAll(path, perm)
+}
+
+func (Real) MkdirTemp(dir, pattern string) (string, error) {
+	return os.MkdirTemp(dir, pattern)
+}
+
+func (Real) Symlink(oldname, newname string) error {
+	return os.Symlink(oldname, newname)
+}
+
+func (Real) Chmod(path string, perm fs.FileMode) error {
+	return os.Chmod(path, perm)
+}
+
+func (Real) Lchown(path string, uid, gid int) error {
+	return os.Lchown(path, uid, gid)
+}
+
+func (Real) Rename(oldpath, newpath string) error {
+	return os.Rename(oldpath, newpath)
+}
+
+func (Real) Remove(path string) error {
+	return audit.Remove(path)
+}
+
+func (Real) RemoveAll(path string) error {
+	return audit.RemoveAll(path)
+}
+
+func (Real) RemoveMatched(patterns, paths []string) (err error) {
+	removed := 0
+	start := time.Now()
+	defer func() { audit.Removed(patterns, removed, start, err) }()
+
+	for _, path := range paths {
+		if err := os.Remove(path); err != nil {
+			if info, statErr := os.Lstat(path); statErr == nil && info.IsDir() {
+				continue // 目录中有保留的文件
+			}
+			return err
+		}
+		removed++
+	}
+	return nil
+}
+
+func (Real) Lock(target string, shared bool) (func() error, error) {
+	acquire := lock.Acquire
+	if shared {
+		acquire = lock.AcquireShared
+	}
+	l, err := acquire(target)
+	if err != nil {
+		return nil, err
+	}
+	return l.Release, nil
+}
+
+// DryRun 的文件操作只添加到计划，之后的 Exists、ReadFile 以计划为准
+
+func (d DryRun) Exists(path string) bool {
+	return d.Plan.Exists(path)
+}
+
+func (d DryRun) ReadFile(path string) ([]byte, error) {
+	if data, ok := d.Plan.content(path); ok {
+		return data, nil
+	}
+	if !d.Plan.Exists(path) {
+		return nil, &fs.PathError{Op: "open", Path: path, Err: fs.ErrNotExist}
+	}
+	return os.ReadFile(path)
+}
+
+func (d DryRun) WriteFile(path string, data []byte, perm fs.FileMode) error {
+	d.Plan.Write(path, "")
+	d.Plan.setContent(path, data)
+	return nil
+}
+
+// WriteTemp 的随机部分为 XXXXXX，计划的输出不随每次执行变化
+func (d DryRun) WriteTemp(dir, pattern string, data []byte, perm fs.FileMode) (string, error) {
+	path := tempPath(dir, pattern)
+	d.Plan.Write(path, "temporary file")
+	d.Plan.setContent(path, data)
+	return path, nil
+}
+
+func (d DryRun) Create(path string) (io.WriteCloser, error) {
+	d.Plan.Write(path, "")
+	return nopCloser{io.Discard}, nil
+}
+
+// MkdirAll 只为 path 添加一步，同时创建的上级目录随之记录为已存在
+func (d DryRun) MkdirAll(path string, perm fs.FileMode) error {
+	if d.Plan.Exists(path) {
+		return nil
+	}
+	for dir := filepath.Dir(absPath(path)); !d.Plan.Exists(dir); dir = filepath.Dir(dir) {
+		d.Plan.track(dir, fileFresh)
+	}
+	d.Plan.Mkdir(path, "")
+	return nil
+}
+
+// MkdirTemp 的随机部分为 XXXXXX，计划的输出不随每次执行变化
+func (d DryRun) MkdirTemp(dir, pattern string) (string, error) {
+	path := tempPath(dir, pattern)
+	d.Plan.Mkdir(path, "temporary directory")
+	return path, nil
+}
+
+func (d DryRun) Symlink(oldname, newname string) error {
+	if d.Plan.Exists(newname) {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: fs.ErrExist}
+	}
+	d.Plan.Write(newname, "symlink to "+oldname)
+	return nil
+}
+
+func (d DryRun) Chmod(path string, perm fs.FileMode) error {
+	if !d.Plan.Exists(path) {
+		return &fs.PathError{Op: "chmod", Path: path, Err: fs.ErrNotExist}
+	}
+	return nil
+}
+
+func (d DryRun) Lchown(path string, uid, gid int) error {
+	if !d.Plan.Exists(path) {
+		return &fs.PathError{Op: "lchown", Path: path, Err: fs.ErrNotExist}
+	}
+	d.Plan.Chown(path, fmt.Sprintf("%d:%d", uid, gid))
+	return nil
+}
+
+func (d DryRun) Rename(oldpath, newpath string) error {
+	if !d.Plan.Exists(oldpath) {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: fs.ErrNotExist}
+	}
+	d.Plan.Rename(oldpath, newpath, "")
+	return nil
+}
+
+func (d DryRun) Remove(path string) error {
+	if !d.Plan.Exists(path) {
+		return &fs.PathError{Op: "remove", Path: path, Err: fs.ErrNotExist}
+	}
+	d.Plan.Delete(path, "")
+	return nil
+}
+
+func (d DryRun) RemoveAll(path string) error {
+	if d.Plan.Exists(path) {
+		d.Plan.Delete(path, "")
+	}
+	return nil
+}
+
+// RemoveMatched 每个 pattern 添加一步，paths 随之从计划中删除
+func (d DryRun) RemoveMatched(patterns, paths []string) error {
+	note := "matching files, if any"
+	if len(paths) > 0 {
+		note = fmt.Sprintf("%d matching files", len(paths))
+	}
+	for _, pattern := range patterns {
+		d.Plan.Delete(pattern, note)
+	}
+	for _, path := range paths {
+		d.Plan.track(absPath(path), fileDeleted)
+	}
+	return nil
+}
+
+func (d DryRun) Lock(target string, shared bool) (func() error, error) {
+	path := lock.Path(target)
+	if shared {
+		d.Plan.Write(path, "shared lock")
+		return func() error {
+			d.Plan.Delete(path, "unless another build still holds the shared lock")
+			return nil
+		}, nil
+	}
+	d.Plan.Write(path, "exclusive lock")
+	return func() error {
+		d.Plan.Delete(path, "")
+		return nil
+	}, nil
+}
+
+// tempPath 返回 DryRun 创建的临时文件或目录路径，pattern 中最后一个 * 或末尾为随机部分
+func tempPath(dir, pattern string) string {
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	name := pattern + "XXXXXX"
+	if i := strings.LastIndex(pattern, "*"); i >= 0 {
+		name = pattern[:i] + "XXXXXX" + pattern[i+1:]
+	}
+	return filepath.Join(dir, name)
+}
+
+// nopCloser 为 io.Writer 添加不做任何事的 Close
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
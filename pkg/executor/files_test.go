@@ -0,0 +1,67 @@
+package executor
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDryRunFiles(t *testing.T) {
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "etc", "resolv.conf")
+	if err := os.MkdirAll(filepath.Dir(existing), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(existing, []byte("nameserver 127.0.0.53\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	d := DryRun{Plan: NewPlan("test")}
+	saved := existing + ".kboot-saved"
+	if err := d.Rename(existing, saved); err != nil {
+		t.Fatalf("Rename: %v", err)
+	}
+	if err := d.WriteFile(existing, []byte("nameserver 1.1.1.1\n"), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if !d.Exists(existing) || !d.Exists(saved) {
+		t.Error("planned files do not exist in the plan")
+	}
+
+	tmp, err := d.MkdirTemp(dir, "kboot-image-")
+	if err != nil || tmp != filepath.Join(dir, "kboot-image-XXXXXX") {
+		t.Fatalf("MkdirTemp = %q, %v", tmp, err)
+	}
+	if err := d.WriteFile(filepath.Join(tmp, "a"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.RemoveAll(tmp); err != nil {
+		t.Fatalf("RemoveAll: %v", err)
+	}
+	if d.Exists(filepath.Join(tmp, "a")) || d.Exists(tmp) {
+		t.Error("removed directory still exists in the plan")
+	}
+	if err := d.Remove(tmp); !os.IsNotExist(err) {
+		t.Errorf("Remove of a removed directory = %v, want not exist", err)
+	}
+
+	// 删除后重新创建的目录中只有计划中的文件
+	etc := filepath.Dir(existing)
+	d.RemoveAll(etc)
+	d.MkdirAll(etc, 0755)
+	if d.Exists(existing) {
+		t.Errorf("%s exists in the recreated directory", existing)
+	}
+
+	// 文件系统没有变化
+	data, err := os.ReadFile(existing)
+	if err != nil || string(data) != "nameserver 127.0.0.53\n" {
+		t.Errorf("%s changed by DryRun: %q, %v", existing, data, err)
+	}
+	if _, err := os.Lstat(saved); err == nil {
+		t.Errorf("%s created by DryRun", saved)
+	}
+	if want := 7; len(d.Plan.Steps) != want {
+		t.Errorf("plan has %d steps, want %d", len(d.Plan.Steps), want)
+	}
+}
@@ -0,0 +1,207 @@
+package executor
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// 计划中的操作
+const (
+	ActionRun    = "run"    // 执行外部命令
+	ActionMkdir  = "mkdir"  // 创建目录
+	ActionWrite  = "write"  // 创建或覆盖文件
+	ActionDelete = "delete" // 删除文件或目录（递归）
+	ActionRename = "rename" // 重命名为 Target
+	ActionChown  = "chown"  // 修改属主，Note 为新的属主
+)
+
+// Step 计划中的一步
+type Step struct {
+	Action  string   `json:"action"`
+	Command []string `json:"command,omitempty"` // run: 命令及参数
+	Path    string   `json:"path,omitempty"`    // mkdir、write、delete、rename、chown: 绝对路径
+	Target  string   `json:"target,omitempty"`  // rename: 新的绝对路径
+	Note    string   `json:"note,omitempty"`    // 执行条件或说明
+}
+
+// Plan 构建将要执行的命令及修改的文件，按执行顺序排列
+type Plan struct {
+	Tool  string `json:"tool"`
+	Steps []Step `json:"steps"`
+
+	files    map[string]fileState // 计划中创建或删除的路径
+	contents map[string][]byte    // 计划中写入的文件内容，DryRun.ReadFile 返回
+}
+
+// fileState 计划执行到目前时路径的状态
+type fileState int
+
+const (
+	fileDeleted fileState = iota // 已删除
+	fileWritten                  // 已创建，其中原有的文件不受影响
+	fileFresh                    // 删除后重新创建的目录，其中只有计划中创建的文件
+)
+
+// NewPlan 创建计划
+func NewPlan(tool string) *Plan {
+	return &Plan{Tool: tool, Steps: []Step{}}
+}
+
+// Run 添加执行命令的一步
+func (p *Plan) Run(note, name string, args ...string) {
+	p.Steps = append(p.Steps, Step{Action: ActionRun, Command: append([]string{name}, args...), Note: note})
+}
+
+// Mkdir 添加创建目录的一步
+func (p *Plan) Mkdir(path, note string) {
+	path = p.add(ActionMkdir, path, note)
+	if p.fresh(path) {
+		p.track(path, fileFresh)
+	} else {
+		p.track(path, fileWritten)
+	}
+}
+
+// Write 添加创建或覆盖文件的一步
+func (p *Plan) Write(path, note string) {
+	p.track(p.add(ActionWrite, path, note), fileWritten)
+}
+
+// Delete 添加删除文件或目录的一步
+func (p *Plan) Delete(path, note string) {
+	p.track(p.add(ActionDelete, path, note), fileDeleted)
+}
+
+// Rename 添加重命名的一步
+func (p *Plan) Rename(oldpath, newpath, note string) {
+	oldpath, newpath = absPath(oldpath), absPath(newpath)
+	p.Steps = append(p.Steps, Step{Action: ActionRename, Path: oldpath, Target: newpath, Note: note})
+	data, ok := p.content(oldpath)
+	p.track(oldpath, fileDeleted)
+	p.track(newpath, fileWritten)
+	if ok {
+		p.setContent(newpath, data)
+	}
+}
+
+// Chown 添加修改属主的一步，owner 为新的属主
+func (p *Plan) Chown(path, owner string) {
+	p.add(ActionChown, path, owner)
+}
+
+// add 添加文件操作，路径转换为绝对路径，返回转换后的路径
+func (p *Plan) add(action, path, note string) string {
+	path = absPath(path)
+	p.Steps = append(p.Steps, Step{Action: action, Path: path, Note: note})
+	return path
+}
+
+// Exists 判断计划执行到目前时 path 是否存在：计划中创建或删除的路径以计划为准，其他路径查询文件系统
+func (p *Plan) Exists(path string) bool {
+	path = absPath(path)
+	if state, ok := p.files[path]; ok {
+		return state != fileDeleted
+	}
+	if p.fresh(filepath.Dir(path)) {
+		return false
+	}
+	_, err := os.Lstat(path)
+	return err == nil
+}
+
+// fresh 判断 path 或其上级目录是否在计划中被删除，此时其中原有的文件已不存在
+func (p *Plan) fresh(path string) bool {
+	for {
+		if state, ok := p.files[path]; ok && state != fileWritten {
+			return true
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return false
+		}
+		path = parent
+	}
+}
+
+// track 记录路径的状态，删除时一并清除其中文件的记录
+func (p *Plan) track(path string, state fileState) {
+	if p.files == nil {
+		p.files = make(map[string]fileState)
+	}
+	delete(p.contents, path)
+	if state == fileDeleted {
+		for name := range p.files {
+			if strings.HasPrefix(name, path+"/") {
+				delete(p.files, name)
+				delete(p.contents, name)
+			}
+		}
+	}
+	p.files[path] = state
+}
+
+// setContent 记录计划中写入的文件内容
+func (p *Plan) setContent(path string, data []byte) {
+	if p.contents == nil {
+		p.contents = make(map[string][]byte)
+	}
+	p.contents[absPath(path)] = bytes.Clone(data)
+}
+
+// content 返回计划中写入的文件内容
+func (p *Plan) content(path string) ([]byte, bool) {
+	data, ok := p.contents[absPath(path)]
+	return data, ok
+}
+
+// absPath 返回绝对路径，失败时返回原路径
+func absPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
+
+// Print 按顺序输出计划
+func (p *Plan) Print(w io.Writer) {
+	fmt.Fprintf(w, "Plan for %s (%d steps, nothing has been changed):\n", p.Tool, len(p.Steps))
+	width := len(fmt.Sprint(len(p.Steps)))
+	for i, step := range p.Steps {
+		target := step.Path
+		switch step.Action {
+		case ActionRun:
+			target = CommandLine(step.Command[0], step.Command[1:])
+		case ActionRename:
+			target = step.Path + " -> " + step.Target
+		}
+		fmt.Fprintf(w, "%*d. %-6s %s\n", width, i+1, step.Action, target)
+		if step.Note != "" {
+			fmt.Fprintf(w, "%*s  %s# %s\n", width, "", strings.Repeat(" ", 7), step.Note)
+		}
+	}
+}
+
+// WriteJSON 以 JSON 格式输出计划
+func (p *Plan) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(p)
+}
+
+// Output 按格式输出计划，format 为 text 或 json
+func (p *Plan) Output(w io.Writer, format string) error {
+	switch format {
+	case "text":
+		p.Print(w)
+		return nil
+	case "json":
+		return p.WriteJSON(w)
+	default:
+		return fmt.Errorf("unknown plan format: %s (supported: text, json)", format)
+	}
+}
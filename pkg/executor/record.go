@@ -0,0 +1,114 @@
+package executor
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/rivsidn/kdev_bootstrap/pkg/utils"
+)
+
+// Record 一次命令执行的记录，记录文件每行一条（JSON Lines）
+type Record struct {
+	Name   string   `json:"name"`
+	Args   []string `json:"args"`
+	Output string   `json:"output,omitempty"` // 标准输出及标准错误
+	Error  string   `json:"error,omitempty"`  // 执行失败时的错误信息
+}
+
+// Recorder 执行命令，并将命令及输出追加到记录文件，构建中断时已执行的命令同样保留
+//
+// 文件操作与 Real 相同，不记录。
+type Recorder struct {
+	Real
+	mu   sync.Mutex
+	file *os.File
+}
+
+// NewRecorder 创建记录文件，已存在时覆盖
+func NewRecorder(path string) (*Recorder, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create record file %s: %v", path, err)
+	}
+	return &Recorder{file: file}, nil
+}
+
+// Close 关闭记录文件
+func (r *Recorder) Close() error {
+	return r.file.Close()
+}
+
+func (r *Recorder) Run(ctx context.Context, name string, args ...string) error {
+	var output bytes.Buffer
+	err := utils.RunCommandTeeContext(ctx, &output, name, args...)
+	return r.record(name, args, output.String(), err)
+}
+
+func (r *Recorder) Output(ctx context.Context, name string, args ...string) (string, error) {
+	output, err := utils.RunCommandOutputContext(ctx, name, args...)
+	return output, r.record(name, args, output, err)
+}
+
+func (r *Recorder) RunWithLog(ctx context.Context, log io.Writer, name string, args ...string) error {
+	var output bytes.Buffer
+	fmt.Fprintf(log, "+ %s %s\n", name, strings.Join(args, " "))
+	err := utils.RunCommandTeeContext(ctx, io.MultiWriter(log, &output), name, args...)
+	if err != nil {
+		fmt.Fprintf(log, "%v\n", err)
+	}
+	return r.record(name, args, output.String(), err)
+}
+
+// record 追加一条记录，返回命令的执行结果
+func (r *Recorder) record(name string, args []string, output string, err error) error {
+	rec := Record{Name: name, Args: args, Output: output}
+	if err != nil {
+		rec.Error = err.Error()
+	}
+
+	data, jerr := json.Marshal(rec)
+	if jerr != nil {
+		return fmt.Errorf("failed to encode record: %v", jerr)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, werr := r.file.Write(append(data, '\n')); werr != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to write record file %s: %v\n", r.file.Name(), werr)
+	}
+	return err
+}
+
+// LoadRecords 读取记录文件
+func LoadRecords(path string) ([]Record, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open record file %s: %v", path, err)
+	}
+	defer file.Close()
+
+	var records []Record
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var rec Record
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return nil, fmt.Errorf("invalid record at %s:%d: %v", path, line, err)
+		}
+		records = append(records, rec)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read record file %s: %v", path, err)
+	}
+	return records, nil
+}
@@ -0,0 +1,132 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/rivsidn/kdev_bootstrap/pkg/diagnose"
+)
+
+// Replay 按顺序匹配记录并返回记录的输出及结果，不执行任何命令
+//
+// 命令与下一条记录不一致时返回错误，构建流程随之失败，
+// 测试据此发现命令顺序或参数的变化。文件操作与 DryRun 相同，只添加到 Plan。
+type Replay struct {
+	DryRun
+	Records []Record
+	// Normalize 比较前处理命令及记录的每个参数，用于替换每次构建不同的临时路径，nil 时不处理
+	Normalize func(arg string) string
+	// Stdout Run 回放输出的位置，nil 时丢弃
+	Stdout io.Writer
+
+	mu   sync.Mutex
+	next int
+}
+
+// NewReplay 创建回放执行器
+func NewReplay(records []Record) *Replay {
+	return &Replay{DryRun: DryRun{Plan: NewPlan("replay")}, Records: records}
+}
+
+// LoadReplay 读取 Recorder 生成的记录文件并创建回放执行器
+func LoadReplay(path string) (*Replay, error) {
+	records, err := LoadRecords(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewReplay(records), nil
+}
+
+func (r *Replay) Run(ctx context.Context, name string, args ...string) error {
+	rec, err := r.match(ctx, name, args)
+	if err != nil {
+		return err
+	}
+	if r.Stdout != nil {
+		io.WriteString(r.Stdout, rec.Output)
+	}
+	return recordError(rec)
+}
+
+func (r *Replay) Output(ctx context.Context, name string, args ...string) (string, error) {
+	rec, err := r.match(ctx, name, args)
+	if err != nil {
+		return "", err
+	}
+	if rec.Error != "" {
+		return "", recordError(rec)
+	}
+	return rec.Output, nil
+}
+
+func (r *Replay) RunWithLog(ctx context.Context, log io.Writer, name string, args ...string) error {
+	rec, err := r.match(ctx, name, args)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(log, "+ %s %s\n", name, strings.Join(args, " "))
+	io.WriteString(log, rec.Output)
+	if rec.Error != "" {
+		fmt.Fprintf(log, "%s\n", rec.Error)
+	}
+	return recordError(rec)
+}
+
+// Done 返回未回放的记录，全部回放时返回 nil
+func (r *Replay) Done() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.next < len(r.Records) {
+		rec := r.Records[r.next]
+		return fmt.Errorf("replay: %d command(s) not executed, next: %s",
+			len(r.Records)-r.next, CommandLine(rec.Name, rec.Args))
+	}
+	return nil
+}
+
+// match 比较命令与下一条记录，一致时消耗该记录
+func (r *Replay) match(ctx context.Context, name string, args []string) (Record, error) {
+	if err := ctx.Err(); err != nil {
+		return Record{}, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	got := CommandLine(r.normalize(name, args))
+	if r.next >= len(r.Records) {
+		return Record{}, fmt.Errorf("replay: unexpected command %d: %s", r.next+1, got)
+	}
+
+	rec := r.Records[r.next]
+	if want := CommandLine(r.normalize(rec.Name, rec.Args)); want != got {
+		return Record{}, fmt.Errorf("replay: command %d mismatch\n  want: %s\n  got:  %s", r.next+1, want, got)
+	}
+	r.next++
+	return rec, nil
+}
+
+// normalize 处理命令及参数
+func (r *Replay) normalize(name string, args []string) (string, []string) {
+	if r.Normalize == nil {
+		return name, args
+	}
+	normalized := make([]string, len(args))
+	for i, arg := range args {
+		normalized[i] = r.Normalize(arg)
+	}
+	return r.Normalize(name), normalized
+}
+
+// recordError 返回记录的错误
+func recordError(rec Record) error {
+	if rec.Error == "" {
+		return nil
+	}
+	return diagnose.Classify(rec.Output, errors.New(rec.Error))
+}
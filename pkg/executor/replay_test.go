@@ -0,0 +1,140 @@
+package executor
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testRecords() []Record {
+	return []Record{
+		{Name: "losetup", Args: []string{"-f"}, Output: "/dev/loop7\n"},
+		{Name: "mkfs.ext3", Args: []string{"-F", "/dev/loop7"}, Output: "done\n"},
+		{Name: "mount", Args: []string{"-o", "loop", "/tmp/a/img", "/tmp/a/mnt"}, Output: "busy\n", Error: "exit status 32"},
+	}
+}
+
+func TestReplay(t *testing.T) {
+	r := NewReplay(testRecords())
+	var stdout bytes.Buffer
+	r.Stdout = &stdout
+	ctx := context.Background()
+
+	output, err := r.Output(ctx, "losetup", "-f")
+	if err != nil || output != "/dev/loop7\n" {
+		t.Fatalf("Output = %q, %v; want recorded output", output, err)
+	}
+	if err := r.Run(ctx, "mkfs.ext3", "-F", "/dev/loop7"); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if stdout.String() != "done\n" {
+		t.Errorf("Stdout = %q, want %q", stdout.String(), "done\n")
+	}
+	if err := r.Done(); err == nil || !strings.Contains(err.Error(), "1 command(s) not executed, next: mount -o loop") {
+		t.Errorf("Done before the last record = %v", err)
+	}
+
+	var log bytes.Buffer
+	err = r.RunWithLog(ctx, &log, "mount", "-o", "loop", "/tmp/a/img", "/tmp/a/mnt")
+	if err == nil || !strings.Contains(err.Error(), "exit status 32") {
+		t.Errorf("RunWithLog error = %v, want recorded error", err)
+	}
+	if want := "+ mount -o loop /tmp/a/img /tmp/a/mnt\nbusy\nexit status 32\n"; log.String() != want {
+		t.Errorf("log = %q, want %q", log.String(), want)
+	}
+	if err := r.Done(); err != nil {
+		t.Errorf("Done: %v", err)
+	}
+}
+
+func TestReplayMismatch(t *testing.T) {
+	r := NewReplay(testRecords())
+
+	err := r.Run(context.Background(), "losetup", "-a")
+	if err == nil || !strings.Contains(err.Error(), "command 1 mismatch") ||
+		!strings.Contains(err.Error(), "want: losetup -f") || !strings.Contains(err.Error(), "got:  losetup -a") {
+		t.Fatalf("Run = %v, want mismatch of command 1", err)
+	}
+	// 不一致的命令不消耗记录
+	if _, err := r.Output(context.Background(), "losetup", "-f"); err != nil {
+		t.Errorf("Output after mismatch: %v", err)
+	}
+}
+
+func TestReplayUnexpected(t *testing.T) {
+	r := NewReplay(testRecords()[:1])
+	ctx := context.Background()
+
+	if _, err := r.Output(ctx, "losetup", "-f"); err != nil {
+		t.Fatal(err)
+	}
+	err := r.Run(ctx, "umount", "/tmp/a/mnt")
+	if err == nil || !strings.Contains(err.Error(), "unexpected command 2: umount /tmp/a/mnt") {
+		t.Errorf("Run = %v, want unexpected command", err)
+	}
+	if err := r.Done(); err != nil {
+		t.Errorf("Done: %v", err)
+	}
+}
+
+func TestReplayNormalize(t *testing.T) {
+	r := NewReplay(testRecords()[2:])
+	r.Normalize = func(arg string) string {
+		if dir, ok := strings.CutPrefix(arg, "/tmp/"); ok {
+			if i := strings.Index(dir, "/"); i >= 0 {
+				return "TMP" + dir[i:]
+			}
+		}
+		return arg
+	}
+
+	err := r.Run(context.Background(), "mount", "-o", "loop", "/tmp/b/img", "/tmp/b/mnt")
+	if err == nil || strings.Contains(err.Error(), "mismatch") {
+		t.Errorf("Run = %v, want the recorded error", err)
+	}
+	if err := r.Done(); err != nil {
+		t.Errorf("Done: %v", err)
+	}
+}
+
+func TestReplayCanceled(t *testing.T) {
+	r := NewReplay(testRecords())
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := r.Run(ctx, "losetup", "-f"); err != context.Canceled {
+		t.Errorf("Run = %v, want %v", err, context.Canceled)
+	}
+	if err := r.Done(); err == nil {
+		t.Error("Done = nil, canceled command must not consume the record")
+	}
+}
+
+func TestLoadReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.rec")
+	data := `{"name":"losetup","args":["-f"],"output":"/dev/loop7\n"}
+
+{"name":"mkfs.ext3","args":["-F","/dev/loop7"]}
+`
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := LoadReplay(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Records) != 2 {
+		t.Fatalf("loaded %d records, want 2", len(r.Records))
+	}
+
+	if err := os.WriteFile(path, []byte("{\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadReplay(path); err == nil || !strings.Contains(err.Error(), "test.rec:1") {
+		t.Errorf("LoadReplay of an invalid file = %v, want error with line", err)
+	}
+}
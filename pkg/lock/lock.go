@@ -0,0 +1,169 @@
+package lock
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Info 独占锁持有者信息，写入锁文件
+type Info struct {
+	PID     int       `json:"pid"`
+	Host    string    `json:"host"`
+	Since   time.Time `json:"since"`
+	Command string    `json:"command"`
+}
+
+// running 判断持有者进程是否仍在运行，其他主机上的进程无法判断，视为运行中
+func (i *Info) running() bool {
+	if host, _ := os.Hostname(); i.Host != host {
+		return true
+	}
+	err := syscall.Kill(i.PID, 0)
+	return err == nil || err == syscall.EPERM
+}
+
+// LockedError 目标已被其他进程锁定
+type LockedError struct {
+	Path   string
+	Holder *Info // 持有者为共享锁或信息已失效时为 nil
+}
+
+func (e *LockedError) Error() string {
+	if e.Holder == nil {
+		return fmt.Sprintf("%s is in use by another kboot process", e.Path)
+	}
+	return fmt.Sprintf("%s is locked by PID %d on host %s since %s (%s)",
+		e.Path, e.Holder.PID, e.Holder.Host, e.Holder.Since.Local().Format(time.RFC3339), e.Holder.Command)
+}
+
+// Lock 基于 flock(2) 的建议锁，锁文件为目标路径旁的 <path>.lock
+//
+// 进程退出时内核自动释放 flock，残留的锁文件不会阻止之后的构建。
+type Lock struct {
+	path      string
+	file      *os.File
+	exclusive bool
+}
+
+// Path 返回目标的锁文件路径
+func Path(target string) string {
+	return filepath.Clean(target) + ".lock"
+}
+
+// Acquire 获取目标的独占锁，用于创建或修改 bootfs、镜像
+func Acquire(target string) (*Lock, error) {
+	return acquire(target, true)
+}
+
+// AcquireShared 获取目标的共享锁，用于读取 bootfs，多个读者可以同时持有
+func AcquireShared(target string) (*Lock, error) {
+	return acquire(target, false)
+}
+
+func acquire(target string, exclusive bool) (*Lock, error) {
+	path := Path(target)
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+
+	for {
+		f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open lock file: %v", err)
+		}
+
+		if err := syscall.Flock(int(f.Fd()), how|syscall.LOCK_NB); err != nil {
+			holder := readInfo(f)
+			f.Close()
+			if err != syscall.EWOULDBLOCK {
+				return nil, fmt.Errorf("failed to lock %s: %v", target, err)
+			}
+			if holder != nil && !holder.running() {
+				holder = nil
+			}
+			return nil, &LockedError{Path: target, Holder: holder}
+		}
+
+		// 加锁前锁文件可能已被上一个持有者删除，此时重新打开
+		if current, err := os.Stat(path); err == nil {
+			if opened, err := f.Stat(); err == nil && os.SameFile(current, opened) {
+				l := &Lock{path: path, file: f, exclusive: exclusive}
+				if exclusive {
+					if err := l.writeInfo(); err != nil {
+						l.Release()
+						return nil, err
+					}
+				}
+				return l, nil
+			}
+		}
+		f.Close()
+	}
+}
+
+// writeInfo 写入持有者信息，锁文件中残留的信息说明上一个持有者异常退出
+func (l *Lock) writeInfo() error {
+	if stale := readInfo(l.file); stale != nil {
+		slog.Warn("removing stale lock left by an earlier run", "path", l.path, "pid", stale.PID,
+			"host", stale.Host, "since", stale.Since.Local().Format(time.RFC3339))
+	}
+
+	host, _ := os.Hostname()
+	data, err := json.Marshal(&Info{
+		PID:     os.Getpid(),
+		Host:    host,
+		Since:   time.Now().UTC(),
+		Command: strings.Join(os.Args, " "),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode lock info: %v", err)
+	}
+
+	if err := l.file.Truncate(0); err != nil {
+		return fmt.Errorf("failed to write lock file: %v", err)
+	}
+	if _, err := l.file.WriteAt(append(data, '\n'), 0); err != nil {
+		return fmt.Errorf("failed to write lock file: %v", err)
+	}
+	return nil
+}
+
+// readInfo 读取锁文件中的持有者信息，没有信息时返回 nil
+func readInfo(f *os.File) *Info {
+	data, err := io.ReadAll(io.NewSectionReader(f, 0, 4096))
+	if err != nil || len(data) == 0 {
+		return nil
+	}
+	info := &Info{}
+	if err := json.Unmarshal(data, info); err != nil || info.PID == 0 {
+		return nil
+	}
+	return info
+}
+
+// Release 释放锁并删除锁文件，共享锁只有最后一个持有者删除锁文件
+func (l *Lock) Release() error {
+	if l == nil || l.file == nil {
+		return nil
+	}
+	defer func() {
+		l.file.Close()
+		l.file = nil
+	}()
+
+	// 删除前需要独占锁，其他进程打开旧文件后会发现文件已删除并重试
+	if l.exclusive || syscall.Flock(int(l.file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB) == nil {
+		if err := os.Remove(l.path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove lock file: %v", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,217 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// fanout 将记录发送给所有启用了该级别的 handler
+type fanout []slog.Handler
+
+func (f fanout) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, h := range f {
+		if h.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f fanout) Handle(ctx context.Context, r slog.Record) error {
+	var firstErr error
+	for _, h := range f {
+		if !h.Enabled(ctx, r.Level) {
+			continue
+		}
+		if err := h.Handle(ctx, r.Clone()); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (f fanout) WithAttrs(attrs []slog.Attr) slog.Handler {
+	handlers := make(fanout, len(f))
+	for i, h := range f {
+		handlers[i] = h.WithAttrs(attrs)
+	}
+	return handlers
+}
+
+func (f fanout) WithGroup(name string) slog.Handler {
+	handlers := make(fanout, len(f))
+	for i, h := range f {
+		handlers[i] = h.WithGroup(name)
+	}
+	return handlers
+}
+
+// textHandler 终端文本格式：消息后跟 key=value，WARN、ERROR 加前缀，不输出时间
+//
+// 外部命令的输出由 CommandOutput 原样写入终端，这里不重复输出。
+type textHandler struct {
+	console *statusConsole
+	level   slog.Level
+	attrs   string // WithAttrs 预先格式化的属性
+	group   string
+}
+
+func (h *textHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level
+}
+
+func (h *textHandler) Handle(_ context.Context, r slog.Record) error {
+	var sb strings.Builder
+	switch {
+	case r.Level >= slog.LevelError:
+		sb.WriteString("Error: ")
+	case r.Level >= slog.LevelWarn:
+		sb.WriteString("Warning: ")
+	}
+	sb.WriteString(r.Message)
+	sb.WriteString(h.attrs)
+
+	output := false
+	r.Attrs(func(a slog.Attr) bool {
+		if a.Key == StreamKey {
+			output = true
+			return false
+		}
+		writeAttr(&sb, h.group, a)
+		return true
+	})
+	if output {
+		return nil
+	}
+	sb.WriteByte('\n')
+
+	return h.console.writeLine(sb.String())
+}
+
+func (h *textHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	var sb strings.Builder
+	for _, a := range attrs {
+		writeAttr(&sb, h.group, a)
+	}
+	clone := *h
+	clone.attrs += sb.String()
+	return &clone
+}
+
+func (h *textHandler) WithGroup(name string) slog.Handler {
+	clone := *h
+	clone.group += name + "."
+	return &clone
+}
+
+// collector 记录 WARN 及以上级别的消息，不受终端日志级别影响，WithAttrs 返回的 handler 共享记录
+type collector struct {
+	records *records
+	attrs   string
+	group   string
+}
+
+// records 收集到的消息
+type records struct {
+	mu       sync.Mutex
+	messages []string
+}
+
+func (c *collector) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= slog.LevelWarn
+}
+
+func (c *collector) Handle(_ context.Context, r slog.Record) error {
+	var sb strings.Builder
+	sb.WriteString(r.Message)
+	sb.WriteString(c.attrs)
+	r.Attrs(func(a slog.Attr) bool {
+		writeAttr(&sb, c.group, a)
+		return true
+	})
+
+	c.records.mu.Lock()
+	defer c.records.mu.Unlock()
+	c.records.messages = append(c.records.messages, sb.String())
+	return nil
+}
+
+func (c *collector) WithAttrs(attrs []slog.Attr) slog.Handler {
+	var sb strings.Builder
+	for _, a := range attrs {
+		writeAttr(&sb, c.group, a)
+	}
+	clone := *c
+	clone.attrs += sb.String()
+	return &clone
+}
+
+func (c *collector) WithGroup(name string) slog.Handler {
+	clone := *c
+	clone.group += name + "."
+	return &clone
+}
+
+func (c *collector) messages() []string {
+	c.records.mu.Lock()
+	defer c.records.mu.Unlock()
+	return append([]string(nil), c.records.messages...)
+}
+
+// statusConsole 终端输出，最后一行可以是随时替换的状态行（进度）
+type statusConsole struct {
+	mu     sync.Mutex
+	w      io.Writer
+	status string
+}
+
+// clearLine 回到行首并清除该行
+const clearLine = "\r\033[K"
+
+// writeLine 在状态行之上写入一行日志，之后重新显示状态行
+func (c *statusConsole) writeLine(line string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.status != "" {
+		line = clearLine + line + c.status
+	}
+	_, err := io.WriteString(c.w, line)
+	return err
+}
+
+func (c *statusConsole) setStatus(status string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if status == c.status {
+		return
+	}
+	io.WriteString(c.w, clearLine+status)
+	c.status = status
+}
+
+// writeAttr 写入 " key=value"，值含空白或引号时加引号
+func writeAttr(sb *strings.Builder, group string, a slog.Attr) {
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+	value := a.Value.Resolve()
+	if value.Kind() == slog.KindGroup {
+		for _, ga := range value.Group() {
+			writeAttr(sb, group+a.Key+".", ga)
+		}
+		return
+	}
+
+	s := value.String()
+	if s == "" || strings.ContainsAny(s, " \t\n\"=") {
+		s = strconv.Quote(s)
+	}
+	fmt.Fprintf(sb, " %s%s=%s", group, a.Key, s)
+}
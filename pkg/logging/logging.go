@@ -0,0 +1,232 @@
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+)
+
+// 外部命令输出记录的属性
+const (
+	CommandKey = "cmd"    // 命令名称
+	StreamKey  = "stream" // stdout、stderr
+)
+
+// Options 终端日志配置
+type Options struct {
+	Level  slog.Level // 终端输出的最低级别
+	Format string     // text（默认）或 json
+	Writer io.Writer  // 终端日志的输出位置，默认 os.Stdout
+}
+
+var (
+	mu       sync.Mutex
+	options  Options
+	terminal slog.Handler
+	files    = map[io.WriteCloser]slog.Handler{}
+	console  *statusConsole                    // text 格式的终端，用于显示状态行
+	status   bool                              // 是否启用了状态行
+	warnings = &collector{records: &records{}} // 所有 WARN 及以上级别的消息
+)
+
+func init() {
+	Setup(Options{})
+}
+
+// Setup 设置终端日志并替换 slog 的默认 logger
+//
+// text 格式面向用户，外部命令的输出原样写入终端；json 格式每条日志一行，
+// 外部命令的输出作为 DEBUG 级别的记录输出。
+func Setup(opts Options) error {
+	if opts.Format == "" {
+		opts.Format = "text"
+	}
+	if opts.Writer == nil {
+		opts.Writer = os.Stdout
+	}
+
+	var handler slog.Handler
+	var con *statusConsole
+	switch opts.Format {
+	case "text":
+		con = &statusConsole{w: opts.Writer}
+		handler = &textHandler{console: con, level: opts.Level}
+	case "json":
+		handler = slog.NewJSONHandler(opts.Writer, &slog.HandlerOptions{Level: opts.Level})
+	default:
+		return fmt.Errorf("unknown log format: %s (supported: text, json)", opts.Format)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	options, terminal, console, status = opts, handler, con, false
+	install()
+	return nil
+}
+
+// Configure 根据命令行参数设置终端日志
+//
+// verbose 等同于 --log-level debug，quiet 等同于 --log-level warn；
+// json 格式的日志写入标准错误，标准输出只保留构建结果。
+func Configure(level string, verbose, quiet bool, format string) error {
+	switch {
+	case verbose && quiet:
+		return fmt.Errorf("--verbose and --quiet are mutually exclusive")
+	case verbose:
+		level = "debug"
+	case quiet:
+		level = "warn"
+	}
+
+	opts := Options{Format: format}
+	if level != "" {
+		l, err := ParseLevel(level)
+		if err != nil {
+			return err
+		}
+		opts.Level = l
+	}
+	if format == "json" {
+		opts.Writer = os.Stderr
+	}
+	return Setup(opts)
+}
+
+// ParseLevel 解析 debug、info、warn、error
+func ParseLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return 0, fmt.Errorf("unknown log level: %s (supported: debug, info, warn, error)", s)
+	}
+	return level, nil
+}
+
+// Open 将所有级别的日志（包括外部命令的每行输出）及时间戳写入 w，返回关闭函数，关闭时同时关闭 w
+func Open(w io.WriteCloser) func() error {
+	mu.Lock()
+	files[w] = slog.NewTextHandler(w, &slog.HandlerOptions{Level: slog.LevelDebug})
+	install()
+	mu.Unlock()
+
+	return func() error {
+		mu.Lock()
+		delete(files, w)
+		install()
+		mu.Unlock()
+		return w.Close()
+	}
+}
+
+// Warnings 返回到目前为止输出的警告及错误日志，格式为 "消息 key=value"
+func Warnings() []string {
+	return warnings.messages()
+}
+
+// install 将终端及日志文件的 handler 设置为默认 logger，调用者持有 mu
+func install() {
+	handlers := []slog.Handler{terminal, warnings}
+	for _, h := range files {
+		handlers = append(handlers, h)
+	}
+	slog.SetDefault(slog.New(fanout(handlers)))
+}
+
+// CommandOutput 返回外部命令的标准输出及标准错误
+//
+// 每行输出作为 DEBUG 级别的记录写入日志；terminal 为 true 且终端为 text 格式、
+// 级别不高于 INFO 时同时原样写入终端。命令结束后调用 flush 输出最后不完整的行。
+func CommandOutput(name string, terminal bool) (stdout, stderr io.Writer, flush func()) {
+	mu.Lock()
+	passthrough := terminal && options.Format == "text" && options.Level <= slog.LevelInfo && !status
+	mu.Unlock()
+
+	out := &lineWriter{name: name, stream: "stdout"}
+	errOut := &lineWriter{name: name, stream: "stderr"}
+	flush = func() {
+		out.flush()
+		errOut.flush()
+	}
+	if !passthrough {
+		return out, errOut, flush
+	}
+	return io.MultiWriter(os.Stdout, out), io.MultiWriter(os.Stderr, errOut), flush
+}
+
+// EnableStatus 在终端为 text 格式、INFO 级别且输出到终端设备时启用状态行，返回是否启用
+//
+// 启用后外部命令的输出不再原样写入终端（仍写入日志文件），由调用者通过 SetStatus 显示进度。
+func EnableStatus() bool {
+	mu.Lock()
+	defer mu.Unlock()
+
+	status = console != nil && options.Level == slog.LevelInfo && isTerminal(options.Writer)
+	return status
+}
+
+// SetStatus 替换终端最后一行显示的状态，空字符串清除状态行；未启用状态行时忽略
+func SetStatus(line string) {
+	mu.Lock()
+	con := console
+	enabled := status
+	mu.Unlock()
+
+	if enabled {
+		con.setStatus(line)
+	}
+}
+
+// isTerminal 判断 w 是否为终端设备
+func isTerminal(w io.Writer) bool {
+	file, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// lineWriter 将写入的内容按行记录到日志，\r 同样作为行结束（进度输出）
+type lineWriter struct {
+	name   string
+	stream string
+
+	mu  sync.Mutex
+	buf []byte
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.buf = append(w.buf, p...)
+	for {
+		i := strings.IndexAny(string(w.buf), "\r\n")
+		if i < 0 {
+			break
+		}
+		w.emit(string(w.buf[:i]))
+		w.buf = w.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+func (w *lineWriter) flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.buf) > 0 {
+		w.emit(string(w.buf))
+		w.buf = nil
+	}
+}
+
+// emit 记录一行，忽略空行
+func (w *lineWriter) emit(line string) {
+	if strings.TrimSpace(line) == "" {
+		return
+	}
+	slog.Debug(line, CommandKey, w.name, StreamKey, w.stream)
+}
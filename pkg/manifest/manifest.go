@@ -0,0 +1,209 @@
+package manifest
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/rivsidn/kdev_bootstrap/pkg/config"
+	"github.com/rivsidn/kdev_bootstrap/pkg/dpkg"
+	"github.com/rivsidn/kdev_bootstrap/pkg/metrics"
+	"github.com/rivsidn/kdev_bootstrap/pkg/version"
+)
+
+// SchemaVersion 清单格式版本，字段发生不兼容变化时递增
+const SchemaVersion = 1
+
+// BootfsPath 清单在 bootfs 中的路径
+const BootfsPath = "etc/kboot/manifest.json"
+
+// Manifest bootfs 构建清单
+type Manifest struct {
+	SchemaVersion int       `json:"schema_version"`
+	Tool          Tool      `json:"tool"`
+	Host          Host      `json:"host"`
+	StartTime     time.Time `json:"start_time"`
+	EndTime       time.Time `json:"end_time"`
+
+	Distribution string `json:"distribution"`
+	Version      string `json:"version"`
+	Suite        string `json:"suite"`
+	Arch         string `json:"arch"`
+	Mirror       string `json:"mirror"`
+
+	// Config 保存到 bootstrap.conf 的配置，段名 -> 键值
+	Config map[string]map[string]string `json:"config"`
+	// Debootstrap debootstrap 完整命令行
+	Debootstrap []string `json:"debootstrap"`
+	// Packages dpkg 数据库中已安装的软件包
+	Packages []dpkg.Package `json:"packages"`
+	// Slim 精简规则及节省的空间
+	Slim []SlimResult `json:"slim,omitempty"`
+	// Metrics 各阶段及步骤的耗时，截至写入清单时
+	Metrics *metrics.Metrics `json:"metrics,omitempty"`
+	// History 构建之后的软件包变更
+	History []Change `json:"history,omitempty"`
+}
+
+// Change 构建之后通过 kboot bootfs install/remove 进行的软件包变更
+type Change struct {
+	Time      time.Time `json:"time"`
+	Operation string    `json:"operation"` // install、remove
+	Packages  []string  `json:"packages"`
+	Command   []string  `json:"command"`
+	User      string    `json:"user,omitempty"`
+}
+
+// SlimResult 精简规则删除的字节数
+type SlimResult struct {
+	Rule       string `json:"rule"`
+	BytesSaved int64  `json:"bytes_saved"`
+	// Exclude、Include 规则删除及保留的路径模式（dpkg path-exclude 语法），校验时据此忽略删除的文件
+	Exclude []string `json:"exclude,omitempty"`
+	Include []string `json:"include,omitempty"`
+}
+
+// Tool 构建工具信息
+type Tool struct {
+	Name      string `json:"name"`
+	Version   string `json:"version"`
+	GoVersion string `json:"go_version"`
+}
+
+// Host 构建主机信息
+type Host struct {
+	Hostname string `json:"hostname"`
+	OS       string `json:"os,omitempty"`
+	Kernel   string `json:"kernel,omitempty"`
+	User     string `json:"user,omitempty"`
+}
+
+// New 创建构建清单，记录工具及主机信息
+func New(tool string, start time.Time) *Manifest {
+	return &Manifest{
+		SchemaVersion: SchemaVersion,
+		Tool: Tool{
+			Name:      tool,
+			Version:   version.Get(),
+			GoVersion: runtime.Version(),
+		},
+		Host:      currentHost(),
+		StartTime: start.UTC(),
+	}
+}
+
+// SetConfig 记录解析后的配置
+func (m *Manifest) SetConfig(cfg *config.Config) error {
+	sections, err := cfg.Sections()
+	if err != nil {
+		return err
+	}
+
+	m.Distribution = cfg.Distribution
+	m.Version = cfg.Version
+	m.Suite = cfg.GetSuite()
+	m.Arch = cfg.ArchCurrent
+	m.Mirror = cfg.Mirror
+	m.Config = sections
+	return nil
+}
+
+// LoadPackages 从 bootfs 的 dpkg 数据库读取已安装的软件包
+func (m *Manifest) LoadPackages(bootfsPath string) error {
+	packages, err := dpkg.ReadInstalled(bootfsPath)
+	if err != nil {
+		return err
+	}
+	m.Packages = packages
+	return nil
+}
+
+// SidecarPath 返回 bootfs 旁的清单路径
+func SidecarPath(bootfsPath string) string {
+	return filepath.Clean(bootfsPath) + ".manifest.json"
+}
+
+// Marshal 返回清单文件的内容
+func (m *Manifest) Marshal() ([]byte, error) {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode manifest: %v", err)
+	}
+	return append(data, '\n'), nil
+}
+
+// Save 将清单写入 bootfs 内及 bootfs 旁
+func (m *Manifest) Save(bootfsPath string) error {
+	data, err := m.Marshal()
+	if err != nil {
+		return err
+	}
+
+	inside := filepath.Join(bootfsPath, BootfsPath)
+	if err := os.MkdirAll(filepath.Dir(inside), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %v", err)
+	}
+	for _, path := range []string{inside, SidecarPath(bootfsPath)} {
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			return fmt.Errorf("failed to write manifest %s: %v", path, err)
+		}
+	}
+
+	return nil
+}
+
+// Load 读取清单文件
+func Load(path string) (*Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %v", err)
+	}
+
+	m := &Manifest{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest %s: %v", path, err)
+	}
+	return m, nil
+}
+
+// LoadFromBootfs 读取 bootfs 内的清单
+func LoadFromBootfs(bootfsPath string) (*Manifest, error) {
+	return Load(filepath.Join(bootfsPath, BootfsPath))
+}
+
+// CurrentUser 返回执行命令的用户，sudo 时为 sudo 前的用户
+func CurrentUser() string {
+	if name := os.Getenv("SUDO_USER"); name != "" {
+		return name
+	}
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return ""
+}
+
+// currentHost 收集构建主机信息
+func currentHost() Host {
+	host := Host{}
+	host.Hostname, _ = os.Hostname()
+
+	if data, err := os.ReadFile("/proc/sys/kernel/osrelease"); err == nil {
+		host.Kernel = strings.TrimSpace(string(data))
+	}
+	if data, err := os.ReadFile("/etc/os-release"); err == nil {
+		for _, line := range strings.Split(string(data), "\n") {
+			if value, ok := strings.CutPrefix(line, "PRETTY_NAME="); ok {
+				host.OS = strings.Trim(value, `"`)
+			}
+		}
+	}
+
+	host.User = CurrentUser()
+
+	return host
+}
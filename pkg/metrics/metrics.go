@@ -0,0 +1,139 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/rivsidn/kdev_bootstrap/pkg/progress"
+)
+
+// Phase 阶段或阶段内步骤的耗时，同名的多次执行累加
+type Phase struct {
+	Stage    string        `json:"stage"`
+	Step     string        `json:"step,omitempty"`
+	Duration time.Duration `json:"duration_ns"`
+	Failed   bool          `json:"failed,omitempty"`
+}
+
+// Metrics 一次构建的耗时
+//
+// Stages 为超时配置中的阶段（如 debootstrap、qemu_copy），Steps 为阶段内根据命令输出划分的步骤
+// （如 debootstrap 的 retrieving、unpacking，docker build 的 context）；阶段之外的检查、配置等
+// 计入 Duration 但不单独列出。
+type Metrics struct {
+	Start    time.Time     `json:"start"`
+	Duration time.Duration `json:"duration_ns"`
+	Stages   []Phase       `json:"stages"`
+	Steps    []Phase       `json:"steps,omitempty"`
+}
+
+// Recorder 从进度事件统计各阶段及步骤的耗时，实现 progress.Reporter
+type Recorder struct {
+	mu     sync.Mutex
+	start  time.Time
+	stages []Phase
+	steps  []Phase
+
+	step      int // 正在进行的步骤在 steps 中的下标，-1 表示没有
+	stepStart time.Time
+}
+
+// NewRecorder 创建耗时统计，构建的开始时间为当前时间
+func NewRecorder() *Recorder {
+	return &Recorder{start: time.Now(), step: -1}
+}
+
+func (r *Recorder) Report(e progress.Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := e.Time
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	switch e.Kind {
+	case progress.KindStageStart:
+		r.endStep(now)
+	case progress.KindStageDone:
+		r.endStep(now)
+		i := add(&r.stages, e.Stage, "")
+		r.stages[i].Duration += e.Elapsed
+		r.stages[i].Failed = r.stages[i].Failed || e.Failed
+	default:
+		// 进度事件同样带有步骤，如 rsync 不单独报告 copying 步骤
+		if e.Step == "" || (r.step >= 0 && r.steps[r.step].Stage == e.Stage && r.steps[r.step].Step == e.Step) {
+			return
+		}
+		r.endStep(now)
+		r.step = add(&r.steps, e.Stage, e.Step)
+		r.stepStart = now
+	}
+}
+
+// endStep 结束正在进行的步骤
+func (r *Recorder) endStep(now time.Time) {
+	if r.step < 0 {
+		return
+	}
+	r.steps[r.step].Duration += now.Sub(r.stepStart)
+	r.step = -1
+}
+
+// add 返回阶段或步骤在 phases 中的下标，不存在时追加
+func add(phases *[]Phase, stage, step string) int {
+	for i, phase := range *phases {
+		if phase.Stage == stage && phase.Step == step {
+			return i
+		}
+	}
+	*phases = append(*phases, Phase{Stage: stage, Step: step})
+	return len(*phases) - 1
+}
+
+// Metrics 返回到目前为止的耗时
+func (r *Recorder) Metrics() Metrics {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return Metrics{
+		Start:    r.start.UTC(),
+		Duration: time.Since(r.start),
+		Stages:   append([]Phase(nil), r.stages...),
+		Steps:    append([]Phase(nil), r.steps...),
+	}
+}
+
+// Print 输出各阶段及其步骤的耗时，其余为阶段之外的时间
+func (m Metrics) Print(w io.Writer) {
+	fmt.Fprintf(w, "Timing:\n")
+
+	var staged time.Duration
+	for _, stage := range m.Stages {
+		staged += stage.Duration
+		line := fmt.Sprintf("   %-16s %s", stage.Stage, formatDuration(stage.Duration))
+		if stage.Failed {
+			line += " (failed)"
+		}
+		fmt.Fprintln(w, line)
+		for _, step := range m.Steps {
+			if step.Stage == stage.Stage {
+				fmt.Fprintf(w, "     %-14s %s\n", step.Step, formatDuration(step.Duration))
+			}
+		}
+	}
+	if other := m.Duration - staged; other.Round(100*time.Millisecond) > 0 {
+		fmt.Fprintf(w, "   %-16s %s\n", "other", formatDuration(other))
+	}
+	fmt.Fprintf(w, "   %-16s %s\n", "total", formatDuration(m.Duration))
+}
+
+// formatDuration 一分钟以内保留 0.1 秒，否则保留到秒
+func formatDuration(d time.Duration) string {
+	if d < time.Minute {
+		return d.Round(100 * time.Millisecond).String()
+	}
+	return d.Round(time.Second).String()
+}
@@ -0,0 +1,49 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// labelEscaper 转义 Prometheus 标签值中的 \、" 及换行
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// Textfile 返回 Prometheus 文本格式的耗时，供 node_exporter 的 textfile collector 读取
+//
+// tool、target 作为标签区分构建命令及产物，failed 表示构建是否失败。
+func (m Metrics) Textfile(tool, target string, failed bool) []byte {
+	labels := fmt.Sprintf(`tool="%s",target="%s"`, labelEscaper.Replace(tool), labelEscaper.Replace(target))
+
+	var buf bytes.Buffer
+	metric := func(name, help string) {
+		fmt.Fprintf(&buf, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+	}
+
+	metric("kboot_build_duration_seconds", "Duration of the last build.")
+	fmt.Fprintf(&buf, "kboot_build_duration_seconds{%s} %g\n", labels, m.Duration.Seconds())
+
+	success := 1
+	if failed {
+		success = 0
+	}
+	metric("kboot_build_success", "Whether the last build succeeded.")
+	fmt.Fprintf(&buf, "kboot_build_success{%s} %d\n", labels, success)
+
+	metric("kboot_build_start_timestamp_seconds", "Start time of the last build.")
+	fmt.Fprintf(&buf, "kboot_build_start_timestamp_seconds{%s} %d\n", labels, m.Start.Unix())
+
+	metric("kboot_build_stage_duration_seconds", "Duration of each stage of the last build.")
+	for _, stage := range m.Stages {
+		fmt.Fprintf(&buf, "kboot_build_stage_duration_seconds{%s,stage=\"%s\"} %g\n",
+			labels, labelEscaper.Replace(stage.Stage), stage.Duration.Seconds())
+	}
+
+	metric("kboot_build_step_duration_seconds", "Duration of each step within a stage of the last build.")
+	for _, step := range m.Steps {
+		fmt.Fprintf(&buf, "kboot_build_step_duration_seconds{%s,stage=\"%s\",step=\"%s\"} %g\n",
+			labels, labelEscaper.Replace(step.Stage), labelEscaper.Replace(step.Step), step.Duration.Seconds())
+	}
+
+	return buf.Bytes()
+}
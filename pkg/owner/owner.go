@@ -0,0 +1,104 @@
+package owner
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+)
+
+// Owner 产物的属主
+type Owner struct {
+	UID int
+	GID int
+}
+
+func (o *Owner) String() string {
+	return fmt.Sprintf("%d:%d", o.UID, o.GID)
+}
+
+// Resolve 返回产物的属主
+//
+// spec 为 USER[:GROUP]，用户、组可以是名称或数字，省略组时使用用户的主组；spec 为空时使用 sudo
+// 设置的 SUDO_UID、SUDO_GID。都没有时（直接以 root 执行）返回 nil，产物保持当前用户。
+func Resolve(spec string) (*Owner, error) {
+	if spec == "" {
+		return fromSudo()
+	}
+
+	name, group, hasGroup := strings.Cut(spec, ":")
+	o := &Owner{}
+	uid, gid, err := lookupUser(name)
+	if err != nil {
+		return nil, err
+	}
+	o.UID, o.GID = uid, gid
+
+	if hasGroup {
+		if o.GID, err = lookupGroup(group); err != nil {
+			return nil, err
+		}
+	} else if gid < 0 {
+		return nil, fmt.Errorf("invalid --owner %s: unknown primary group of uid %d, use UID:GID", spec, uid)
+	}
+	return o, nil
+}
+
+// fromSudo 读取 sudo 设置的 SUDO_UID、SUDO_GID
+func fromSudo() (*Owner, error) {
+	uidValue, gidValue := os.Getenv("SUDO_UID"), os.Getenv("SUDO_GID")
+	if uidValue == "" || gidValue == "" {
+		return nil, nil
+	}
+
+	uid, err := strconv.Atoi(uidValue)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SUDO_UID %s", uidValue)
+	}
+	gid, err := strconv.Atoi(gidValue)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SUDO_GID %s", gidValue)
+	}
+	return &Owner{UID: uid, GID: gid}, nil
+}
+
+// lookupUser 返回用户的 uid 及主组，数字 uid 不存在于 passwd 时主组为 -1
+func lookupUser(name string) (int, int, error) {
+	u, err := user.Lookup(name)
+	if err != nil {
+		uid, convErr := strconv.Atoi(name)
+		if convErr != nil {
+			return 0, 0, fmt.Errorf("unknown user %s", name)
+		}
+		if u, err = user.LookupId(name); err != nil {
+			return uid, -1, nil
+		}
+	}
+
+	uid, err := strconv.Atoi(u.Uid)
+	if err != nil {
+		return 0, 0, fmt.Errorf("unsupported uid %s of user %s", u.Uid, name)
+	}
+	gid, err := strconv.Atoi(u.Gid)
+	if err != nil {
+		return 0, 0, fmt.Errorf("unsupported gid %s of user %s", u.Gid, name)
+	}
+	return uid, gid, nil
+}
+
+// lookupGroup 返回组的 gid，数字直接使用
+func lookupGroup(name string) (int, error) {
+	if gid, err := strconv.Atoi(name); err == nil {
+		return gid, nil
+	}
+	g, err := user.LookupGroup(name)
+	if err != nil {
+		return 0, fmt.Errorf("unknown group %s", name)
+	}
+	gid, err := strconv.Atoi(g.Gid)
+	if err != nil {
+		return 0, fmt.Errorf("unsupported gid %s of group %s", g.Gid, name)
+	}
+	return gid, nil
+}
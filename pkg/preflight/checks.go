@@ -0,0 +1,401 @@
+package preflight
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/rivsidn/kdev_bootstrap/pkg/diagnose"
+	"github.com/rivsidn/kdev_bootstrap/pkg/utils"
+)
+
+// commandTimeout 检查中执行命令（如 docker info）的超时
+const commandTimeout = 10 * time.Second
+
+// 所有检查，kboot doctor 按此顺序执行
+var (
+	CheckDebootstrap = Check{Name: "debootstrap", Run: checkDebootstrap}
+	CheckKeyring     = Check{Name: "keyring", Run: checkKeyring}
+	CheckBinfmt      = Check{Name: "binfmt", Run: checkBinfmt}
+	CheckQemuImg     = Check{Name: "qemu-img", Run: checkCommand("qemu-img", "qemu-utils")}
+	CheckMkfs        = Check{Name: "mkfs", Run: checkMkfs}
+	CheckLoop        = Check{Name: "loop", Run: checkLoop}
+	CheckRsync       = Check{Name: "rsync", Run: checkRsync}
+	CheckDocker      = Check{Name: "docker", Run: checkDocker}
+	CheckKVM         = Check{Name: "kvm", Run: checkKVM}
+	CheckNetwork     = Check{Name: "bridge/tap", Run: checkNetwork}
+	CheckDisk        = Check{Name: "disk", Run: checkDisk}
+)
+
+// 各构建工具在构建前执行的检查
+var (
+	BootfsChecks = []Check{CheckDebootstrap, CheckKeyring, CheckBinfmt, CheckDisk}
+	DockerChecks = []Check{CheckDocker, CheckDisk}
+	QemuChecks   = []Check{CheckQemuImg, CheckMkfs, CheckLoop, CheckRsync, CheckDisk}
+	AllChecks    = []Check{
+		CheckDebootstrap, CheckKeyring, CheckBinfmt, CheckQemuImg, CheckMkfs, CheckLoop,
+		CheckRsync, CheckDocker, CheckKVM, CheckNetwork, CheckDisk,
+	}
+)
+
+// 构建需要的空间估算
+const (
+	BootfsEstimate = 2 << 30 // buildd 变体加上开发工具约 1.5GiB
+)
+
+func pass(format string, args ...any) Result {
+	return Result{Status: StatusPass, Detail: fmt.Sprintf(format, args...)}
+}
+
+func skip(format string, args ...any) Result {
+	return Result{Status: StatusSkip, Detail: fmt.Sprintf(format, args...)}
+}
+
+func warn(hint, format string, args ...any) Result {
+	return Result{Status: StatusWarn, Detail: fmt.Sprintf(format, args...), Hint: hint}
+}
+
+func fail(hint, format string, args ...any) Result {
+	return Result{Status: StatusFail, Detail: fmt.Sprintf(format, args...), Hint: hint}
+}
+
+// output 执行命令并返回去掉首尾空白的输出
+func output(ctx context.Context, name string, args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, commandTimeout)
+	defer cancel()
+	out, err := utils.RunCommandOutputContext(ctx, name, args...)
+	return strings.TrimSpace(out), err
+}
+
+// checkCommand 检查命令是否已安装
+func checkCommand(name, pkg string) func(context.Context, Options) Result {
+	return func(context.Context, Options) Result {
+		path, err := lookPath(name)
+		if err != nil {
+			return fail("sudo apt install "+pkg, "%s not found", name)
+		}
+		return pass("%s", path)
+	}
+}
+
+// lookPath 在 PATH 中查找命令，构建时按同样的 PATH 执行
+func lookPath(name string) (string, error) {
+	return exec.LookPath(name)
+}
+
+// debootstrapDir debootstrap 脚本所在目录
+func debootstrapDir() string {
+	if dir := os.Getenv("DEBOOTSTRAP_DIR"); dir != "" {
+		return dir
+	}
+	return "/usr/share/debootstrap"
+}
+
+func checkDebootstrap(ctx context.Context, opts Options) Result {
+	if _, err := lookPath("debootstrap"); err != nil {
+		return fail("sudo apt install debootstrap", "debootstrap not found")
+	}
+
+	version, err := output(ctx, "dpkg-query", "-W", "-f=${Version}", "debootstrap")
+	if err != nil || version == "" {
+		version = "unknown version"
+	}
+
+	if opts.Suite != "" {
+		script := filepath.Join(debootstrapDir(), "scripts", opts.Suite)
+		if !utils.FileExists(script) {
+			return fail("upgrade debootstrap, or link the script of the closest release, e.g. ln -s gutsy "+script,
+				"%s, no script for suite %s", version, opts.Suite)
+		}
+		return pass("%s, suite %s", version, opts.Suite)
+	}
+	return pass("%s", version)
+}
+
+// suiteKeyrings 返回 suite 脚本中 keyring 指令使用的密钥环
+func suiteKeyrings(suite string) []string {
+	file, err := os.Open(filepath.Join(debootstrapDir(), "scripts", suite))
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	var keyrings []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[0] == "keyring" {
+			keyrings = append(keyrings, fields[1])
+		}
+	}
+	return keyrings
+}
+
+func checkKeyring(ctx context.Context, opts Options) Result {
+	if opts.NoCheckGPG {
+		return skip("signature check disabled for this release")
+	}
+
+	keyrings := []string{"/usr/share/keyrings/ubuntu-archive-keyring.gpg"}
+	if opts.Suite != "" {
+		if found := suiteKeyrings(opts.Suite); len(found) > 0 {
+			keyrings = found
+		}
+	}
+
+	var missing []string
+	for _, keyring := range keyrings {
+		if utils.FileExists(keyring) {
+			return pass("%s", keyring)
+		}
+		missing = append(missing, keyring)
+	}
+	return warn("sudo apt install ubuntu-keyring; for old releases install the removed keys, see FAQ.md",
+		"%s not found, debootstrap cannot check the Release signature", strings.Join(missing, ", "))
+}
+
+// hostArch 宿主机的 Debian 架构名称
+func hostArch() string {
+	switch runtime.GOARCH {
+	case "386":
+		return "i386"
+	case "arm":
+		return "armhf"
+	case "ppc64le":
+		return "ppc64el"
+	}
+	return runtime.GOARCH
+}
+
+// qemuArch Debian 架构对应的 qemu-user 名称
+var qemuArch = map[string]string{
+	"amd64":   "x86_64",
+	"i386":    "i386",
+	"arm64":   "aarch64",
+	"armhf":   "arm",
+	"armel":   "arm",
+	"ppc64el": "ppc64le",
+	"s390x":   "s390x",
+	"riscv64": "riscv64",
+}
+
+// nativeArch 判断宿主机能否直接执行目标架构的程序
+func nativeArch(arch string) bool {
+	host := hostArch()
+	return arch == host || (host == "amd64" && arch == "i386") || (host == "arm64" && arch == "armhf")
+}
+
+func checkBinfmt(ctx context.Context, opts Options) Result {
+	if opts.Arch == "" || nativeArch(opts.Arch) {
+		return skip("not needed for %s on %s", valueOr(opts.Arch, hostArch()), hostArch())
+	}
+
+	name, ok := qemuArch[opts.Arch]
+	if !ok {
+		return warn("", "unknown architecture %s", opts.Arch)
+	}
+	hint := "sudo apt install qemu-user-static binfmt-support"
+
+	data, err := os.ReadFile("/proc/sys/fs/binfmt_misc/qemu-" + name)
+	if err != nil {
+		return fail(hint, "no binfmt handler for %s (qemu-%s)", opts.Arch, name)
+	}
+	text := string(data)
+	if !strings.HasPrefix(text, "enabled") {
+		return fail("sudo update-binfmts --enable qemu-"+name, "binfmt handler qemu-%s is disabled", name)
+	}
+	// debootstrap 在 chroot 中执行目标架构的程序，解释器需要预先打开（F 标志）
+	for _, line := range strings.Split(text, "\n") {
+		if flags, ok := strings.CutPrefix(line, "flags: "); ok && !strings.Contains(flags, "F") {
+			return warn(hint+" (the static package registers handlers with the F flag)",
+				"binfmt handler qemu-%s has no F flag, the interpreter must exist inside the bootfs", name)
+		}
+	}
+	return pass("qemu-%s", name)
+}
+
+func checkMkfs(ctx context.Context, opts Options) Result {
+	var found, missing []string
+	for _, name := range []string{"mkfs.ext3", "mkfs.ext4"} {
+		if _, err := lookPath(name); err != nil {
+			missing = append(missing, name)
+		} else {
+			found = append(found, name)
+		}
+	}
+	// 镜像格式化为 ext3
+	if len(missing) > 0 && missing[0] == "mkfs.ext3" {
+		return fail("sudo apt install e2fsprogs", "%s not found", strings.Join(missing, ", "))
+	}
+	return pass("%s", strings.Join(found, ", "))
+}
+
+func checkLoop(ctx context.Context, opts Options) Result {
+	if _, err := lookPath("losetup"); err != nil {
+		return fail("sudo apt install mount (util-linux)", "losetup not found")
+	}
+	if !utils.CheckRoot() {
+		return skip("free loop devices can only be checked as root")
+	}
+
+	device, err := output(ctx, "losetup", "-f")
+	if err != nil {
+		hint := "sudo modprobe loop; in a container run it privileged with the host /dev/loop* devices"
+		if known := diagnose.Find(err); known != nil {
+			hint = known.Hint
+		}
+		return fail(hint, "no free loop device")
+	}
+	return pass("free loop device %s", device)
+}
+
+func checkRsync(ctx context.Context, opts Options) Result {
+	path, err := lookPath("rsync")
+	if err != nil {
+		return warn("sudo apt install rsync", "rsync not found, kboot_build_qemu falls back to cp without progress")
+	}
+	return pass("%s", path)
+}
+
+func checkDocker(ctx context.Context, opts Options) Result {
+	if _, err := lookPath("docker"); err != nil {
+		return fail("install docker (sudo apt install docker.io)", "docker not found")
+	}
+
+	version, err := output(ctx, "docker", "info", "--format", "{{.ServerVersion}}")
+	if err != nil {
+		hint := "start the daemon (sudo systemctl start docker) and check access to /var/run/docker.sock"
+		if known := diagnose.Find(err); known != nil {
+			hint = known.Hint
+		}
+		return fail(hint, "cannot access the docker daemon")
+	}
+	return pass("server %s", version)
+}
+
+func checkKVM(ctx context.Context, opts Options) Result {
+	if !utils.FileExists("/dev/kvm") {
+		return warn("enable virtualization in the BIOS and load kvm_intel or kvm_amd; without KVM, QEMU runs without -enable-kvm (slow)",
+			"/dev/kvm not found")
+	}
+	if err := openReadWrite("/dev/kvm"); err != nil {
+		return warn("sudo usermod -aG kvm $USER, then log in again", "/dev/kvm: %v", err)
+	}
+	return pass("/dev/kvm")
+}
+
+// bridgeHelpers qemu-bridge-helper 的常见位置
+var bridgeHelpers = []string{"/usr/lib/qemu/qemu-bridge-helper", "/usr/libexec/qemu-bridge-helper"}
+
+// checkNetwork 检查运行 QEMU 时使用 tap 及网桥的权限，不影响构建
+func checkNetwork(ctx context.Context, opts Options) Result {
+	if err := openReadWrite("/dev/net/tun"); err != nil {
+		return warn("sudo modprobe tun; running QEMU with -netdev tap needs access to /dev/net/tun", "/dev/net/tun: %v", err)
+	}
+
+	for _, helper := range bridgeHelpers {
+		info, err := os.Stat(helper)
+		if err != nil {
+			continue
+		}
+		if info.Mode()&os.ModeSetuid == 0 && !utils.CheckRoot() {
+			return warn("sudo chmod u+s "+helper, "%s is not setuid, -netdev bridge needs root", helper)
+		}
+		data, err := os.ReadFile("/etc/qemu/bridge.conf")
+		if err != nil || !strings.Contains(string(data), "allow ") {
+			return warn("echo 'allow br0' | sudo tee -a /etc/qemu/bridge.conf", "no bridge allowed in /etc/qemu/bridge.conf")
+		}
+		return pass("/dev/net/tun, %s", helper)
+	}
+	return pass("/dev/net/tun (no qemu-bridge-helper, -netdev bridge unavailable)")
+}
+
+func checkDisk(ctx context.Context, opts Options) Result {
+	dir := valueOr(opts.Dir, ".")
+	free, err := freeSpace(dir)
+	if err != nil {
+		return skip("%v", err)
+	}
+	if opts.Need > 0 && free < uint64(opts.Need) {
+		return fail("free space (df -h "+dir+") or write the output to another filesystem",
+			"%s free in %s, about %s needed", utils.FormatBytes(int64(free)), dir, utils.FormatBytes(opts.Need))
+	}
+	if opts.Need > 0 {
+		return pass("%s free in %s, about %s needed", utils.FormatBytes(int64(free)), dir, utils.FormatBytes(opts.Need))
+	}
+	return pass("%s free in %s", utils.FormatBytes(int64(free)), dir)
+}
+
+// freeSpace 返回 dir（不存在时为最近的上级目录）所在文件系统的可用空间
+func freeSpace(dir string) (uint64, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return 0, err
+	}
+	for !utils.DirExists(dir) && dir != "/" {
+		dir = filepath.Dir(dir)
+	}
+
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return 0, fmt.Errorf("failed to get free space of %s: %v", dir, err)
+	}
+	return st.Bavail * uint64(st.Bsize), nil
+}
+
+// openReadWrite 检查设备能否以读写方式打开
+func openReadWrite(path string) error {
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		if os.IsPermission(err) {
+			return fmt.Errorf("permission denied")
+		}
+		return err
+	}
+	return file.Close()
+}
+
+// ParseSize 解析 qemu-img 的大小，如 1G、512M、10240K，无后缀为字节
+func ParseSize(size string) (int64, error) {
+	s := strings.TrimSpace(size)
+	units := map[byte]int64{'K': 1 << 10, 'M': 1 << 20, 'G': 1 << 30, 'T': 1 << 40}
+	multiplier := int64(1)
+	if s != "" {
+		if unit, ok := units[strings.ToUpper(s[len(s)-1:])[0]]; ok {
+			multiplier = unit
+			s = s[:len(s)-1]
+		}
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size: %s", size)
+	}
+	return int64(n * float64(multiplier)), nil
+}
+
+// DirSize 返回目录中普通文件的总大小，用于估算复制需要的空间
+func DirSize(dir string) int64 {
+	var total int64
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			total += info.Size()
+		}
+		return nil
+	})
+	return total
+}
+
+func valueOr(s, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}
@@ -0,0 +1,111 @@
+package preflight
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// 检查结果的状态
+const (
+	StatusPass = "pass" // 满足
+	StatusWarn = "warn" // 不影响构建，但部分功能不可用或会降级
+	StatusFail = "fail" // 构建会失败
+	StatusSkip = "skip" // 无法检查（如需要 root 权限）或不需要检查
+)
+
+// Options 检查的目标，为空的项不检查
+type Options struct {
+	Suite      string // debootstrap 的 suite，检查脚本及密钥环
+	NoCheckGPG bool   // debootstrap 不校验签名，不检查密钥环
+	Arch       string // 目标架构，与宿主机不同时检查 binfmt
+	Dir        string // 输出目录，检查可用空间
+	Need       int64  // 估算需要的空间（字节）
+}
+
+// Result 一项检查的结果
+type Result struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Detail string `json:"detail,omitempty"` // 检查到的情况，如版本、可用空间
+	Hint   string `json:"hint,omitempty"`   // 未通过时的解决方法
+}
+
+// Check 一项主机检查
+type Check struct {
+	Name string
+	Run  func(ctx context.Context, opts Options) Result
+}
+
+// Report 按检查顺序排列的结果
+type Report []Result
+
+// Run 依次执行检查
+func Run(ctx context.Context, checks []Check, opts Options) Report {
+	var report Report
+	for _, check := range checks {
+		result := check.Run(ctx, opts)
+		result.Name = check.Name
+		report = append(report, result)
+	}
+	return report
+}
+
+// Failed 是否有未通过（fail）的检查
+func (r Report) Failed() bool {
+	for _, result := range r {
+		if result.Status == StatusFail {
+			return true
+		}
+	}
+	return false
+}
+
+// Print 输出检查结果表格，之后列出未通过及警告项的解决方法
+func (r Report) Print(w io.Writer) {
+	width := len("CHECK")
+	for _, result := range r {
+		width = max(width, len(result.Name))
+	}
+
+	fmt.Fprintf(w, "%-*s  %-6s %s\n", width, "CHECK", "STATUS", "DETAIL")
+	for _, result := range r {
+		fmt.Fprintf(w, "%-*s  %-6s %s\n", width, result.Name, result.Status, result.Detail)
+	}
+
+	hints := false
+	for _, result := range r {
+		if result.Hint == "" || (result.Status != StatusFail && result.Status != StatusWarn) {
+			continue
+		}
+		if !hints {
+			fmt.Fprintln(w, "\nHints:")
+			hints = true
+		}
+		fmt.Fprintf(w, "   %s: %s\n", result.Name, result.Hint)
+	}
+}
+
+// Err 将未通过的检查合并为一个错误，警告项作为日志输出，全部通过时返回 nil
+func (r Report) Err() error {
+	var failed []string
+	for _, result := range r {
+		switch result.Status {
+		case StatusFail:
+			msg := fmt.Sprintf("%s: %s", result.Name, result.Detail)
+			if result.Hint != "" {
+				msg += "; fix: " + result.Hint
+			}
+			failed = append(failed, msg)
+		case StatusWarn:
+			slog.Warn(result.Detail, "check", result.Name, "hint", result.Hint)
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	return fmt.Errorf("preflight checks failed (run 'kboot doctor' for details, --skip-preflight to ignore):\n   %s",
+		strings.Join(failed, "\n   "))
+}
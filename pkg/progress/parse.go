@@ -0,0 +1,176 @@
+package progress
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// debootstrap 的步骤
+const (
+	StepRelease     = "release"     // 下载并校验 Release、Packages
+	StepResolving   = "resolving"   // 解析依赖
+	StepRetrieving  = "retrieving"  // 下载软件包
+	StepExtracting  = "extracting"  // 解压必需的软件包
+	StepInstalling  = "installing"  // 安装核心软件包
+	StepUnpacking   = "unpacking"   // 解包软件包
+	StepConfiguring = "configuring" // 配置软件包
+	StepDone        = "done"        // 基本系统安装完成
+)
+
+// rsync、docker 的步骤
+const (
+	StepCopying = "copying" // rsync 复制文件
+	StepContext = "context" // docker build 上传构建上下文
+	StepBuild   = "build"   // docker build 执行 Dockerfile 的指令
+)
+
+// Debootstrap 解析 debootstrap 的 I:、W:、E: 输出
+//
+// debootstrap 不输出软件包总数，下载阶段的总数未知；解包、配置以下载的软件包数量作为总数，
+// 必需及基本软件包分两轮解包、配置，计数在两轮之间累加。
+type Debootstrap struct {
+	step   string
+	counts map[string]int
+}
+
+var debootstrapLine = regexp.MustCompile(`^([IWE]): (.*)$`)
+
+func (d *Debootstrap) Parse(line string) []Event {
+	m := debootstrapLine.FindStringSubmatch(strings.TrimSpace(line))
+	if m == nil {
+		return nil
+	}
+	message := m[2]
+	switch m[1] {
+	case "W":
+		return []Event{{Kind: KindWarning, Message: message}}
+	case "E":
+		return []Event{{Kind: KindError, Message: message}}
+	}
+
+	verb, rest, _ := strings.Cut(message, " ")
+	fields := strings.Fields(strings.TrimSuffix(rest, "..."))
+
+	// 单个软件包的行：Retrieving、Validating 为 "包名 版本"，其余为 "包名..."
+	step, pkg := "", ""
+	switch verb {
+	case "Retrieving", "Validating":
+		step = StepRelease
+		if len(fields) == 2 {
+			// 每个软件包先 Retrieving 后 Validating，只按 Retrieving 计数
+			if verb == "Validating" {
+				return nil
+			}
+			step, pkg = StepRetrieving, fields[0]
+		}
+	case "Checking", "Valid":
+		step = StepResolving
+		if strings.HasPrefix(rest, "Release") {
+			step = StepRelease
+		}
+	case "Resolving", "Found":
+		step = StepResolving
+	case "Extracting", "Unpacking", "Configuring":
+		step = map[string]string{"Extracting": StepExtracting, "Unpacking": StepUnpacking, "Configuring": StepConfiguring}[verb]
+		if len(fields) == 1 {
+			pkg = fields[0]
+		}
+	case "Installing":
+		step = StepInstalling
+	case "Base":
+		step = StepDone
+	default:
+		return nil
+	}
+
+	if d.counts == nil {
+		d.counts = map[string]int{}
+	}
+	retrieved := d.counts[StepRetrieving]
+
+	var events []Event
+	if step != d.step {
+		d.step = step
+		events = append(events, Event{Kind: KindStep, Step: step, Message: message})
+	}
+	switch {
+	case step == StepDone:
+		events = append(events, Event{Kind: KindProgress, Step: step, Current: retrieved, Total: retrieved, Unit: UnitPackages, Message: message})
+	case pkg != "":
+		d.counts[step]++
+		total := retrieved
+		if step == StepRetrieving || step == StepExtracting {
+			total = 0
+		}
+		events = append(events, Event{Kind: KindProgress, Step: step, Current: d.counts[step], Total: total, Unit: UnitPackages, Message: pkg})
+	}
+	return events
+}
+
+// Rsync 解析 rsync --info=progress2 的总体进度及错误
+type Rsync struct{}
+
+// rsyncProgress 如 "  1,234,567  45%   12.34MB/s    0:00:10 (xfr#123, to-chk=456/789)"
+var rsyncProgress = regexp.MustCompile(`^\s*[\d,.]+[KMGT]?\s+(\d+)%\s+\S+\s+\d+:\d+:\d+`)
+
+func (Rsync) Parse(line string) []Event {
+	if m := rsyncProgress.FindStringSubmatch(line); m != nil {
+		percent, _ := strconv.Atoi(m[1])
+		return []Event{{Kind: KindProgress, Step: StepCopying, Current: percent, Total: 100, Unit: UnitPercent}}
+	}
+	switch {
+	case strings.HasPrefix(line, "rsync error:"):
+		return []Event{{Kind: KindError, Message: line}}
+	case strings.HasPrefix(line, "rsync:"):
+		return []Event{{Kind: KindWarning, Message: line}}
+	}
+	return nil
+}
+
+// Docker 解析 docker build 的步骤，支持传统构建器及 BuildKit 的 plain 输出
+type Docker struct {
+	step string
+}
+
+var (
+	dockerStep     = regexp.MustCompile(`^Step (\d+)/(\d+) : (.*)$`)
+	buildkitStep   = regexp.MustCompile(`^#\d+ \[(?:[\w.-]+ )?(\d+)/(\d+)\] (.*)$`)
+	buildkitErr    = regexp.MustCompile(`^(?:#\d+ )?ERROR:? (.*)$`)
+	dockerContext  = regexp.MustCompile(`^Sending build context to Docker daemon\s+(.*)$`)
+	buildkitUpload = regexp.MustCompile(`^#\d+ (?:\[internal\] load build context|transferring context:?\s*(.*))$`)
+)
+
+func (d *Docker) Parse(line string) []Event {
+	line = strings.TrimSpace(line)
+	if m := dockerContext.FindStringSubmatch(line); m != nil {
+		return d.enter(StepContext, m[1])
+	}
+	if m := buildkitUpload.FindStringSubmatch(line); m != nil {
+		return d.enter(StepContext, m[1])
+	}
+
+	m := dockerStep.FindStringSubmatch(line)
+	if m == nil {
+		m = buildkitStep.FindStringSubmatch(line)
+	}
+	if m != nil {
+		current, _ := strconv.Atoi(m[1])
+		total, _ := strconv.Atoi(m[2])
+		events := d.enter(StepBuild, "")
+		return append(events, Event{Kind: KindProgress, Step: StepBuild, Current: current, Total: total, Unit: UnitSteps, Message: m[3]})
+	}
+	if m := buildkitErr.FindStringSubmatch(line); m != nil {
+		return []Event{{Kind: KindError, Message: m[1]}}
+	}
+	return nil
+}
+
+// enter 进入步骤，步骤变化时返回 step 事件
+func (d *Docker) enter(step, message string) []Event {
+	if step == d.step {
+		return nil
+	}
+	d.step = step
+	return []Event{{Kind: KindStep, Step: step, Message: message}}
+}
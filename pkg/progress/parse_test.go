@@ -0,0 +1,166 @@
+package progress
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// parseOutput 通过 Writer 解析命令输出，返回去掉 Time、Stage 的事件
+func parseOutput(p Parser, output string) []Event {
+	var events []Event
+	w := NewWriter(ReporterFunc(func(e Event) {
+		e.Time, e.Stage = time.Time{}, ""
+		events = append(events, e)
+	}), "test", p)
+	w.Write([]byte(output))
+	w.Flush()
+	return events
+}
+
+// checkEvents 比较解析出的事件，失败时每行输出一个事件
+func checkEvents(t *testing.T, got, want []Event) {
+	t.Helper()
+	if reflect.DeepEqual(got, want) {
+		return
+	}
+	format := func(events []Event) string {
+		var lines []string
+		for _, e := range events {
+			lines = append(lines, fmt.Sprintf("  %s %s %d/%d %s %q", e.Kind, e.Step, e.Current, e.Total, e.Unit, e.Message))
+		}
+		return strings.Join(lines, "\n")
+	}
+	t.Errorf("events:\n%s\nwant:\n%s", format(got), format(want))
+}
+
+func TestDebootstrapParse(t *testing.T) {
+	output := `I: Retrieving InRelease
+I: Checking Release signature
+I: Valid Release signature (key id 790BC7277767219C42C86F933B4FE6ACC0B21F32)
+I: Retrieving Packages
+I: Validating Packages
+I: Resolving dependencies of required packages...
+I: Resolving dependencies of base packages...
+I: Found additional required dependencies: libaudit1 libcap-ng0
+I: Checking component main on http://archive.ubuntu.com/ubuntu...
+I: Retrieving adduser 3.113+nmu3ubuntu4
+I: Validating adduser 3.113+nmu3ubuntu4
+I: Retrieving apt 1.2.35
+I: Validating apt 1.2.35
+W: Failure trying to run: chroot "/tmp/bootfs" mount -t proc proc /proc
+I: Extracting adduser...
+I: Extracting apt...
+I: Installing core packages...
+I: Unpacking required packages...
+I: Unpacking adduser...
+I: Configuring required packages...
+I: Configuring adduser...
+I: Unpacking the base system...
+I: Unpacking apt...
+I: Configuring apt...
+E: Couldn't find these debs: vim
+I: Base system installed successfully.
+`
+	step := func(step, message string) Event {
+		return Event{Kind: KindStep, Step: step, Message: message}
+	}
+	pkg := func(step string, current, total int, name string) Event {
+		return Event{Kind: KindProgress, Step: step, Current: current, Total: total, Unit: UnitPackages, Message: name}
+	}
+	checkEvents(t, parseOutput(&Debootstrap{}, output), []Event{
+		step(StepRelease, "Retrieving InRelease"),
+		step(StepResolving, "Resolving dependencies of required packages..."),
+		step(StepRetrieving, "Retrieving adduser 3.113+nmu3ubuntu4"),
+		// Validating 不计数
+		pkg(StepRetrieving, 1, 0, "adduser"),
+		pkg(StepRetrieving, 2, 0, "apt"),
+		{Kind: KindWarning, Message: `Failure trying to run: chroot "/tmp/bootfs" mount -t proc proc /proc`},
+		step(StepExtracting, "Extracting adduser..."),
+		pkg(StepExtracting, 1, 0, "adduser"),
+		pkg(StepExtracting, 2, 0, "apt"),
+		step(StepInstalling, "Installing core packages..."),
+		// 解包、配置以下载的软件包数量为总数，两轮计数累加
+		step(StepUnpacking, "Unpacking required packages..."),
+		pkg(StepUnpacking, 1, 2, "adduser"),
+		step(StepConfiguring, "Configuring required packages..."),
+		pkg(StepConfiguring, 1, 2, "adduser"),
+		step(StepUnpacking, "Unpacking the base system..."),
+		pkg(StepUnpacking, 2, 2, "apt"),
+		step(StepConfiguring, "Configuring apt..."),
+		pkg(StepConfiguring, 2, 2, "apt"),
+		{Kind: KindError, Message: "Couldn't find these debs: vim"},
+		step(StepDone, "Base system installed successfully."),
+		{Kind: KindProgress, Step: StepDone, Current: 2, Total: 2, Unit: UnitPackages, Message: "Base system installed successfully."},
+	})
+}
+
+func TestDockerParse(t *testing.T) {
+	buildStep := func(current, total int, message string) Event {
+		return Event{Kind: KindProgress, Step: StepBuild, Current: current, Total: total, Unit: UnitSteps, Message: message}
+	}
+
+	t.Run("legacy", func(t *testing.T) {
+		output := `Sending build context to Docker daemon  4.096kB
+Sending build context to Docker daemon  312.5MB
+Step 1/3 : FROM scratch
+ --->
+Step 2/3 : ADD . /
+ ---> 3f1c2d0a9b8e
+Step 3/3 : CMD ["/bin/bash"]
+ ---> Running in 0a1b2c3d4e5f
+Successfully built 3f1c2d0a9b8e
+`
+		checkEvents(t, parseOutput(&Docker{}, output), []Event{
+			{Kind: KindStep, Step: StepContext, Message: "4.096kB"},
+			{Kind: KindStep, Step: StepBuild},
+			buildStep(1, 3, "FROM scratch"),
+			buildStep(2, 3, "ADD . /"),
+			buildStep(3, 3, `CMD ["/bin/bash"]`),
+		})
+	})
+
+	t.Run("buildkit", func(t *testing.T) {
+		output := `#1 [internal] load build definition from Dockerfile.123456.tmp
+#1 transferring dockerfile: 512B done
+#2 [internal] load .dockerignore
+#3 [internal] load build context
+#3 transferring context: 312.50MB 4.2s done
+#4 [1/2] ADD . /
+#4 DONE 6.1s
+#5 [2/2] WORKDIR /root
+#5 ERROR: failed to compute cache key: not found
+ERROR: failed to solve: failed to compute cache key: not found
+`
+		checkEvents(t, parseOutput(&Docker{}, output), []Event{
+			{Kind: KindStep, Step: StepContext},
+			{Kind: KindStep, Step: StepBuild},
+			buildStep(1, 2, "ADD . /"),
+			buildStep(2, 2, "WORKDIR /root"),
+			{Kind: KindError, Message: "failed to compute cache key: not found"},
+			{Kind: KindError, Message: "failed to solve: failed to compute cache key: not found"},
+		})
+	})
+}
+
+func TestRsyncParse(t *testing.T) {
+	// --info=progress2 以 \r 刷新同一行
+	output := "sending incremental file list\n" +
+		"          0   0%    0.00kB/s    0:00:00 (xfr#0, ir-chk=1000/1001)\r" +
+		"  1,234,567  45%   12.34MB/s    0:00:10 (xfr#123, to-chk=456/789)\r" +
+		"    312.50M 100%  101.23MB/s    0:00:03 (xfr#789, to-chk=0/789)\n" +
+		"rsync: [sender] send_files failed to open \"/tmp/bootfs/root/.ssh\": Permission denied (13)\n" +
+		"rsync error: some files/attrs were not transferred (see previous errors) (code 23) at main.c(1207) [sender=3.1.3]\n"
+	percent := func(current int) Event {
+		return Event{Kind: KindProgress, Step: StepCopying, Current: current, Total: 100, Unit: UnitPercent}
+	}
+	checkEvents(t, parseOutput(Rsync{}, output), []Event{
+		percent(0),
+		percent(45),
+		percent(100),
+		{Kind: KindWarning, Message: `rsync: [sender] send_files failed to open "/tmp/bootfs/root/.ssh": Permission denied (13)`},
+		{Kind: KindError, Message: "rsync error: some files/attrs were not transferred (see previous errors) (code 23) at main.c(1207) [sender=3.1.3]"},
+	})
+}
@@ -0,0 +1,159 @@
+package progress
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// 事件类型
+const (
+	KindStageStart = "stage_start" // 阶段开始
+	KindStageDone  = "stage_done"  // 阶段结束，失败时 Message 为错误信息
+	KindStep       = "step"        // 进入阶段内的步骤，如 debootstrap 的 retrieving、unpacking
+	KindProgress   = "progress"    // 步骤的进度
+	KindWarning    = "warning"     // 命令输出的警告，如 debootstrap 的 W: 行
+	KindError      = "error"       // 命令输出的错误，如 debootstrap 的 E: 行
+)
+
+// 进度的单位
+const (
+	UnitPackages = "packages"
+	UnitSteps    = "steps"
+	UnitPercent  = "percent"
+)
+
+// Event 构建的阶段及进度事件
+type Event struct {
+	Time    time.Time     `json:"time"`
+	Kind    string        `json:"kind"`
+	Stage   string        `json:"stage"`                // 超时配置中的阶段名称，如 debootstrap、docker_build
+	Step    string        `json:"step,omitempty"`       // 阶段内的步骤
+	Current int           `json:"current,omitempty"`    // 已完成的数量
+	Total   int           `json:"total,omitempty"`      // 总数，0 表示未知
+	Unit    string        `json:"unit,omitempty"`       // Current、Total 的单位
+	Message string        `json:"message,omitempty"`    // 原始输出行或说明
+	Failed  bool          `json:"failed,omitempty"`     // stage_done：阶段是否失败
+	Elapsed time.Duration `json:"elapsed_ns,omitempty"` // stage_done：阶段耗时
+}
+
+// Percent 返回完成的百分比，总数未知时返回 -1
+func (e Event) Percent() int {
+	if e.Total <= 0 {
+		return -1
+	}
+	current := e.Current
+	if current > e.Total {
+		current = e.Total
+	}
+	return current * 100 / e.Total
+}
+
+// Reporter 接收构建的进度事件，库的使用者可以实现它获取进度
+//
+// Report 可能在执行外部命令的 goroutine 中调用，实现需要并发安全且不应阻塞。
+type Reporter interface {
+	Report(e Event)
+}
+
+// ReporterFunc 将函数作为 Reporter
+type ReporterFunc func(e Event)
+
+func (f ReporterFunc) Report(e Event) { f(e) }
+
+// Discard 丢弃所有事件，未设置 Reporter 时使用
+var Discard Reporter = ReporterFunc(func(Event) {})
+
+// Or 返回 r，r 为 nil 时返回 Discard
+func Or(r Reporter) Reporter {
+	if r == nil {
+		return Discard
+	}
+	return r
+}
+
+// Multi 将事件依次报告给多个 Reporter，nil 被忽略
+func Multi(rs ...Reporter) Reporter {
+	var reporters []Reporter
+	for _, r := range rs {
+		if r != nil {
+			reporters = append(reporters, r)
+		}
+	}
+	return ReporterFunc(func(e Event) {
+		for _, r := range reporters {
+			r.Report(e)
+		}
+	})
+}
+
+// Parser 将外部命令的一行输出解析为事件，不需要设置 Time、Stage
+type Parser interface {
+	Parse(line string) []Event
+}
+
+// Writer 按行解析写入的命令输出并报告事件，\r 同样作为行结束（进度输出）
+type Writer struct {
+	reporter Reporter
+	stage    string
+	parser   Parser
+
+	mu  sync.Mutex
+	buf []byte
+}
+
+// NewWriter 创建解析 stage 阶段命令输出的 Writer，命令结束后调用 Flush
+func NewWriter(r Reporter, stage string, p Parser) *Writer {
+	return &Writer{reporter: Or(r), stage: stage, parser: p}
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.buf = append(w.buf, p...)
+	for {
+		i := strings.IndexAny(string(w.buf), "\r\n")
+		if i < 0 {
+			break
+		}
+		w.parse(string(w.buf[:i]))
+		w.buf = w.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+// Flush 解析最后不完整的行
+func (w *Writer) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.buf) > 0 {
+		w.parse(string(w.buf))
+		w.buf = nil
+	}
+}
+
+func (w *Writer) parse(line string) {
+	for _, e := range w.parser.Parse(line) {
+		e.Time = time.Now()
+		e.Stage = w.stage
+		w.reporter.Report(e)
+	}
+}
+
+// Stage 报告阶段开始，返回的函数报告阶段结束，err 不为 nil 时阶段失败
+func Stage(r Reporter, stage string) func(err error) {
+	r = Or(r)
+	start := time.Now()
+	r.Report(Event{Time: start, Kind: KindStageStart, Stage: stage})
+
+	return func(err error) {
+		e := Event{Time: time.Now(), Kind: KindStageDone, Stage: stage, Elapsed: time.Since(start)}
+		if err != nil {
+			e.Failed = true
+			e.Message = err.Error()
+		}
+		r.Report(e)
+	}
+}
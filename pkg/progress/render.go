@@ -0,0 +1,100 @@
+package progress
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+
+	"github.com/rivsidn/kdev_bootstrap/pkg/logging"
+)
+
+// statusWidth 状态行的最大宽度，超出部分截断
+const statusWidth = 79
+
+// Terminal 在终端的状态行显示当前阶段及进度，警告及错误作为日志输出
+//
+// 需要先通过 logging.EnableStatus 启用状态行。
+type Terminal struct{}
+
+// NewTerminal 创建终端进度显示
+func NewTerminal() *Terminal {
+	return &Terminal{}
+}
+
+func (t *Terminal) Report(e Event) {
+	switch e.Kind {
+	case KindStageStart:
+		logging.SetStatus(fmt.Sprintf("[%s] starting", e.Stage))
+	case KindStep:
+		logging.SetStatus(fmt.Sprintf("[%s] %s", e.Stage, e.Step))
+	case KindProgress:
+		logging.SetStatus(statusLine(e))
+	case KindWarning:
+		slog.Warn(e.Message, "stage", e.Stage)
+	case KindError:
+		slog.Error(e.Message, "stage", e.Stage)
+	case KindStageDone:
+		logging.SetStatus("")
+	}
+}
+
+// statusLine 如 "[debootstrap] unpacking 45/120 packages (37%) libc6"
+func statusLine(e Event) string {
+	line := fmt.Sprintf("[%s] %s", e.Stage, e.Step)
+	switch {
+	case e.Unit == UnitPercent:
+		line += fmt.Sprintf(" %d%%", e.Percent())
+	case e.Total > 0:
+		line += fmt.Sprintf(" %d/%d %s (%d%%)", e.Current, e.Total, e.Unit, e.Percent())
+	default:
+		line += fmt.Sprintf(" %d %s", e.Current, e.Unit)
+	}
+	if e.Message != "" {
+		line += " " + e.Message
+	}
+
+	if runes := []rune(line); len(runes) > statusWidth {
+		line = string(runes[:statusWidth-3]) + "..."
+	}
+	return line
+}
+
+// JSON 将事件逐行（JSON Lines）写入 w
+type JSON struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// NewJSON 创建输出 JSON 事件流的 Reporter
+func NewJSON(w io.Writer) *JSON {
+	return &JSON{enc: json.NewEncoder(w)}
+}
+
+func (j *JSON) Report(e Event) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.enc.Encode(e)
+}
+
+// Select 根据命令行参数选择进度的输出方式
+//
+// auto: 终端为 text 格式、INFO 级别且输出到终端设备时显示状态行，否则不显示；
+// json: 事件以 JSON Lines 写入标准错误；none: 不输出。
+func Select(mode string) (Reporter, error) {
+	switch mode {
+	case "auto":
+		if logging.EnableStatus() {
+			return NewTerminal(), nil
+		}
+		return Discard, nil
+	case "json":
+		return NewJSON(os.Stderr), nil
+	case "none":
+		return Discard, nil
+	default:
+		return nil, fmt.Errorf("unknown progress mode: %s (supported: auto, json, none)", mode)
+	}
+}
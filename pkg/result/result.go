@@ -0,0 +1,169 @@
+package result
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/rivsidn/kdev_bootstrap/pkg/cleanup"
+	"github.com/rivsidn/kdev_bootstrap/pkg/config"
+	"github.com/rivsidn/kdev_bootstrap/pkg/diagnose"
+	"github.com/rivsidn/kdev_bootstrap/pkg/logging"
+	"github.com/rivsidn/kdev_bootstrap/pkg/metrics"
+)
+
+// SchemaVersion 结果格式版本，字段发生不兼容变化时递增
+const SchemaVersion = 1
+
+// 输出格式
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// 不能由 diagnose 识别的错误代码，与 diagnose 的错误代码一样保持稳定
+const (
+	CodeUnknown     = "unknown"     // 未识别的失败，见 message
+	CodeInterrupted = "interrupted" // 收到 SIGINT、SIGTERM
+)
+
+// 产物类型
+const (
+	ArtifactBootfs      = "bootfs"         // bootfs 目录
+	ArtifactArchive     = "bootfs_archive" // 导出的 bootfs 压缩包
+	ArtifactConfig      = "config"         // bootfs 中的 bootstrap.conf
+	ArtifactManifest    = "manifest"       // bootfs 旁的构建清单
+	ArtifactCredentials = "credentials"    // root 密码文件
+	ArtifactDockerImage = "docker_image"   // Docker 镜像，Name 为镜像名称
+	ArtifactQemuImage   = "qemu_image"     // QEMU 镜像文件
+	ArtifactSBOM        = "sbom"           // SPDX、CycloneDX 文件
+	ArtifactBuildLog    = "build_log"      // 构建日志
+	ArtifactMetrics     = "metrics"        // Prometheus textfile
+	ArtifactLog         = "log"            // 其他日志，如 bootfs install 的 .packages.log
+)
+
+// Artifact 命令生成或修改的文件及镜像
+type Artifact struct {
+	Type string `json:"type"`
+	Path string `json:"path,omitempty"` // 绝对路径
+	Name string `json:"name,omitempty"` // 没有路径的产物，如 Docker 镜像名称
+}
+
+// Error 失败原因
+type Error struct {
+	Code    string `json:"code"`              // diagnose 的错误代码、unknown 或 interrupted
+	Message string `json:"message"`           // 与标准错误中 Error: 之后的内容相同
+	Summary string `json:"summary,omitempty"` // 识别出的失败原因
+	Hint    string `json:"hint,omitempty"`    // 建议的解决方法
+}
+
+// Result 命令的最终结果，--output-format json 时作为唯一内容写入标准输出
+type Result struct {
+	SchemaVersion int                          `json:"schema_version"`
+	Command       string                       `json:"command"`   // 如 kboot_build_bootfs、kboot bootfs export
+	Success       bool                         `json:"success"`   // 命令执行完成，没有出错
+	ExitCode      int                          `json:"exit_code"` // 进程退出码，如 diff 发现差异时为 1
+	Start         time.Time                    `json:"start"`
+	Duration      time.Duration                `json:"duration_ns"`
+	Artifacts     []Artifact                   `json:"artifacts"`
+	Config        map[string]map[string]string `json:"config,omitempty"`  // 解析后的配置，段名 -> 键值
+	Metrics       *metrics.Metrics             `json:"metrics,omitempty"` // 构建命令各阶段的耗时
+	Data          any                          `json:"data,omitempty"`    // 命令特有的结果，如 verify 的检查报告
+	Warnings      []string                     `json:"warnings"`
+	Error         *Error                       `json:"error,omitempty"`
+}
+
+// New 创建命令的结果，开始时间为当前时间
+func New(command string) *Result {
+	return &Result{
+		SchemaVersion: SchemaVersion,
+		Command:       command,
+		Start:         time.Now().UTC(),
+		Artifacts:     []Artifact{},
+	}
+}
+
+// CheckFormat 校验 --output-format 参数
+func CheckFormat(format string) error {
+	if format != FormatText && format != FormatJSON {
+		return fmt.Errorf("unknown output format: %s (supported: text, json)", format)
+	}
+	return nil
+}
+
+// RedirectStdout 将 os.Stdout 指向标准错误并返回原来的标准输出
+//
+// 之后所有面向用户的输出（包括外部命令的输出及确认提示）都写入标准错误，标准输出只写入结果。
+// 需要在设置日志之前调用，logging 默认的输出位置在 Setup 时确定。
+func RedirectStdout() *os.File {
+	stdout := os.Stdout
+	os.Stdout = os.Stderr
+	return stdout
+}
+
+// AddPath 添加文件产物，转换为绝对路径，不存在时忽略（如失败时尚未生成）
+func (r *Result) AddPath(typ, path string) {
+	if path == "" {
+		return
+	}
+	if _, err := os.Stat(path); err != nil {
+		return
+	}
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	r.Artifacts = append(r.Artifacts, Artifact{Type: typ, Path: path})
+}
+
+// AddName 添加没有路径的产物，如 Docker 镜像
+func (r *Result) AddName(typ, name string) {
+	r.Artifacts = append(r.Artifacts, Artifact{Type: typ, Name: name})
+}
+
+// SetConfig 记录解析后的配置
+func (r *Result) SetConfig(cfg *config.Config) {
+	if cfg == nil {
+		return
+	}
+	if sections, err := cfg.Sections(); err == nil {
+		r.Config = sections
+	}
+}
+
+// Finish 记录命令的结果，err 为 nil 时命令成功；exitCode 为进程退出码
+func (r *Result) Finish(err error, exitCode int) {
+	r.Duration = time.Since(r.Start)
+	r.ExitCode = exitCode
+	r.Warnings = logging.Warnings()
+	if r.Warnings == nil {
+		r.Warnings = []string{}
+	}
+
+	r.Success = err == nil
+	if err == nil {
+		return
+	}
+
+	r.Error = &Error{Code: CodeUnknown, Message: err.Error()}
+	switch known := diagnose.Find(err); {
+	case cleanup.Interrupted():
+		r.Error.Code = CodeInterrupted
+	case known != nil:
+		r.Error.Code = known.Code
+		r.Error.Summary = known.Summary
+		r.Error.Hint = known.Hint
+	}
+}
+
+// Write 以缩进的 JSON 写入结果
+func (r *Result) Write(w io.Writer) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode result: %v", err)
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
@@ -0,0 +1,142 @@
+package sbom
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/rivsidn/kdev_bootstrap/pkg/version"
+)
+
+// CycloneDX 1.5 JSON 文档结构，只包含用到的字段
+type cdxDocument struct {
+	BOMFormat    string         `json:"bomFormat"`
+	SpecVersion  string         `json:"specVersion"`
+	SerialNumber string         `json:"serialNumber"`
+	Version      int            `json:"version"`
+	Metadata     cdxMetadata    `json:"metadata"`
+	Components   []cdxComponent `json:"components"`
+}
+
+type cdxMetadata struct {
+	Timestamp string       `json:"timestamp"`
+	Tools     cdxTools     `json:"tools"`
+	Component cdxComponent `json:"component"`
+}
+
+type cdxTools struct {
+	Components []cdxComponent `json:"components"`
+}
+
+type cdxComponent struct {
+	Type        string        `json:"type"`
+	BOMRef      string        `json:"bom-ref,omitempty"`
+	Supplier    *cdxSupplier  `json:"supplier,omitempty"`
+	Name        string        `json:"name"`
+	Version     string        `json:"version,omitempty"`
+	Description string        `json:"description,omitempty"`
+	Licenses    []cdxLicense  `json:"licenses,omitempty"`
+	PURL        string        `json:"purl,omitempty"`
+	Properties  []cdxProperty `json:"properties,omitempty"`
+}
+
+type cdxSupplier struct {
+	Name string `json:"name"`
+}
+
+type cdxLicense struct {
+	License    *cdxLicenseChoice `json:"license,omitempty"`
+	Expression string            `json:"expression,omitempty"`
+}
+
+type cdxLicenseChoice struct {
+	ID   string `json:"id,omitempty"`
+	Name string `json:"name,omitempty"`
+}
+
+type cdxProperty struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// WriteCycloneDX 写出 CycloneDX 1.5 JSON 文档
+func (d *Document) WriteCycloneDX(w io.Writer) error {
+	uuid, err := newUUID()
+	if err != nil {
+		return err
+	}
+	doc := cdxDocument{
+		BOMFormat:    "CycloneDX",
+		SpecVersion:  "1.5",
+		SerialNumber: "urn:uuid:" + uuid,
+		Version:      1,
+		Metadata: cdxMetadata{
+			Timestamp: d.Created.Format(time.RFC3339),
+			Tools: cdxTools{Components: []cdxComponent{{
+				Type:    "application",
+				Name:    "kboot",
+				Version: version.Get(),
+			}}},
+			Component: cdxComponent{
+				Type:    "operating-system",
+				BOMRef:  d.Name,
+				Name:    d.Name,
+				Version: d.Version,
+			},
+		},
+		Components: []cdxComponent{},
+	}
+
+	for _, p := range d.Packages {
+		purl := d.PURL(p)
+		component := cdxComponent{
+			Type:        "library",
+			BOMRef:      purl,
+			Name:        p.Name,
+			Version:     p.Version,
+			Description: p.Description,
+			Licenses:    cdxLicenses(p.Licenses),
+			PURL:        purl,
+			Properties: []cdxProperty{
+				{"dpkg:architecture", p.Architecture},
+				{"dpkg:source", p.SourceName()},
+			},
+		}
+		if p.Maintainer != "" {
+			component.Supplier = &cdxSupplier{Name: p.Maintainer}
+		}
+		doc.Components = append(doc.Components, component)
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(doc)
+}
+
+// cdxLicenses 转换许可证，单个 SPDX 标识符使用 id，否则使用 SPDX 表达式
+func cdxLicenses(licenses []string) []cdxLicense {
+	if len(licenses) == 0 {
+		return nil
+	}
+
+	expression, refs := SPDXExpression(licenses)
+	switch {
+	case expression == "NOASSERTION":
+		return nil
+	case len(licenses) == 1 && len(refs) == 1 && expression == refs[0]:
+		return []cdxLicense{{License: &cdxLicenseChoice{Name: licenses[0]}}}
+	case len(refs) == 0 && isSingleID(expression):
+		return []cdxLicense{{License: &cdxLicenseChoice{ID: expression}}}
+	}
+	return []cdxLicense{{Expression: expression}}
+}
+
+// isSingleID 判断表达式是否只包含一个许可证标识符
+func isSingleID(expression string) bool {
+	for _, r := range expression {
+		if r == ' ' || r == '(' {
+			return false
+		}
+	}
+	return true
+}
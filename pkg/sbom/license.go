@@ -0,0 +1,179 @@
+package sbom
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// spdxLicenses Debian 许可证简称与 SPDX 标识符的对应关系
+var spdxLicenses = map[string]string{
+	"gpl-1":            "GPL-1.0-only",
+	"gpl-1+":           "GPL-1.0-or-later",
+	"gpl-2":            "GPL-2.0-only",
+	"gpl-2+":           "GPL-2.0-or-later",
+	"gpl-3":            "GPL-3.0-only",
+	"gpl-3+":           "GPL-3.0-or-later",
+	"lgpl-2":           "LGPL-2.0-only",
+	"lgpl-2+":          "LGPL-2.0-or-later",
+	"lgpl-2.1":         "LGPL-2.1-only",
+	"lgpl-2.1+":        "LGPL-2.1-or-later",
+	"lgpl-3":           "LGPL-3.0-only",
+	"lgpl-3+":          "LGPL-3.0-or-later",
+	"agpl-3":           "AGPL-3.0-only",
+	"agpl-3+":          "AGPL-3.0-or-later",
+	"gfdl-1.2":         "GFDL-1.2-only",
+	"gfdl-1.2+":        "GFDL-1.2-or-later",
+	"gfdl-1.3":         "GFDL-1.3-only",
+	"gfdl-1.3+":        "GFDL-1.3-or-later",
+	"apache-2.0":       "Apache-2.0",
+	"artistic":         "Artistic-1.0-Perl",
+	"artistic-2.0":     "Artistic-2.0",
+	"bsd-2-clause":     "BSD-2-Clause",
+	"bsd-3-clause":     "BSD-3-Clause",
+	"bsd-4-clause":     "BSD-4-Clause",
+	"cc0-1.0":          "CC0-1.0",
+	"expat":            "MIT",
+	"mit":              "MIT",
+	"isc":              "ISC",
+	"mpl-1.1":          "MPL-1.1",
+	"mpl-2.0":          "MPL-2.0",
+	"zlib":             "Zlib",
+	"openssl":          "OpenSSL",
+	"python-2.0":       "Python-2.0",
+	"psf-2":            "Python-2.0",
+	"x11":              "X11",
+	"curl":             "curl",
+	"bsl-1.0":          "BSL-1.0",
+	"ofl-1.1":          "OFL-1.1",
+	"unicode-dfs-2016": "Unicode-DFS-2016",
+}
+
+// commonLicenseRef 旧格式 copyright 文件中引用的 /usr/share/common-licenses 文件
+var commonLicenseRef = regexp.MustCompile(`/usr/share/common-licenses/([A-Za-z0-9.+-]*[A-Za-z0-9+])`)
+
+// licenseSeparator DEP-5 License 字段中的 or/and
+var licenseSeparator = regexp.MustCompile(`(?i)\s+(or|and)\s+|\s*,\s*(or|and)?\s*`)
+
+// ReadLicenses 读取软件包 copyright 文件中的许可证，返回去重后的 Debian 许可证简称
+//
+// 机器可读格式（DEP-5）读取所有 License 字段，旧格式只识别
+// /usr/share/common-licenses 引用。无法识别时返回 nil。
+func ReadLicenses(root, name string) []string {
+	data, err := readCopyright(root, name)
+	if err != nil {
+		return nil
+	}
+	text := string(data)
+
+	seen := make(map[string]bool)
+	var licenses []string
+	add := func(license string) {
+		license = strings.TrimSpace(license)
+		if license == "" || seen[strings.ToLower(license)] {
+			return
+		}
+		seen[strings.ToLower(license)] = true
+		licenses = append(licenses, license)
+	}
+
+	if strings.HasPrefix(text, "Format:") || strings.HasPrefix(text, "Format-Specification:") {
+		for _, line := range strings.Split(text, "\n") {
+			if value, ok := strings.CutPrefix(line, "License:"); ok {
+				add(value)
+			}
+		}
+		return licenses
+	}
+
+	for _, m := range commonLicenseRef.FindAllStringSubmatch(text, -1) {
+		add(m[1])
+	}
+	return licenses
+}
+
+// readCopyright 读取 /usr/share/doc/<name>/copyright，doc 目录可能是指向其他软件包的符号链接
+func readCopyright(root, name string) ([]byte, error) {
+	dir := filepath.Join("/usr/share/doc", name)
+	for i := 0; i < 8; i++ {
+		target, err := os.Readlink(filepath.Join(root, dir))
+		if err != nil {
+			break
+		}
+		if filepath.IsAbs(target) {
+			dir = filepath.Clean(target)
+		} else {
+			dir = filepath.Join(filepath.Dir(dir), target)
+		}
+	}
+	return os.ReadFile(filepath.Join(root, dir, "copyright"))
+}
+
+// SPDXExpression 将 Debian 许可证简称转换为 SPDX 许可证表达式
+//
+// 无对应标识符的许可证使用 LicenseRef-，返回值第二项为用到的 LicenseRef 列表。
+func SPDXExpression(licenses []string) (string, []string) {
+	var terms, refs []string
+	seen := make(map[string]bool)
+	for _, license := range licenses {
+		var parts []string
+		ops := licenseSeparator.FindAllStringSubmatch(license, -1)
+		for i, item := range licenseSeparator.Split(license, -1) {
+			// 忽略 "with ... exception"
+			item, _, _ = strings.Cut(item, " with ")
+			id, ref := spdxID(item)
+			if id == "" {
+				continue
+			}
+			if ref {
+				refs = append(refs, id)
+			}
+			if len(parts) > 0 {
+				op := "AND"
+				if m := ops[i-1]; strings.EqualFold(m[1], "or") || strings.EqualFold(m[2], "or") {
+					op = "OR"
+				}
+				parts = append(parts, op)
+			}
+			parts = append(parts, id)
+		}
+
+		term := strings.Join(parts, " ")
+		if len(parts) > 1 && len(licenses) > 1 {
+			term = "(" + term + ")"
+		}
+		// 不同简称可能对应同一个 SPDX 标识符
+		if term != "" && !seen[term] {
+			seen[term] = true
+			terms = append(terms, term)
+		}
+	}
+
+	if len(terms) == 0 {
+		return "NOASSERTION", nil
+	}
+	return strings.Join(terms, " AND "), refs
+}
+
+// spdxID 返回许可证的 SPDX 标识符，第二项表示是否为 LicenseRef
+func spdxID(license string) (string, bool) {
+	license = strings.TrimSpace(license)
+	if license == "" {
+		return "", false
+	}
+	if id, ok := spdxLicenses[strings.ToLower(license)]; ok {
+		return id, false
+	}
+
+	ref := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-':
+			return r
+		case r == '+':
+			return '-'
+		}
+		return -1
+	}, license)
+	return "LicenseRef-" + strings.Trim(ref, "-."), true
+}
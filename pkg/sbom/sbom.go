@@ -0,0 +1,185 @@
+package sbom
+
+import (
+	"bufio"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rivsidn/kdev_bootstrap/pkg/config"
+	"github.com/rivsidn/kdev_bootstrap/pkg/dpkg"
+	"github.com/rivsidn/kdev_bootstrap/pkg/version"
+)
+
+// Format SBOM 格式
+type Format string
+
+const (
+	FormatSPDX      Format = "spdx"
+	FormatCycloneDX Format = "cyclonedx"
+)
+
+// Formats 支持的全部格式
+var Formats = []Format{FormatSPDX, FormatCycloneDX}
+
+// ParseFormats 解析逗号分隔的格式列表，all 表示全部格式
+func ParseFormats(value string) ([]Format, error) {
+	var formats []Format
+	for _, item := range strings.Split(value, ",") {
+		switch strings.ToLower(strings.TrimSpace(item)) {
+		case "", "all":
+			return Formats, nil
+		case "spdx", "spdx-json":
+			formats = append(formats, FormatSPDX)
+		case "cyclonedx", "cdx":
+			formats = append(formats, FormatCycloneDX)
+		default:
+			return nil, fmt.Errorf("unsupported SBOM format: %s (supported: spdx, cyclonedx, all)", item)
+		}
+	}
+	return formats, nil
+}
+
+// Suffix 返回格式对应的文件后缀
+func (f Format) Suffix() string {
+	if f == FormatCycloneDX {
+		return ".cdx.json"
+	}
+	return ".spdx.json"
+}
+
+// Package SBOM 中的软件包
+type Package struct {
+	dpkg.Package
+	Licenses []string // copyright 文件中的许可证（Debian 简称）
+}
+
+// Document 根文件系统的软件物料清单
+type Document struct {
+	Name         string
+	Distribution string // 小写发行版名称，用作 purl 命名空间
+	Version      string
+	Arch         string
+	Created      time.Time
+	Packages     []Package
+}
+
+// Collect 从根文件系统的 dpkg 数据库及 copyright 文件收集软件包信息
+func Collect(root, name string) (*Document, error) {
+	packages, err := dpkg.ReadInstalled(root)
+	if err != nil {
+		return nil, err
+	}
+
+	doc := &Document{
+		Name:    name,
+		Created: time.Now().UTC(),
+	}
+	doc.Distribution, doc.Version, doc.Arch = distribution(root)
+
+	for _, p := range packages {
+		doc.Packages = append(doc.Packages, Package{
+			Package:  p,
+			Licenses: ReadLicenses(root, p.Name),
+		})
+	}
+
+	return doc, nil
+}
+
+// distribution 读取发行版名称、版本及架构，优先使用 bootstrap.conf
+func distribution(root string) (string, string, string) {
+	if cfg, err := config.LoadConfig(filepath.Join(root, "etc", "bootstrap.conf")); err == nil {
+		return strings.ToLower(cfg.Distribution), cfg.Version, cfg.ArchCurrent
+	}
+
+	// 非 kboot 构建的根文件系统，使用 os-release
+	f, err := os.Open(filepath.Join(root, "etc", "os-release"))
+	if err != nil {
+		return "debian", "", ""
+	}
+	defer f.Close()
+
+	id, versionID := "debian", ""
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), "=")
+		if !ok {
+			continue
+		}
+		value = strings.Trim(value, `"`)
+		switch key {
+		case "ID":
+			id = value
+		case "VERSION_ID":
+			versionID = value
+		}
+	}
+	return id, versionID, ""
+}
+
+// PURL 返回软件包的 package URL
+func (d *Document) PURL(p Package) string {
+	purl := fmt.Sprintf("pkg:deb/%s/%s@%s?arch=%s",
+		d.Distribution, url.QueryEscape(p.Name), url.QueryEscape(p.Version), url.QueryEscape(p.Architecture))
+	if p.Source != "" && p.SourceName() != p.Name {
+		purl += "&upstream=" + url.QueryEscape(p.SourceName())
+	}
+	if d.Version != "" {
+		purl += "&distro=" + url.QueryEscape(d.Distribution+"-"+d.Version)
+	}
+	return purl
+}
+
+// Write 以指定格式写出 SBOM
+func (d *Document) Write(w io.Writer, format Format) error {
+	switch format {
+	case FormatSPDX:
+		return d.WriteSPDX(w)
+	case FormatCycloneDX:
+		return d.WriteCycloneDX(w)
+	}
+	return fmt.Errorf("unsupported SBOM format: %s", format)
+}
+
+// Save 将 SBOM 保存为 ${prefix}.spdx.json、${prefix}.cdx.json，返回写入的文件
+func (d *Document) Save(prefix string, formats []Format) ([]string, error) {
+	var files []string
+	for _, format := range formats {
+		path := prefix + format.Suffix()
+		f, err := os.Create(path)
+		if err != nil {
+			return files, fmt.Errorf("failed to create SBOM file: %v", err)
+		}
+		err = d.Write(f, format)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return files, fmt.Errorf("failed to write SBOM %s: %v", path, err)
+		}
+		files = append(files, path)
+	}
+	return files, nil
+}
+
+// toolName 写入 SBOM 的工具名称
+func toolName() string {
+	return "kboot-" + version.Get()
+}
+
+// newUUID 生成随机 UUID（版本 4）
+func newUUID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", fmt.Errorf("failed to generate UUID: %v", err)
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
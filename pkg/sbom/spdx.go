@@ -0,0 +1,158 @@
+package sbom
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SPDX 2.3 JSON 文档结构，只包含用到的字段
+type spdxDocument struct {
+	SPDXVersion       string                 `json:"spdxVersion"`
+	DataLicense       string                 `json:"dataLicense"`
+	SPDXID            string                 `json:"SPDXID"`
+	Name              string                 `json:"name"`
+	DocumentNamespace string                 `json:"documentNamespace"`
+	CreationInfo      spdxCreationInfo       `json:"creationInfo"`
+	Packages          []spdxPackage          `json:"packages"`
+	Relationships     []spdxRelationship     `json:"relationships"`
+	ExtractedLicenses []spdxExtractedLicense `json:"hasExtractedLicensingInfos,omitempty"`
+}
+
+type spdxCreationInfo struct {
+	Created  string   `json:"created"`
+	Creators []string `json:"creators"`
+}
+
+type spdxPackage struct {
+	Name             string            `json:"name"`
+	SPDXID           string            `json:"SPDXID"`
+	VersionInfo      string            `json:"versionInfo,omitempty"`
+	Supplier         string            `json:"supplier,omitempty"`
+	DownloadLocation string            `json:"downloadLocation"`
+	FilesAnalyzed    bool              `json:"filesAnalyzed"`
+	Homepage         string            `json:"homepage,omitempty"`
+	SourceInfo       string            `json:"sourceInfo,omitempty"`
+	LicenseConcluded string            `json:"licenseConcluded"`
+	LicenseDeclared  string            `json:"licenseDeclared"`
+	CopyrightText    string            `json:"copyrightText"`
+	Summary          string            `json:"summary,omitempty"`
+	PrimaryPurpose   string            `json:"primaryPackagePurpose,omitempty"`
+	ExternalRefs     []spdxExternalRef `json:"externalRefs,omitempty"`
+}
+
+type spdxExternalRef struct {
+	ReferenceCategory string `json:"referenceCategory"`
+	ReferenceType     string `json:"referenceType"`
+	ReferenceLocator  string `json:"referenceLocator"`
+}
+
+type spdxRelationship struct {
+	Element string `json:"spdxElementId"`
+	Type    string `json:"relationshipType"`
+	Related string `json:"relatedSpdxElement"`
+}
+
+type spdxExtractedLicense struct {
+	LicenseID     string `json:"licenseId"`
+	Name          string `json:"name"`
+	ExtractedText string `json:"extractedText"`
+}
+
+// WriteSPDX 写出 SPDX 2.3 JSON 文档
+func (d *Document) WriteSPDX(w io.Writer) error {
+	uuid, err := newUUID()
+	if err != nil {
+		return err
+	}
+	rootID := "SPDXRef-" + spdxRef(d.Name)
+	doc := spdxDocument{
+		SPDXVersion:       "SPDX-2.3",
+		DataLicense:       "CC0-1.0",
+		SPDXID:            "SPDXRef-DOCUMENT",
+		Name:              d.Name,
+		DocumentNamespace: fmt.Sprintf("https://github.com/rivsidn/kdev_bootstrap/spdx/%s-%s", spdxRef(d.Name), uuid),
+		CreationInfo: spdxCreationInfo{
+			Created:  d.Created.Format(time.RFC3339),
+			Creators: []string{"Tool: " + toolName()},
+		},
+		Relationships: []spdxRelationship{{"SPDXRef-DOCUMENT", "DESCRIBES", rootID}},
+	}
+
+	doc.Packages = append(doc.Packages, spdxPackage{
+		Name:             d.Name,
+		SPDXID:           rootID,
+		VersionInfo:      d.Version,
+		DownloadLocation: "NOASSERTION",
+		LicenseConcluded: "NOASSERTION",
+		LicenseDeclared:  "NOASSERTION",
+		CopyrightText:    "NOASSERTION",
+		PrimaryPurpose:   "OPERATING-SYSTEM",
+	})
+
+	refs := make(map[string]bool)
+	for _, p := range d.Packages {
+		id := "SPDXRef-Package-" + spdxRef(p.Name+"-"+p.Architecture)
+		expression, licenseRefs := SPDXExpression(p.Licenses)
+		for _, ref := range licenseRefs {
+			refs[ref] = true
+		}
+
+		pkg := spdxPackage{
+			Name:             p.Name,
+			SPDXID:           id,
+			VersionInfo:      p.Version,
+			Supplier:         "NOASSERTION",
+			DownloadLocation: "NOASSERTION",
+			Homepage:         p.Homepage,
+			LicenseConcluded: "NOASSERTION",
+			LicenseDeclared:  expression,
+			CopyrightText:    "NOASSERTION",
+			Summary:          p.Description,
+			PrimaryPurpose:   "LIBRARY",
+			ExternalRefs: []spdxExternalRef{{
+				ReferenceCategory: "PACKAGE-MANAGER",
+				ReferenceType:     "purl",
+				ReferenceLocator:  d.PURL(p),
+			}},
+		}
+		if p.Maintainer != "" {
+			pkg.Supplier = "Person: " + p.Maintainer
+		}
+		if p.Source != "" {
+			pkg.SourceInfo = "built package from: " + p.Source
+		}
+		doc.Packages = append(doc.Packages, pkg)
+		doc.Relationships = append(doc.Relationships, spdxRelationship{rootID, "CONTAINS", id})
+	}
+
+	// LicenseRef- 必须在文档中声明
+	for ref := range refs {
+		doc.ExtractedLicenses = append(doc.ExtractedLicenses, spdxExtractedLicense{
+			LicenseID:     ref,
+			Name:          strings.TrimPrefix(ref, "LicenseRef-"),
+			ExtractedText: "Debian license short name, see /usr/share/doc/<package>/copyright in the root filesystem",
+		})
+	}
+	sort.Slice(doc.ExtractedLicenses, func(i, j int) bool {
+		return doc.ExtractedLicenses[i].LicenseID < doc.ExtractedLicenses[j].LicenseID
+	})
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(doc)
+}
+
+// spdxRef 将名称转换为 SPDX 标识符允许的字符（字母、数字、"."、"-"）
+func spdxRef(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-':
+			return r
+		}
+		return '-'
+	}, name)
+}
@@ -0,0 +1,223 @@
+package utils
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha512"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// cryptAlphabet crypt(3) 使用的 base64 字母表
+const cryptAlphabet = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// SHA-512 crypt 的默认、最小及最大轮数
+const (
+	sha512Rounds    = 5000
+	sha512MinRounds = 1000
+	sha512MaxRounds = 999999999
+)
+
+// RandomString 生成指定长度的随机字符串，字符取自 alphabet
+func RandomString(length int, alphabet string) (string, error) {
+	buf := make([]byte, length)
+	max := big.NewInt(int64(len(alphabet)))
+	for i := range buf {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", fmt.Errorf("failed to generate random data: %v", err)
+		}
+		buf[i] = alphabet[n.Int64()]
+	}
+	return string(buf), nil
+}
+
+// SHA512Crypt 生成 $6$ 格式的密码哈希，salt 为空时随机生成
+//
+// salt 可以以 rounds=N$ 开头指定轮数，轮数限制在 1000 到 999999999 之间，
+// 与 crypt(3) 相同，salt 超过 16 个字符时截断。
+func SHA512Crypt(password, salt string) (string, error) {
+	rounds, prefix := sha512Rounds, ""
+	if rest, ok := strings.CutPrefix(salt, "rounds="); ok {
+		value, rest, ok := strings.Cut(rest, "$")
+		if n, err := strconv.ParseUint(value, 10, 64); ok && err == nil {
+			rounds = int(min(max(n, sha512MinRounds), sha512MaxRounds))
+			prefix = "rounds=" + strconv.Itoa(rounds) + "$"
+			salt = rest
+		}
+	}
+	if salt == "" {
+		var err error
+		if salt, err = RandomString(16, cryptAlphabet); err != nil {
+			return "", err
+		}
+	}
+	if len(salt) > 16 {
+		salt = salt[:16]
+	}
+
+	p, s := []byte(password), []byte(salt)
+
+	alt := sha512.New()
+	alt.Write(p)
+	alt.Write(s)
+	alt.Write(p)
+	altSum := alt.Sum(nil)
+
+	a := sha512.New()
+	a.Write(p)
+	a.Write(s)
+	i := len(p)
+	for ; i > 64; i -= 64 {
+		a.Write(altSum)
+	}
+	a.Write(altSum[:i])
+	for i = len(p); i > 0; i >>= 1 {
+		if i&1 != 0 {
+			a.Write(altSum)
+		} else {
+			a.Write(p)
+		}
+	}
+	sum := a.Sum(nil)
+
+	dp := sha512.New()
+	for range p {
+		dp.Write(p)
+	}
+	pSeq := repeatBytes(dp.Sum(nil), len(p))
+
+	ds := sha512.New()
+	for j := 0; j < 16+int(sum[0]); j++ {
+		ds.Write(s)
+	}
+	sSeq := repeatBytes(ds.Sum(nil), len(s))
+
+	for r := 0; r < rounds; r++ {
+		c := sha512.New()
+		if r&1 != 0 {
+			c.Write(pSeq)
+		} else {
+			c.Write(sum)
+		}
+		if r%3 != 0 {
+			c.Write(sSeq)
+		}
+		if r%7 != 0 {
+			c.Write(pSeq)
+		}
+		if r&1 != 0 {
+			c.Write(sum)
+		} else {
+			c.Write(pSeq)
+		}
+		sum = c.Sum(nil)
+	}
+
+	order := [][3]int{
+		{0, 21, 42}, {22, 43, 1}, {44, 2, 23}, {3, 24, 45}, {25, 46, 4},
+		{47, 5, 26}, {6, 27, 48}, {28, 49, 7}, {50, 8, 29}, {9, 30, 51},
+		{31, 52, 10}, {53, 11, 32}, {12, 33, 54}, {34, 55, 13}, {56, 14, 35},
+		{15, 36, 57}, {37, 58, 16}, {59, 17, 38}, {18, 39, 60}, {40, 61, 19},
+		{62, 20, 41},
+	}
+	encoded := make([]byte, 0, 86)
+	for _, o := range order {
+		encoded = appendCrypt64(encoded, sum[o[0]], sum[o[1]], sum[o[2]], 4)
+	}
+	encoded = appendCrypt64(encoded, 0, 0, sum[63], 2)
+
+	return "$6$" + prefix + salt + "$" + string(encoded), nil
+}
+
+// MD5Crypt 生成 $1$ 格式的密码哈希，用于不支持 SHA-512 crypt 的旧发行版
+func MD5Crypt(password, salt string) (string, error) {
+	if salt == "" {
+		var err error
+		if salt, err = RandomString(8, cryptAlphabet); err != nil {
+			return "", err
+		}
+	}
+	if len(salt) > 8 {
+		salt = salt[:8]
+	}
+
+	p, s := []byte(password), []byte(salt)
+
+	alt := md5.New()
+	alt.Write(p)
+	alt.Write(s)
+	alt.Write(p)
+	altSum := alt.Sum(nil)
+
+	a := md5.New()
+	a.Write(p)
+	a.Write([]byte("$1$"))
+	a.Write(s)
+	for i := len(p); i > 0; i -= 16 {
+		if i > 16 {
+			a.Write(altSum)
+		} else {
+			a.Write(altSum[:i])
+		}
+	}
+	for i := len(p); i > 0; i >>= 1 {
+		if i&1 != 0 {
+			a.Write([]byte{0})
+		} else {
+			a.Write(p[:1])
+		}
+	}
+	sum := a.Sum(nil)
+
+	for r := 0; r < 1000; r++ {
+		c := md5.New()
+		if r&1 != 0 {
+			c.Write(p)
+		} else {
+			c.Write(sum)
+		}
+		if r%3 != 0 {
+			c.Write(s)
+		}
+		if r%7 != 0 {
+			c.Write(p)
+		}
+		if r&1 != 0 {
+			c.Write(sum)
+		} else {
+			c.Write(p)
+		}
+		sum = c.Sum(nil)
+	}
+
+	order := [][3]int{{0, 6, 12}, {1, 7, 13}, {2, 8, 14}, {3, 9, 15}, {4, 10, 5}}
+	encoded := make([]byte, 0, 22)
+	for _, o := range order {
+		encoded = appendCrypt64(encoded, sum[o[0]], sum[o[1]], sum[o[2]], 4)
+	}
+	encoded = appendCrypt64(encoded, 0, 0, sum[11], 2)
+
+	return "$1$" + salt + "$" + string(encoded), nil
+}
+
+// repeatBytes 重复 digest 直到长度为 n
+func repeatBytes(digest []byte, n int) []byte {
+	out := make([]byte, 0, n)
+	for len(out)+len(digest) <= n {
+		out = append(out, digest...)
+	}
+	return append(out, digest[:n-len(out)]...)
+}
+
+// appendCrypt64 将 3 字节按 crypt(3) base64 编码为 n 个字符
+func appendCrypt64(dst []byte, b2, b1, b0 byte, n int) []byte {
+	w := uint(b2)<<16 | uint(b1)<<8 | uint(b0)
+	for i := 0; i < n; i++ {
+		dst = append(dst, cryptAlphabet[w&0x3f])
+		w >>= 6
+	}
+	return dst
+}
@@ -0,0 +1,75 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+// 期望值取自 SHA-crypt 规范的测试向量及 glibc crypt(3) 的输出
+func TestSHA512Crypt(t *testing.T) {
+	tests := []struct {
+		password, salt, want string
+	}{
+		{"Hello world!", "saltstring",
+			"$6$saltstring$svn8UoSVapNtMuq1ukKS4tPQd8iKwSMHWjl/O817G3uBnIFNjnQJuesI68u4OTLiBFdcbYEdFCoEOfaS35inz1"},
+		{"", "salt",
+			"$6$salt$r6qPcj2UeIkfklWHvleGJk8OKTInFYR/fxyuwcC656IWiZBpIFZ9.hMRG2ZQnnyMFrKOe461f9iT9Ljn0wJ5l."},
+		// salt 截断为 16 个字符
+		{"This is just a test", "toolongsaltstringXYZ",
+			"$6$toolongsaltstrin$lQ8jolhgVRVhY4b5pZKaysCLi0QBxGoNeKQzQ3glMhwllF7oGDZxUhx1yxdYcz/e1JSbq3y6JMxxl8audkUEm0"},
+		{"Hello world!", "rounds=10000$saltstringsaltstring",
+			"$6$rounds=10000$saltstringsaltst$OW1/O6BYHV6BcXZu8QVeXbDWra3Oeqh0sbHbbMCVNSnCM/UrjmM0Dp8vOuZeHBy/YTBmSK6H9qs/y3RnOaw5v."},
+		{"This is just a test", "rounds=5000$toolongsaltstring",
+			"$6$rounds=5000$toolongsaltstrin$lQ8jolhgVRVhY4b5pZKaysCLi0QBxGoNeKQzQ3glMhwllF7oGDZxUhx1yxdYcz/e1JSbq3y6JMxxl8audkUEm0"},
+		{"a short string", "rounds=123456$asaltof16chars..",
+			"$6$rounds=123456$asaltof16chars..$BtCwjqMJGx5hrJhZywWvt0RLE8uZ4oPwcelCjmw2kSYu.Ec6ycULevoBK25fs2xXgMNrCzIMVcgEJAstJeonj1"},
+		// 轮数不足 1000 时按 1000 计算
+		{"the minimum number is still observed", "rounds=10$roundstoolow",
+			"$6$rounds=1000$roundstoolow$kUMsbe306n21p9R.FRkW3IGn.S9NPN0x50YhH1xhLsPuWGsUSklZt58jaTfF4ZEQpyUNGc0dqbpBYYBaHHrsX."},
+	}
+	for _, tt := range tests {
+		got, err := SHA512Crypt(tt.password, tt.salt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("SHA512Crypt(%q, %q) = %s, want %s", tt.password, tt.salt, got, tt.want)
+		}
+	}
+}
+
+func TestMD5Crypt(t *testing.T) {
+	tests := []struct {
+		password, salt, want string
+	}{
+		{"password", "abc", "$1$abc$BXBqpb9BZcZhXLgbee.0s/"},
+		// salt 截断为 8 个字符
+		{"Hello world!", "saltstring", "$1$saltstri$YMyguxXMBpd2TEZ.vS/3q1"},
+		{"password", "abcdefghijk", "$1$abcdefgh$G//4keteveJp0qb8z2DxG/"},
+	}
+	for _, tt := range tests {
+		got, err := MD5Crypt(tt.password, tt.salt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("MD5Crypt(%q, %q) = %s, want %s", tt.password, tt.salt, got, tt.want)
+		}
+	}
+}
+
+func TestCryptRandomSalt(t *testing.T) {
+	for _, crypt := range []func(string, string) (string, error){SHA512Crypt, MD5Crypt} {
+		a, err := crypt("secret", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := crypt("secret", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if a == b || strings.Count(a, "$") != 3 {
+			t.Errorf("random salt: %s, %s", a, b)
+		}
+	}
+}
@@ -2,35 +2,82 @@ package utils
 
 import (
 	"bufio"
+	"bytes"
+	"context"
 	"fmt"
 	"io"
+	"log/slog"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/rivsidn/kdev_bootstrap/pkg/audit"
+	"github.com/rivsidn/kdev_bootstrap/pkg/diagnose"
+	"github.com/rivsidn/kdev_bootstrap/pkg/logging"
 )
 
+// KillGrace 取消命令后等待进程组退出的时间，超时后发送 SIGKILL
+const KillGrace = 10 * time.Second
+
 func RunCommand(name string, args ...string) error {
-	cmd := exec.Command(name, args...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	cmd.Stdin = os.Stdin
+	return RunCommandContext(context.Background(), name, args...)
+}
+
+// RunCommandContext 执行命令，ctx 取消或超时时终止命令所在的进程组
+func RunCommandContext(ctx context.Context, name string, args ...string) error {
+	return RunCommandTeeContext(ctx, io.Discard, name, args...)
+}
+
+func RunCommandOutput(name string, args ...string) (string, error) {
+	return RunCommandOutputContext(context.Background(), name, args...)
+}
 
-	fmt.Printf("Executing command: %s %s\n", name, strings.Join(args, " "))
+// RunCommandOutputContext 执行命令并返回标准输出及标准错误
+func RunCommandOutputContext(ctx context.Context, name string, args ...string) (string, error) {
+	cmd := commandContext(ctx, name, args...)
+	var output bytes.Buffer
+	stdout, stderr, flush := logging.CommandOutput(name, false)
+	cmd.Stdout = io.MultiWriter(&output, stdout)
+	cmd.Stderr = io.MultiWriter(&output, stderr)
 
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("command execution failed %s: %v", name, err)
+	slog.Debug("Executing command", logging.CommandKey, commandLine(name, args))
+	err := runCommand(ctx, cmd)
+	flush()
+	if err != nil {
+		err = fmt.Errorf("command execution failed %s: %v\noutput: %s", name, err, output.String())
+		return "", diagnose.Classify("", err)
 	}
+	return output.String(), nil
+}
 
-	return nil
+// commandContext 创建在独立进程组中运行的命令
+//
+// 命令启动的子进程（如 debootstrap 调用的 wget、dpkg）在同一进程组中，取消时一并终止；
+// 终端的 Ctrl-C 只发送给 kboot，由 kboot 取消 ctx 后清理。
+func commandContext(ctx context.Context, name string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGTERM)
+	}
+	cmd.WaitDelay = KillGrace
+	return cmd
 }
 
-func RunCommandOutput(name string, args ...string) (string, error) {
-	cmd := exec.Command(name, args...)
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return "", fmt.Errorf("command execution failed %s: %v\noutput: %s", name, err, string(output))
+// runCommand 执行命令并记录到审计日志，取消后仍未退出的进程组成员被强制终止
+func runCommand(ctx context.Context, cmd *exec.Cmd) error {
+	start := time.Now()
+	err := cmd.Run()
+	audit.Command(cmd.Args, start, err)
+	if ctx.Err() != nil && cmd.Process != nil {
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		return ctx.Err()
 	}
-	return string(output), nil
+	return err
 }
 
 func CheckCommand(name string) bool {
@@ -98,3 +145,127 @@ func CopyFile(src, dst string) error {
 	return nil
 }
 
+// RunCommandWithLog 执行命令，输出同时写入终端和日志
+func RunCommandWithLog(log io.Writer, name string, args ...string) error {
+	return RunCommandWithLogContext(context.Background(), log, name, args...)
+}
+
+// RunCommandWithLogContext 执行命令，输出同时写入终端和日志，ctx 取消时终止命令所在的进程组
+func RunCommandWithLogContext(ctx context.Context, log io.Writer, name string, args ...string) error {
+	fmt.Fprintf(log, "+ %s %s\n", name, strings.Join(args, " "))
+
+	if err := RunCommandTeeContext(ctx, log, name, args...); err != nil {
+		fmt.Fprintf(log, "%v\n", err)
+		return err
+	}
+
+	return nil
+}
+
+// RunCommandTeeContext 执行命令，标准输出及标准错误同时写入终端、日志和 w
+func RunCommandTeeContext(ctx context.Context, w io.Writer, name string, args ...string) error {
+	cmd := commandContext(ctx, name, args...)
+	stdout, stderr, flush := logging.CommandOutput(name, true)
+	tail := &tailBuffer{}
+	cmd.Stdout = io.MultiWriter(stdout, w, tail)
+	cmd.Stderr = io.MultiWriter(stderr, w, tail)
+
+	slog.Info("Executing command", logging.CommandKey, commandLine(name, args))
+	err := runCommand(ctx, cmd)
+	flush()
+	if err != nil {
+		err = diagnose.Classify(tail.String(), fmt.Errorf("command execution failed %s: %v", name, err))
+		slog.Debug(err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// tailSize 命令失败时用于识别原因的输出长度
+const tailSize = 64 * 1024
+
+// tailBuffer 保留命令最后 tailSize 字节的输出，标准输出及标准错误并发写入
+type tailBuffer struct {
+	mu  sync.Mutex
+	buf []byte
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.buf = append(t.buf, p...)
+	if len(t.buf) > 2*tailSize {
+		t.buf = append(t.buf[:0], t.buf[len(t.buf)-tailSize:]...)
+	}
+	return len(p), nil
+}
+
+func (t *tailBuffer) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(t.buf) > tailSize {
+		return string(t.buf[len(t.buf)-tailSize:])
+	}
+	return string(t.buf)
+}
+
+// commandLine 返回记录到日志的命令行
+func commandLine(name string, args []string) string {
+	return strings.TrimSpace(name + " " + strings.Join(args, " "))
+}
+
+// IsMounted 判断路径是否为挂载点
+func IsMounted(path string) bool {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+
+	for _, mountPoint := range MountPoints() {
+		if mountPoint == absPath {
+			return true
+		}
+	}
+	return false
+}
+
+// MountPoints 返回当前所有挂载点
+func MountPoints() []string {
+	data, err := os.ReadFile("/proc/self/mounts")
+	if err != nil {
+		return nil
+	}
+
+	var mountPoints []string
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		mountPoints = append(mountPoints, unescapeMountPath(fields[1]))
+	}
+	return mountPoints
+}
+
+// unescapeMountPath 还原 /proc/mounts 中八进制转义的空白字符
+func unescapeMountPath(path string) string {
+	replacer := strings.NewReplacer(`\040`, " ", `\011`, "\t", `\012`, "\n", `\134`, `\`)
+	return replacer.Replace(path)
+}
+
+// FormatBytes 以易读的单位（KiB、MiB 等）显示字节数
+func FormatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}